@@ -23,16 +23,14 @@ import (
 	"os/exec"
 	"os/user"
 	"path/filepath"
+	"strings"
 
-	"stash.appscode.dev/apimachinery/apis"
 	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	"stash.appscode.dev/stash/pkg/util"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"gomodules.xyz/flags"
-	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/rest"
@@ -40,10 +38,14 @@ import (
 )
 
 type keyOptions struct {
-	config    *rest.Config
-	repo      *v1alpha1.Repository
-	localDirs cliLocalDirectories
+	config     *rest.Config
+	repo       *v1alpha1.Repository
+	localDirs  cliLocalDirectories
+	scratchDir string
 	restic.KeyOptions
+
+	newPasswordSecret string
+	newPassword       string
 }
 
 func NewCmdAddKey(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
@@ -53,23 +55,16 @@ func NewCmdAddKey(clientGetter genericclioptions.RESTClientGetter) *cobra.Comman
 		Short:             `Add a new key (password) to a restic repository`,
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			flags.EnsureRequiredFlags(cmd, "new-password-file")
-			var err error
-			opt.File, err = filepath.Abs(opt.File)
-			if err != nil {
-				return fmt.Errorf("failed to find the absolute path for password file: %w", err)
-			}
-
-			_, err = os.Stat(opt.File)
-			if os.IsNotExist(err) {
-				return fmt.Errorf("%s does not exist", opt.File)
-			}
-
 			if len(args) == 0 || args[0] == "" {
 				return fmt.Errorf("repository name not found")
 			}
 			repositoryName := args[0]
 
+			var err error
+			if err = opt.readNewPassword(); err != nil {
+				return err
+			}
+
 			opt.config, err = clientGetter.ToRESTConfig()
 			if err != nil {
 				return errors.Wrap(err, "failed to read kubeconfig")
@@ -92,6 +87,7 @@ func NewCmdAddKey(clientGetter genericclioptions.RESTClientGetter) *cobra.Comman
 	cmd.Flags().StringVar(&opt.Host, "host", opt.Host, "Host for the new key")
 	cmd.Flags().StringVar(&opt.User, "user", opt.User, "Username for the new key")
 	cmd.Flags().StringVar(&opt.File, "new-password-file", opt.File, "File from which to read the new password")
+	cmd.Flags().StringVar(&opt.newPasswordSecret, "new-password-secret", opt.newPasswordSecret, "Secret key, in name/key format, from which to read the new password")
 
 	return cmd
 }
@@ -103,13 +99,9 @@ func (opt *keyOptions) addResticKeyForLocalRepo() error {
 		return err
 	}
 
-	if err := opt.copyPasswordFileToPod(pod); err != nil {
-		return fmt.Errorf("failed to copy password file from local directory to pod: %w", err)
-	}
-
 	command := []string{"/stash-enterprise", "add-key"}
 	command = append(command, "--repo-name="+opt.repo.Name, "--repo-namespace="+opt.repo.Namespace)
-	command = append(command, "--new-password-file="+getPodDirForPasswordFile())
+	command = append(command, "--new-password-file=/dev/stdin")
 
 	if opt.User != "" {
 		command = append(command, "--user="+opt.User)
@@ -118,40 +110,15 @@ func (opt *keyOptions) addResticKeyForLocalRepo() error {
 		command = append(command, "--host="+opt.Host)
 	}
 
-	_, err = execCommandOnPod(kubeClient, opt.config, pod, command)
+	_, err = execCommandOnPodWithStdin(kubeClient, opt.config, pod, command, strings.NewReader(opt.newPassword))
 	if err != nil {
 		return err
 	}
 
-	if err := opt.removePasswordFileFromPod(pod); err != nil {
-		return fmt.Errorf("failed to remove password file from pod: %w", err)
-	}
-
 	klog.Infof("Restic key has been added successfully for repository %s/%s", opt.repo.Namespace, opt.repo.Name)
 	return nil
 }
 
-func (opt *keyOptions) removePasswordFileFromPod(pod *core.Pod) error {
-	cmd := []string{"rm", "-rf", getPodDirForPasswordFile()}
-	out, err := execCommandOnPod(kubeClient, opt.config, pod, cmd)
-	if string(out) != "" {
-		klog.Infoln("Output:", string(out))
-	}
-	return err
-}
-
-func (opt *keyOptions) copyPasswordFileToPod(pod *core.Pod) error {
-	_, err := exec.Command(cmdKubectl, "cp", opt.File, fmt.Sprintf("%s/%s:%s", pod.Namespace, pod.Name, getPodDirForPasswordFile())).CombinedOutput()
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func getPodDirForPasswordFile() string {
-	return filepath.Join(apis.ScratchDirMountPath, passwordFile)
-}
-
 func (opt *keyOptions) addResticKey() error {
 	// get source repository secret
 	secret, err := kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), opt.repo.Spec.Backend.StorageSecretName, metav1.GetOptions{})
@@ -159,15 +126,17 @@ func (opt *keyOptions) addResticKey() error {
 		return err
 	}
 
-	if err = os.MkdirAll(ScratchDir, 0o755); err != nil {
+	// keep the dumped credentials in a private directory of this invocation
+	opt.scratchDir, err = newScratchDir()
+	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(ScratchDir)
+	defer removeScratchDir(opt.scratchDir)
 
 	// configure restic wrapper
 	extraOpt := util.ExtraOptions{
 		StorageSecret: secret,
-		ScratchDir:    ScratchDir,
+		ScratchDir:    opt.scratchDir,
 	}
 	// configure setupOption
 	setupOpt, err := util.SetupOptionsForRepository(*opt.repo, extraOpt)
@@ -181,7 +150,7 @@ func (opt *keyOptions) addResticKey() error {
 	}
 
 	opt.localDirs = cliLocalDirectories{
-		configDir: filepath.Join(ScratchDir, configDirName),
+		configDir: filepath.Join(opt.scratchDir, configDirName),
 	}
 
 	// dump restic's environments into `restic-env` file.
@@ -221,21 +190,22 @@ func manageKeyViaDocker(opt *keyOptions, args []string) error {
 		"run",
 		"--rm",
 		"-u", currentUser.Uid,
-		"-v", ScratchDir + ":" + ScratchDir,
+		"-v", opt.scratchDir + ":" + opt.scratchDir,
 		"--env", "HTTP_PROXY=" + os.Getenv("HTTP_PROXY"),
 		"--env", "HTTPS_PROXY=" + os.Getenv("HTTPS_PROXY"),
 		"--env-file", filepath.Join(opt.localDirs.configDir, ResticEnvs),
 	}
 
-	if opt.File != "" {
-		keyArgs = append(keyArgs, "-v", opt.File+":"+opt.File)
+	// the new password is streamed through stdin so that it is never written to the disk
+	if opt.newPassword != "" {
+		keyArgs = append(keyArgs, "-i")
 	}
 
 	keyArgs = append(keyArgs, imgRestic.ToContainerImage())
 	keyArgs = append(keyArgs, args...)
 
-	if opt.File != "" {
-		keyArgs = append(keyArgs, "--new-password-file", opt.File)
+	if opt.newPassword != "" {
+		keyArgs = append(keyArgs, "--new-password-file", "/dev/stdin")
 	}
 
 	if opt.User != "" {
@@ -248,7 +218,11 @@ func manageKeyViaDocker(opt *keyOptions, args []string) error {
 
 	klog.Infoln("Running docker with args:", keyArgs)
 
-	out, err := exec.Command("docker", keyArgs...).CombinedOutput()
+	cmd := exec.Command("docker", keyArgs...)
+	if opt.newPassword != "" {
+		cmd.Stdin = strings.NewReader(opt.newPassword)
+	}
+	out, err := cmd.CombinedOutput()
 	if err != nil {
 		klog.Infoln("Output:", string(out))
 		return err
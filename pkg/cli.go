@@ -23,6 +23,7 @@ import (
 	"stash.appscode.dev/apimachinery/pkg/docker"
 
 	vs_cs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
 )
@@ -32,7 +33,6 @@ const (
 	ScratchDir     = "/tmp/scratch"
 	DestinationDir = "/tmp/destination"
 	configDirName  = "config"
-	passwordFile   = "password.txt"
 
 	ResticEnvs     = "restic-envs"
 	ResticRegistry = "restic"
@@ -57,10 +57,31 @@ var (
 	vsClient    *vs_cs.Clientset
 	imgRestic   docker.Docker
 
+	// clients of the cluster where the copy commands write into
+	dstKubeClient  *kubernetes.Clientset
+	dstStashClient *cs.Clientset
+	dstVsClient    *vs_cs.Clientset
+	// dynamic clients used by "cp all" to copy objects of any kind
+	dynamicClient    dynamic.Interface
+	dstDynamicClient dynamic.Interface
+	// overwrite allows the copy commands to patch conflicting objects in the destination
+	overwrite bool
+	// labels and annotations matching these keys are not copied
+	stripLabels      = defaultStripLabels
+	stripAnnotations = defaultStripAnnotations
+	// copyAsPaused pauses the copied BackupConfigurations and BackupBatches
+	copyAsPaused bool
+
 	backupConfig   string
 	backupBatch    string
 	restoreSession string
 	restoreBatch   string
+
+	// backupSelector and allNamespaces select the BackupConfigurations and BackupBatches to pause or resume
+	backupSelector string
+	allNamespaces  bool
+	// resumeExpired resumes only the backups whose pause window has passed
+	resumeExpired bool
 )
 
 func init() {
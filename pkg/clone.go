@@ -19,6 +19,7 @@ package pkg
 import (
 	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
 
+	vs_cs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
@@ -51,10 +52,17 @@ func NewCmdClone(clientGetter genericclioptions.RESTClientGetter) *cobra.Command
 				return err
 			}
 
+			vsClient, err = vs_cs.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
+			dstKubeClient, dstStashClient, dstVsClient = kubeClient, stashClient, vsClient
 			return nil
 		},
 	}
 	cmd.AddCommand(NewCmdClonePVC())
+	cmd.AddCommand(NewCmdCloneWorkload())
 
 	cmd.PersistentFlags().StringVar(&dstNamespace, "to-namespace", dstNamespace, "Destination namespace.")
 
@@ -0,0 +1,270 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	core_util "kmodules.xyz/client-go/core/v1"
+)
+
+const (
+	cloneJournalLabelKey = "stash.appscode.com/clone-journal"
+	cloneJournalDataKey  = "journal"
+	cloneJournalPrefix   = "stash-clone-"
+
+	kindVolumeSnapshot        = "VolumeSnapshot"
+	kindVolumeSnapshotContent = "VolumeSnapshotContent"
+)
+
+// cloneJournal records the progress of a clone so that it can be rolled back or resumed.
+// It is stored in a ConfigMap in the source namespace.
+type cloneJournal struct {
+	ID           string           `json:"id"`
+	PVCs         []string         `json:"pvcs"`
+	SrcNamespace string           `json:"srcNamespace"`
+	DstNamespace string           `json:"dstNamespace"`
+	Method       string           `json:"method"`
+	Snapshots    []cloneSnapshot  `json:"snapshots,omitempty"`
+	Repository   *cloneRepository `json:"repository,omitempty"`
+	Overrides    *pvcOverrides    `json:"overrides,omitempty"`
+	Workload     *clonedResource  `json:"workload,omitempty"`
+	Completed    []string         `json:"completedSteps,omitempty"`
+	Created      []clonedResource `json:"createdResources,omitempty"`
+}
+
+type cloneSnapshot struct {
+	PVC   string `json:"pvc"`
+	Name  string `json:"name"`
+	Class string `json:"class"`
+}
+
+type cloneRepository struct {
+	Name           string `json:"name"`
+	Provider       string `json:"provider"`
+	Bucket         string `json:"bucket,omitempty"`
+	Endpoint       string `json:"endpoint,omitempty"`
+	MaxConnections int64  `json:"maxConnections,omitempty"`
+	Secret         string `json:"secret,omitempty"`
+	Prefix         string `json:"prefix,omitempty"`
+	Region         string `json:"region,omitempty"`
+	InsecureTLS    bool   `json:"insecureTLS,omitempty"`
+	PVC            string `json:"pvc,omitempty"`
+	NFSServer      string `json:"nfsServer,omitempty"`
+	NFSPath        string `json:"nfsPath,omitempty"`
+	HostPath       string `json:"hostPath,omitempty"`
+	MountPath      string `json:"mountPath,omitempty"`
+	SubPath        string `json:"subPath,omitempty"`
+}
+
+type clonedResource struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name"`
+}
+
+type cloneStep struct {
+	name string
+	run  func() error
+}
+
+func newCloneRepository(name string, opt repositoryOption) *cloneRepository {
+	return &cloneRepository{
+		Name:           name,
+		Provider:       opt.provider,
+		Bucket:         opt.bucket,
+		Endpoint:       opt.endpoint,
+		MaxConnections: opt.maxConnections,
+		Secret:         opt.secret,
+		Prefix:         opt.prefix,
+		Region:         opt.region,
+		InsecureTLS:    opt.insecureTLS,
+		PVC:            opt.pvc,
+		NFSServer:      opt.nfsServer,
+		NFSPath:        opt.nfsPath,
+		HostPath:       opt.hostPath,
+		MountPath:      opt.mountPath,
+		SubPath:        opt.subPath,
+	}
+}
+
+func (r *cloneRepository) option() repositoryOption {
+	return repositoryOption{
+		provider:       r.Provider,
+		bucket:         r.Bucket,
+		endpoint:       r.Endpoint,
+		maxConnections: r.MaxConnections,
+		secret:         r.Secret,
+		prefix:         r.Prefix,
+		region:         r.Region,
+		insecureTLS:    r.InsecureTLS,
+		pvc:            r.PVC,
+		nfsServer:      r.NFSServer,
+		nfsPath:        r.NFSPath,
+		hostPath:       r.HostPath,
+		mountPath:      r.MountPath,
+		subPath:        r.SubPath,
+	}
+}
+
+func loadCloneJournal(ns, id string) (*cloneJournal, error) {
+	cm, err := kubeClient.CoreV1().ConfigMaps(ns).Get(context.TODO(), cloneJournalPrefix+id, metav1.GetOptions{})
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			return nil, fmt.Errorf("no clone with id %q found in namespace %s", id, ns)
+		}
+		return nil, err
+	}
+	j := &cloneJournal{}
+	if err := json.Unmarshal([]byte(cm.Data[cloneJournalDataKey]), j); err != nil {
+		return nil, fmt.Errorf("failed to parse the journal of clone %q: %w", id, err)
+	}
+	return j, nil
+}
+
+func (j *cloneJournal) save() error {
+	data, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+	meta := metav1.ObjectMeta{
+		Name:      cloneJournalPrefix + j.ID,
+		Namespace: j.SrcNamespace,
+		Labels:    map[string]string{cloneJournalLabelKey: "true"},
+	}
+	_, _, err = core_util.CreateOrPatchConfigMap(context.TODO(), kubeClient, meta, func(in *core.ConfigMap) *core.ConfigMap {
+		in.Data = map[string]string{cloneJournalDataKey: string(data)}
+		return in
+	}, metav1.PatchOptions{})
+	return err
+}
+
+func (j *cloneJournal) delete() error {
+	err := kubeClient.CoreV1().ConfigMaps(j.SrcNamespace).Delete(context.TODO(), cloneJournalPrefix+j.ID, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// track records a resource before it is created so that it is removed on rollback even if the step fails halfway.
+func (j *cloneJournal) track(kind, ns, name string) error {
+	if j.tracked(kind, ns, name) {
+		return nil
+	}
+	j.Created = append(j.Created, clonedResource{Kind: kind, Namespace: ns, Name: name})
+	return j.save()
+}
+
+func (j *cloneJournal) tracked(kind, ns, name string) bool {
+	for _, r := range j.Created {
+		if r.Kind == kind && r.Namespace == ns && r.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (j *cloneJournal) completed(step string) bool {
+	for _, s := range j.Completed {
+		if s == step {
+			return true
+		}
+	}
+	return false
+}
+
+// execute runs the steps that have not been completed yet. If a step fails, the created resources
+// are rolled back unless keepOnFailure is set, in which case the clone can be resumed later.
+func (j *cloneJournal) execute(steps []cloneStep, keepOnFailure bool) error {
+	for _, step := range steps {
+		if j.completed(step.name) {
+			klog.Infof("Skipping step %q as it has been completed already.", step.name)
+			continue
+		}
+		klog.Infof("Running step %q of clone %s", step.name, j.ID)
+		if err := step.run(); err != nil {
+			err = fmt.Errorf("step %q of clone %s failed: %w", step.name, j.ID, err)
+			if keepOnFailure {
+				klog.Infof("Resources of the clone have been kept. Run \"clone pvc --resume %s -n %s\" to continue from the failed step.", j.ID, j.SrcNamespace)
+				return err
+			}
+			if rbErr := j.rollback(); rbErr != nil {
+				return fmt.Errorf("%v. Rollback also failed: %v", err, rbErr)
+			}
+			return err
+		}
+		j.Completed = append(j.Completed, step.name)
+		if err := j.save(); err != nil {
+			return err
+		}
+	}
+	return j.delete()
+}
+
+// rollback deletes the created resources in the reverse order of their creation.
+func (j *cloneJournal) rollback() error {
+	klog.Infof("Rolling back clone %s", j.ID)
+	for i := len(j.Created) - 1; i >= 0; i-- {
+		r := j.Created[i]
+		if err := deleteClonedResource(r); err != nil && !kerr.IsNotFound(err) {
+			return fmt.Errorf("failed to delete %s %s/%s: %w", r.Kind, r.Namespace, r.Name, err)
+		}
+		klog.Infof("Deleted %s %s/%s", r.Kind, r.Namespace, r.Name)
+	}
+	return j.delete()
+}
+
+func deleteClonedResource(r clonedResource) error {
+	ctx := context.TODO()
+	opts := metav1.DeleteOptions{}
+	switch r.Kind {
+	case v1alpha1.ResourceKindRepository:
+		return stashClient.StashV1alpha1().Repositories(r.Namespace).Delete(ctx, r.Name, opts)
+	case v1beta1.ResourceKindBackupConfiguration:
+		return stashClient.StashV1beta1().BackupConfigurations(r.Namespace).Delete(ctx, r.Name, opts)
+	case v1beta1.ResourceKindBackupBatch:
+		return stashClient.StashV1beta1().BackupBatches(r.Namespace).Delete(ctx, r.Name, opts)
+	case v1beta1.ResourceKindRestoreSession:
+		return stashClient.StashV1beta1().RestoreSessions(r.Namespace).Delete(ctx, r.Name, opts)
+	case v1beta1.ResourceKindRestoreBatch:
+		return stashClient.StashV1beta1().RestoreBatches(r.Namespace).Delete(ctx, r.Name, opts)
+	case "Secret":
+		return kubeClient.CoreV1().Secrets(r.Namespace).Delete(ctx, r.Name, opts)
+	case "PersistentVolumeClaim":
+		return kubeClient.CoreV1().PersistentVolumeClaims(r.Namespace).Delete(ctx, r.Name, opts)
+	case apis.KindStatefulSet:
+		return dstKubeClient.AppsV1().StatefulSets(r.Namespace).Delete(ctx, r.Name, opts)
+	case apis.KindDeployment:
+		return dstKubeClient.AppsV1().Deployments(r.Namespace).Delete(ctx, r.Name, opts)
+	case kindVolumeSnapshot:
+		return vsClient.SnapshotV1().VolumeSnapshots(r.Namespace).Delete(ctx, r.Name, opts)
+	case kindVolumeSnapshotContent:
+		return vsClient.SnapshotV1().VolumeSnapshotContents().Delete(ctx, r.Name, opts)
+	}
+	return fmt.Errorf("unknown kind %s", r.Kind)
+}
@@ -0,0 +1,215 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// pvcOverrides holds the fields of the destination PVC that differ from the source PVC.
+type pvcOverrides struct {
+	Name         string   `json:"name,omitempty"`
+	StorageClass string   `json:"storageClass,omitempty"`
+	Size         string   `json:"size,omitempty"`
+	AccessModes  []string `json:"accessModes,omitempty"`
+}
+
+func addPVCOverrideFlags(cmd *cobra.Command, o *pvcOverrides) {
+	cmd.Flags().StringVar(&o.Name, "name", o.Name, "Name of the destination PVC. Defaults to the name of the source PVC")
+	cmd.Flags().StringVar(&o.StorageClass, "storage-class", o.StorageClass, "StorageClass of the destination PVC. Defaults to the StorageClass of the source PVC")
+	cmd.Flags().StringVar(&o.Size, "size", o.Size, "Requested size of the destination PVC (i.e. 10Gi). Defaults to the size of the source PVC")
+	cmd.Flags().StringSliceVar(&o.AccessModes, "access-modes", o.AccessModes, "Access modes of the destination PVC. Defaults to the access modes of the source PVC")
+}
+
+func (o *pvcOverrides) isEmpty() bool {
+	return o == nil || (o.Name == "" && o.StorageClass == "" && o.Size == "" && len(o.AccessModes) == 0)
+}
+
+// apply returns the destination PVC built from the source PVC.
+func (o *pvcOverrides) apply(pvc *core.PersistentVolumeClaim) (*core.PersistentVolumeClaim, error) {
+	out := pvc.DeepCopy()
+	if o == nil {
+		return out, nil
+	}
+	if o.Name != "" {
+		out.Name = o.Name
+	}
+	if o.StorageClass != "" {
+		out.Spec.StorageClassName = &o.StorageClass
+	}
+	if o.Size != "" {
+		size, err := resource.ParseQuantity(o.Size)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size %q: %w", o.Size, err)
+		}
+		if out.Spec.Resources.Requests == nil {
+			out.Spec.Resources.Requests = core.ResourceList{}
+		}
+		out.Spec.Resources.Requests[core.ResourceStorage] = size
+	}
+	if len(o.AccessModes) > 0 {
+		out.Spec.AccessModes = getPVAccessModes(o.AccessModes)
+	}
+	return out, nil
+}
+
+func (o *pvcOverrides) destinationName(name string) string {
+	if o != nil && o.Name != "" {
+		return o.Name
+	}
+	return name
+}
+
+// validate checks the overrides against the source PVCs. The requested size must be able to hold the data
+// currently stored in each PVC.
+func (o *pvcOverrides) validate(pvcs []*core.PersistentVolumeClaim) error {
+	if o == nil {
+		return nil
+	}
+	if o.Name != "" && len(pvcs) > 1 {
+		return fmt.Errorf("--name can not be used when cloning multiple PVCs")
+	}
+	for _, am := range o.AccessModes {
+		switch core.PersistentVolumeAccessMode(am) {
+		case core.ReadWriteOnce, core.ReadOnlyMany, core.ReadWriteMany, core.ReadWriteOncePod:
+		default:
+			return fmt.Errorf("invalid access mode %q", am)
+		}
+	}
+	if o.StorageClass != "" {
+		if _, err := kubeClient.StorageV1().StorageClasses().Get(context.TODO(), o.StorageClass, metav1.GetOptions{}); err != nil {
+			return err
+		}
+	}
+	if o.Size == "" {
+		return nil
+	}
+	size, err := resource.ParseQuantity(o.Size)
+	if err != nil {
+		return fmt.Errorf("invalid size %q: %w", o.Size, err)
+	}
+	for _, pvc := range pvcs {
+		used, found, err := pvcUsedBytes(pvc)
+		if err != nil {
+			return err
+		}
+		if !found {
+			klog.Warningf("Could not determine the used size of PVC %s/%s as no running Pod mounts it. Make sure %s is large enough to hold its data.", pvc.Namespace, pvc.Name, o.Size)
+			continue
+		}
+		if size.Value() < used {
+			return fmt.Errorf("requested size %s is smaller than the %s of data stored in PVC %s/%s", o.Size, formatSize(used), pvc.Namespace, pvc.Name)
+		}
+	}
+	return nil
+}
+
+// snapshotIncompatibility returns the reason why the destination PVCs can not be provisioned from
+// VolumeSnapshots of the source PVCs, or an empty string if they can.
+func (o *pvcOverrides) snapshotIncompatibility(pvcs []*core.PersistentVolumeClaim) (string, error) {
+	if o == nil {
+		return "", nil
+	}
+	if o.StorageClass != "" {
+		target, err := kubeClient.StorageV1().StorageClasses().Get(context.TODO(), o.StorageClass, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		for _, pvc := range pvcs {
+			source, err := pvcStorageClass(pvc)
+			if err != nil {
+				return "", err
+			}
+			if source == nil || source.Provisioner != target.Provisioner {
+				return fmt.Sprintf("StorageClass %s uses a different provisioner than PVC %s/%s", target.Name, pvc.Namespace, pvc.Name), nil
+			}
+		}
+	}
+	if o.Size != "" {
+		size, err := resource.ParseQuantity(o.Size)
+		if err != nil {
+			return "", fmt.Errorf("invalid size %q: %w", o.Size, err)
+		}
+		for _, pvc := range pvcs {
+			if size.Cmp(pvc.Spec.Resources.Requests[core.ResourceStorage]) < 0 {
+				return fmt.Sprintf("a VolumeSnapshot can not be restored into a PVC smaller than the source PVC %s/%s", pvc.Namespace, pvc.Name), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// kubeletStatsSummary is the part of the kubelet stats summary API response that reports volume usage.
+type kubeletStatsSummary struct {
+	Pods []struct {
+		Volumes []struct {
+			UsedBytes *int64 `json:"usedBytes,omitempty"`
+			PVCRef    *struct {
+				Name      string `json:"name"`
+				Namespace string `json:"namespace"`
+			} `json:"pvcRef,omitempty"`
+		} `json:"volume,omitempty"`
+	} `json:"pods"`
+}
+
+// pvcUsedBytes reads the used size of the PVC from the stats summary of the kubelet running a Pod that mounts it.
+// It reports false if no running Pod mounts the PVC or the stats are not accessible.
+func pvcUsedBytes(pvc *core.PersistentVolumeClaim) (int64, bool, error) {
+	pods, err := kubeClient.CoreV1().Pods(pvc.Namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return 0, false, err
+	}
+	for _, pod := range pods.Items {
+		if pod.Status.Phase != core.PodRunning || pod.Spec.NodeName == "" || !podUsesPVC(&pod, pvc.Name) {
+			continue
+		}
+		data, err := kubeClient.CoreV1().RESTClient().Get().AbsPath("/api/v1/nodes", pod.Spec.NodeName, "proxy", "stats", "summary").DoRaw(context.TODO())
+		if err != nil {
+			klog.Warningf("Failed to read the stats summary of node %s: %v", pod.Spec.NodeName, err)
+			return 0, false, nil
+		}
+		var summary kubeletStatsSummary
+		if err = json.Unmarshal(data, &summary); err != nil {
+			return 0, false, err
+		}
+		for _, p := range summary.Pods {
+			for _, v := range p.Volumes {
+				if v.PVCRef != nil && v.PVCRef.Namespace == pvc.Namespace && v.PVCRef.Name == pvc.Name && v.UsedBytes != nil {
+					return *v.UsedBytes, true, nil
+				}
+			}
+		}
+	}
+	return 0, false, nil
+}
+
+func podUsesPVC(pod *core.Pod, claimName string) bool {
+	for _, vol := range pod.Spec.Volumes {
+		if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == claimName {
+			return true
+		}
+	}
+	return false
+}
@@ -19,15 +19,19 @@ package pkg
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"stash.appscode.dev/apimachinery/apis"
 	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
 
 	"github.com/spf13/cobra"
 	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	"k8s.io/kubectl/pkg/util/templates"
 	kmapi "kmodules.xyz/client-go/api/v1"
@@ -36,83 +40,312 @@ import (
 
 var cloneExample = templates.Examples(`
 		# Clone PVC
-		stash clone pvc source-pvc -n demo --to-namespace=demo1 --secret=<secret> --bucket=<bucket> --prefix=<prefix> --provider=<provider>`)
+		stash clone pvc source-pvc -n demo --to-namespace=demo1 --secret=<secret> --bucket=<bucket> --prefix=<prefix> --provider=<provider>
+
+		# Clone PVC using a CSI VolumeSnapshot
+		stash clone pvc source-pvc -n demo --to-namespace=demo1 --method=snapshot
+
+		# Clone all the PVCs matching a label selector
+		stash clone pvc -l app=db -n demo --to-namespace=demo1
+
+		# Keep the created resources if the clone fails and, resume it later
+		stash clone pvc source-pvc -n demo --to-namespace=demo1 --method=snapshot --keep-on-failure
+		stash clone pvc -n demo --resume source-pvc-1700000000`)
 
 func NewCmdClonePVC() *cobra.Command {
 	repoOpt := repositoryOption{}
+	method := cloneMethodAuto
+	var (
+		snapshotClass string
+		selector      string
+		keepOnFailure bool
+		resumeID      string
+		overrides     pvcOverrides
+	)
 	cmd := &cobra.Command{
 		Use:               "pvc",
 		Short:             `Clone PVC`,
-		Long:              `Use Backup and Restore process for cloning PVC`,
+		Long:              `Clone PVC using a CSI VolumeSnapshot or, the Backup and Restore process`,
 		Example:           cloneExample,
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if len(args) == 0 || args[0] == "" {
-				return fmt.Errorf("PVC name is not provided ")
+			if resumeID != "" {
+				journal, err := loadCloneJournal(srcNamespace, resumeID)
+				if err != nil {
+					return err
+				}
+				dstNamespace = journal.DstNamespace
+				klog.Infof("Resuming clone %s of PVC %s/%s", journal.ID, journal.SrcNamespace, strings.Join(journal.PVCs, ","))
+				return runClone(journal, keepOnFailure)
 			}
 
-			pvcName := args[0]
+			var (
+				pvcs []*core.PersistentVolumeClaim
+				name string
+			)
+			switch {
+			case selector != "" && len(args) > 0:
+				return fmt.Errorf("PVC name and selector can not be used together")
+			case selector != "":
+				pvcList, err := kubeClient.CoreV1().PersistentVolumeClaims(srcNamespace).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+				if err != nil {
+					return err
+				}
+				if len(pvcList.Items) == 0 {
+					return fmt.Errorf("no PVC found in namespace %s with selector %q", srcNamespace, selector)
+				}
+				for i := range pvcList.Items {
+					pvcs = append(pvcs, &pvcList.Items[i])
+				}
+				name = "pvcs"
+			case len(args) > 0 && args[0] != "":
+				pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(srcNamespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				pvcs = append(pvcs, pvc)
+				name = pvc.Name
+			default:
+				return fmt.Errorf("PVC name is not provided ")
+			}
 
-			pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(srcNamespace).Get(context.TODO(), pvcName, metav1.GetOptions{})
-			if err != nil {
+			if err := overrides.validate(pvcs); err != nil {
 				return err
 			}
-			// to clone a PVC from source namespace to destination namespace, Steps are following:
-			// 1. create Repository to the source namespace.
-			// 2. create BackupConfiguration to take backup of the source PVC.
-			// 3. clone Repository to the destination namespace
-			// 4. then restore the pvc to the destination namespace.
-
-			// set unique name for a Repository and create a Repository to the source namespace
-			repoName := fmt.Sprintf("%s-%s-%d", repoOpt.provider, "repo", time.Now().Unix())
-			klog.Infof("Creating Repository: %s to the Namespace: %s", repoName, srcNamespace)
-			repository := newRepository(repoOpt, repoName, srcNamespace)
-			_, err = createRepository(repository, repository.ObjectMeta)
+			journal, err := newCloneJournal(name, pvcs, method, snapshotClass, repoOpt, &overrides)
 			if err != nil {
 				return err
 			}
-			klog.Infof("Repository has been created successfully.")
+			return runClone(journal, keepOnFailure)
+		},
+	}
+	cmd.Flags().StringVarP(&selector, "selector", "l", selector, "Label selector to clone all the matching PVCs")
+	cmd.Flags().StringVar(&method, "method", method, "Method to clone the PVC. One of: snapshot|restic|auto. auto uses snapshot when a VolumeSnapshotClass matches the driver of the PVC")
+	cmd.Flags().StringVar(&snapshotClass, "volume-snapshot-class", snapshotClass, "Name of the VolumeSnapshotClass to use for the snapshot method")
+	cmd.Flags().BoolVar(&keepOnFailure, "keep-on-failure", keepOnFailure, "Keep the resources created so far if a step fails, so that the clone can be resumed with --resume")
+	cmd.Flags().StringVar(&resumeID, "resume", resumeID, "ID of a failed clone to resume from the failed step")
+	addRepositoryFlags(cmd, &repoOpt)
+	addPVCOverrideFlags(cmd, &overrides)
+	return cmd
+}
 
-			err = backupPVC(pvcName, kmapi.ObjectReference{
-				Name: repository.Name,
-			})
-			if err != nil {
-				return err
-			}
-			klog.Infof("The PVC %s/%s data has been backed up successfully", pvc.Namespace, pvc.Name)
+// newCloneJournal resolves the clone method and the names of the intermediate resources and, saves them in a new journal.
+func newCloneJournal(name string, pvcs []*core.PersistentVolumeClaim, method, snapshotClass string, repoOpt repositoryOption, overrides *pvcOverrides) (*cloneJournal, error) {
+	now := time.Now().Unix()
+	journal := &cloneJournal{
+		ID:           fmt.Sprintf("%s-%d", name, now),
+		SrcNamespace: srcNamespace,
+		DstNamespace: dstNamespace,
+	}
+	for _, pvc := range pvcs {
+		journal.PVCs = append(journal.PVCs, pvc.Name)
+	}
+	if !overrides.isEmpty() {
+		journal.Overrides = overrides
+	}
 
-			// copy repository and secret to the destination namespace
-			err = ensureRepository(repoName)
-			if err != nil {
-				return err
-			}
-			err = ensurePVC(pvc)
-			if err != nil {
-				return err
-			}
-			err = restorePVC(pvc.Name, kmapi.ObjectReference{
-				Name: repoName,
-			})
-			if err != nil {
-				return err
-			}
-			// delete all repository
-			err = cleanupRepository(repoName)
-			if err != nil {
-				return err
-			}
-			klog.Infof("PVC has been cloned successfully!!")
+	switch method {
+	case cloneMethodSnapshot, cloneMethodAuto:
+		snapshots, missing, err := findCloneSnapshotClasses(pvcs, snapshotClass, now)
+		if err != nil {
+			return nil, err
+		}
+		var reason string
+		if missing != nil {
+			reason = fmt.Sprintf("no VolumeSnapshotClass found for the driver of PVC %s/%s", missing.Namespace, missing.Name)
+		} else if reason, err = journal.Overrides.snapshotIncompatibility(pvcs); err != nil {
+			return nil, err
+		}
+		if reason == "" {
+			journal.Method = cloneMethodSnapshot
+			journal.Snapshots = snapshots
+			break
+		}
+		if method == cloneMethodSnapshot {
+			return nil, fmt.Errorf("can not clone using snapshot as %s", reason)
+		}
+		klog.Infof("Cloning using restic as %s.", reason)
+		fallthrough
+	case cloneMethodRestic:
+		if repoOpt.provider == "" {
+			return nil, fmt.Errorf("cloning using restic requires the backend flags (i.e. --provider, --bucket, --secret)")
+		}
+		if _, err := repoOpt.getBackendInfo(); err != nil {
+			return nil, err
+		}
+		journal.Method = cloneMethodRestic
+		journal.Repository = newCloneRepository(fmt.Sprintf("%s-%s-%d", repoOpt.provider, "repo", now), repoOpt)
+	default:
+		return nil, fmt.Errorf("unknown clone method %q. Supported methods are: snapshot, restic, auto", method)
+	}
 
-			return nil
+	if err := journal.save(); err != nil {
+		return nil, err
+	}
+	klog.Infof("Cloning PVC %s/%s with id %s", srcNamespace, strings.Join(journal.PVCs, ","), journal.ID)
+	return journal, nil
+}
+
+func runClone(journal *cloneJournal, keepOnFailure bool) error {
+	pvcs := make([]*core.PersistentVolumeClaim, 0, len(journal.PVCs))
+	for _, name := range journal.PVCs {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(journal.SrcNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		pvcs = append(pvcs, pvc)
+	}
+
+	var steps []cloneStep
+	if journal.Method == cloneMethodSnapshot {
+		steps = journal.snapshotSteps(pvcs)
+	} else {
+		steps = journal.resticSteps(pvcs)
+	}
+	if journal.Workload != nil {
+		steps = append(steps, journal.copyWorkloadStep())
+	}
+	if err := journal.execute(steps, keepOnFailure); err != nil {
+		return err
+	}
+	klog.Infof("PVC has been cloned successfully!!")
+	return nil
+}
+
+// resticSteps clones the PVCs from the source namespace to the destination namespace by,
+// 1. creating a Repository in the source namespace.
+// 2. creating a BackupConfiguration, or a BackupBatch for multiple PVCs, to take backup of the source PVCs.
+// 3. copying the Repository to the destination namespace.
+// 4. creating the PVCs in the destination namespace and restoring the backed up data into them.
+func (j *cloneJournal) resticSteps(pvcs []*core.PersistentVolumeClaim) []cloneStep {
+	repoName := j.Repository.Name
+	repoRef := kmapi.ObjectReference{Name: repoName}
+	return []cloneStep{
+		{
+			name: "create-repository",
+			run: func() error {
+				if err := j.track(v1alpha1.ResourceKindRepository, srcNamespace, repoName); err != nil {
+					return err
+				}
+				klog.Infof("Creating Repository: %s to the Namespace: %s", repoName, srcNamespace)
+				backend, err := j.Repository.option().getBackendInfo()
+				if err != nil {
+					return err
+				}
+				repository := newRepository(backend, repoName, srcNamespace)
+				if _, err := createRepository(stashClient, repository, repository.ObjectMeta, metav1.PatchOptions{}); err != nil {
+					return err
+				}
+				klog.Infof("Repository has been created successfully.")
+				return nil
+			},
+		},
+		{
+			name: "backup",
+			run: func() error {
+				if len(pvcs) == 1 {
+					if err := j.track(v1beta1.ResourceKindBackupConfiguration, srcNamespace, fmt.Sprintf("%s-%s", pvcs[0].Name, "backup")); err != nil {
+						return err
+					}
+					if err := backupPVC(pvcs[0].Name, repoRef); err != nil {
+						return err
+					}
+				} else {
+					if err := j.track(v1beta1.ResourceKindBackupBatch, srcNamespace, fmt.Sprintf("%s-%s", j.ID, "backup")); err != nil {
+						return err
+					}
+					if err := backupPVCs(fmt.Sprintf("%s-%s", j.ID, "backup"), pvcs, repoRef); err != nil {
+						return err
+					}
+				}
+				klog.Infof("The PVC %s/%s data has been backed up successfully", srcNamespace, strings.Join(j.PVCs, ","))
+				return nil
+			},
+		},
+		{
+			name: "copy-repository",
+			run: func() error {
+				// the storage Secret is removed on rollback only if this clone has created it
+				_, err := dstKubeClient.CoreV1().Secrets(dstNamespace).Get(context.TODO(), j.Repository.Secret, metav1.GetOptions{})
+				if kerr.IsNotFound(err) {
+					if err = j.track("Secret", dstNamespace, j.Repository.Secret); err != nil {
+						return err
+					}
+				} else if err != nil {
+					return err
+				}
+				if err = j.track(v1alpha1.ResourceKindRepository, dstNamespace, repoName); err != nil {
+					return err
+				}
+				return ensureRepository(repoName)
+			},
+		},
+		{
+			name: "create-pvcs",
+			run: func() error {
+				for _, pvc := range pvcs {
+					claim, err := j.Overrides.apply(pvc)
+					if err != nil {
+						return err
+					}
+					err = j.createDestinationPVC(claim.Name, func() error {
+						return ensurePVC(claim)
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		{
+			name: "restore",
+			run: func() error {
+				kind, name := v1beta1.ResourceKindRestoreSession, fmt.Sprintf("%s-%s", j.Overrides.destinationName(pvcs[0].Name), "restore")
+				if len(pvcs) > 1 {
+					kind, name = v1beta1.ResourceKindRestoreBatch, fmt.Sprintf("%s-%s", j.ID, "restore")
+				}
+				if j.tracked(kind, dstNamespace, name) {
+					// a restore invoker left by a failed attempt does not run again, so start over with a new one
+					if err := deleteRestoreInvoker(kind, dstNamespace, name); err != nil {
+						return err
+					}
+				}
+				if err := j.track(kind, dstNamespace, name); err != nil {
+					return err
+				}
+				if len(pvcs) == 1 {
+					return restorePVC(j.Overrides.destinationName(pvcs[0].Name), repoRef)
+				}
+				return restorePVCs(name, pvcs, repoRef)
+			},
+		},
+		{
+			name: "cleanup",
+			run: func() error {
+				return cleanupRepository(repoName)
+			},
 		},
 	}
-	cmd.Flags().StringVar(&repoOpt.provider, "provider", repoOpt.provider, "Backend provider (i.e. gcs, s3, azure etc)")
-	cmd.Flags().StringVar(&repoOpt.bucket, "bucket", repoOpt.bucket, "Name of the cloud bucket/container")
-	cmd.Flags().StringVar(&repoOpt.endpoint, "endpoint", repoOpt.endpoint, "Endpoint for s3/s3 compatible backend")
-	cmd.Flags().Int64Var(&repoOpt.maxConnections, "max-connections", repoOpt.maxConnections, "Specify maximum concurrent connections for GCS, Azure and B2 backend")
-	cmd.Flags().StringVar(&repoOpt.secret, "secret", repoOpt.secret, "Name of the Storage Secret")
-	cmd.Flags().StringVar(&repoOpt.prefix, "prefix", repoOpt.prefix, "Prefix denotes the directory inside the backend")
-	return cmd
+}
+
+// createDestinationPVC creates the PVC in the destination namespace unless a previous attempt of the clone has
+// created it already. It refuses to touch a PVC that does not belong to the clone.
+func (j *cloneJournal) createDestinationPVC(name string, create func() error) error {
+	_, err := kubeClient.CoreV1().PersistentVolumeClaims(dstNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err == nil {
+		if j.tracked("PersistentVolumeClaim", dstNamespace, name) {
+			return nil
+		}
+		return fmt.Errorf("PVC %s/%s already exists", dstNamespace, name)
+	}
+	if !kerr.IsNotFound(err) {
+		return err
+	}
+	if err = j.track("PersistentVolumeClaim", dstNamespace, name); err != nil {
+		return err
+	}
+	return create()
 }
 
 // at first, create BackupConfiguration to take backup
@@ -139,7 +372,7 @@ func backupPVC(pvcName string, repository kmapi.ObjectReference) error {
 		return err
 	}
 	klog.Infof("Creating BackupConfiguration: %s to the namespace: %s", backupConfig.Name, backupConfig.Namespace)
-	backupConfig, err = createBackupConfiguration(backupConfig, backupConfig.ObjectMeta)
+	backupConfig, err = createBackupConfiguration(stashClient, backupConfig, backupConfig.ObjectMeta, metav1.PatchOptions{})
 	if err != nil {
 		return err
 	}
@@ -159,6 +392,102 @@ func backupPVC(pvcName string, repository kmapi.ObjectReference) error {
 	return stashClient.StashV1beta1().BackupConfigurations(srcNamespace).Delete(context.TODO(), backupConfig.Name, metav1.DeleteOptions{})
 }
 
+// backupPVCs takes backup of multiple PVCs in a single BackupSession using a BackupBatch.
+// Each PVC is backed up with its name as the alias so that the snapshots can be told apart.
+func backupPVCs(name string, pvcs []*core.PersistentVolumeClaim, repository kmapi.ObjectReference) error {
+	backupBatch := &v1beta1.BackupBatch{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: srcNamespace,
+		},
+		Spec: v1beta1.BackupBatchSpec{
+			Schedule:   "*/59 * * * *",
+			Repository: repository,
+			RetentionPolicy: v1alpha1.RetentionPolicy{
+				Name:     "keep-last-5",
+				KeepLast: 5,
+				Prune:    true,
+			},
+		},
+	}
+	for _, pvc := range pvcs {
+		backupBatch.Spec.Members = append(backupBatch.Spec.Members, v1beta1.BackupConfigurationTemplateSpec{
+			Task: v1beta1.TaskRef{Name: "pvc-backup"},
+			Target: &v1beta1.BackupTarget{
+				Alias: pvc.Name,
+				Ref: v1beta1.TargetRef{
+					Name:       pvc.Name,
+					Kind:       apis.KindPersistentVolumeClaim,
+					APIVersion: core.SchemeGroupVersion.String(),
+				},
+			},
+		})
+	}
+	klog.Infof("Creating BackupBatch: %s to the namespace: %s", backupBatch.Name, backupBatch.Namespace)
+	backupBatch, _, err := v1beta1_util.CreateOrPatchBackupBatch(context.TODO(), stashClient.StashV1beta1(), backupBatch.ObjectMeta, func(in *v1beta1.BackupBatch) *v1beta1.BackupBatch {
+		in.Spec = backupBatch.Spec
+		return in
+	}, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	klog.Infof("BackupBatch has been created successfully.")
+
+	backupSession, err := triggerBackupBatch(backupBatch, stashClient)
+	if err != nil {
+		return err
+	}
+	err = WaitUntilBackupSessionCompleted(backupSession.Name, backupSession.Namespace)
+	if err != nil {
+		return err
+	}
+	klog.Infof("BackupSession has been succeeded.")
+	// delete the BackupBatch to stop taking backup
+	return stashClient.StashV1beta1().BackupBatches(srcNamespace).Delete(context.TODO(), backupBatch.Name, metav1.DeleteOptions{})
+}
+
+// restorePVCs restores the PVCs backed up by backupPVCs into the PVCs of the same names in the destination namespace.
+func restorePVCs(name string, pvcs []*core.PersistentVolumeClaim, repository kmapi.ObjectReference) error {
+	restoreBatch := &v1beta1.RestoreBatch{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: dstNamespace,
+		},
+		Spec: v1beta1.RestoreBatchSpec{
+			Repository: repository,
+		},
+	}
+	for _, pvc := range pvcs {
+		restoreBatch.Spec.Members = append(restoreBatch.Spec.Members, v1beta1.RestoreTargetSpec{
+			Task: v1beta1.TaskRef{Name: "pvc-restore"},
+			Target: &v1beta1.RestoreTarget{
+				Alias: pvc.Name,
+				Ref: v1beta1.TargetRef{
+					Name:       pvc.Name,
+					Kind:       apis.KindPersistentVolumeClaim,
+					APIVersion: core.SchemeGroupVersion.String(),
+				},
+				Rules: []v1beta1.Rule{{Snapshots: []string{"latest"}}},
+			},
+		})
+	}
+	klog.Infof("Creating RestoreBatch: %s to the namespace: %s", restoreBatch.Name, restoreBatch.Namespace)
+	restoreBatch, _, err := v1beta1_util.CreateOrPatchRestoreBatch(context.TODO(), stashClient.StashV1beta1(), restoreBatch.ObjectMeta, func(in *v1beta1.RestoreBatch) *v1beta1.RestoreBatch {
+		in.Spec = restoreBatch.Spec
+		return in
+	}, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+	klog.Infof("RestoreBatch has been created successfully.")
+	err = WaitUntilRestoreBatchCompleted(restoreBatch.Name, restoreBatch.Namespace)
+	if err != nil {
+		return err
+	}
+	klog.Infof("RestoreBatch has been succeeded.")
+	return stashClient.StashV1beta1().RestoreBatches(dstNamespace).Delete(context.TODO(), restoreBatch.Name, metav1.DeleteOptions{})
+}
+
 // create RestoreSession to create a new PVC in the destination namespace
 // then restore the backed up data into the PVC
 
@@ -183,7 +512,7 @@ func restorePVC(pvcName string, repository kmapi.ObjectReference) error {
 	}
 
 	klog.Infof("Creating RestoreSession: %s to the namespace: %s", restoreSession.Name, restoreSession.Namespace)
-	restoreSession, err = createRestoreSession(restoreSession)
+	restoreSession, err = createRestoreSession(restoreSession, metav1.PatchOptions{})
 	if err != nil {
 		return err
 	}
@@ -223,8 +552,32 @@ func ensurePVC(pvc *core.PersistentVolumeClaim) error {
 
 func cleanupRepository(repoName string) error {
 	err := stashClient.StashV1alpha1().Repositories(srcNamespace).Delete(context.TODO(), repoName, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	err = stashClient.StashV1alpha1().Repositories(dstNamespace).Delete(context.TODO(), repoName, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// deleteRestoreInvoker deletes a RestoreSession or RestoreBatch and waits until it is gone.
+func deleteRestoreInvoker(kind, ns, name string) error {
+	err := deleteClonedResource(clonedResource{Kind: kind, Namespace: ns, Name: name})
 	if err != nil {
+		if kerr.IsNotFound(err) {
+			return nil
+		}
 		return err
 	}
-	return stashClient.StashV1alpha1().Repositories(dstNamespace).Delete(context.TODO(), repoName, metav1.DeleteOptions{})
+	return wait.PollUntilContextTimeout(context.Background(), PullInterval, WaitTimeOut, true, func(ctx context.Context) (bool, error) {
+		var err error
+		if kind == v1beta1.ResourceKindRestoreBatch {
+			_, err = stashClient.StashV1beta1().RestoreBatches(ns).Get(ctx, name, metav1.GetOptions{})
+		} else {
+			_, err = stashClient.StashV1beta1().RestoreSessions(ns).Get(ctx, name, metav1.GetOptions{})
+		}
+		return kerr.IsNotFound(err), nil
+	})
 }
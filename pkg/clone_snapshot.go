@@ -0,0 +1,295 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	"gomodules.xyz/pointer"
+	core "k8s.io/api/core/v1"
+	storage "k8s.io/api/storage/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+)
+
+const (
+	cloneMethodSnapshot = "snapshot"
+	cloneMethodRestic   = "restic"
+	cloneMethodAuto     = "auto"
+
+	isDefaultSnapshotClassAnnotation = "snapshot.storage.kubernetes.io/is-default-class"
+)
+
+// findVolumeSnapshotClass returns the VolumeSnapshotClass whose driver provisions the PVC.
+// The default class is preferred if there are multiple matching classes.
+// It returns an empty string if none of the classes matches.
+func findVolumeSnapshotClass(pvc *core.PersistentVolumeClaim) (string, error) {
+	sc, err := pvcStorageClass(pvc)
+	if err != nil || sc == nil {
+		return "", err
+	}
+
+	classes, err := vsClient.SnapshotV1().VolumeSnapshotClasses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			// VolumeSnapshot CRDs are not installed
+			return "", nil
+		}
+		return "", err
+	}
+	var matched []vsapi.VolumeSnapshotClass
+	for _, class := range classes.Items {
+		if class.Driver == sc.Provisioner {
+			matched = append(matched, class)
+		}
+	}
+	if len(matched) == 0 {
+		return "", nil
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		di := matched[i].Annotations[isDefaultSnapshotClassAnnotation] == "true"
+		dj := matched[j].Annotations[isDefaultSnapshotClassAnnotation] == "true"
+		if di != dj {
+			return di
+		}
+		return matched[i].Name < matched[j].Name
+	})
+	return matched[0].Name, nil
+}
+
+// pvcStorageClass returns the StorageClass of the PVC or, the default StorageClass if the PVC does not specify one.
+func pvcStorageClass(pvc *core.PersistentVolumeClaim) (*storage.StorageClass, error) {
+	if pvc.Spec.StorageClassName != nil && *pvc.Spec.StorageClassName != "" {
+		return kubeClient.StorageV1().StorageClasses().Get(context.TODO(), *pvc.Spec.StorageClassName, metav1.GetOptions{})
+	}
+	classes, err := kubeClient.StorageV1().StorageClasses().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range classes.Items {
+		if classes.Items[i].Annotations["storageclass.kubernetes.io/is-default-class"] == "true" {
+			return &classes.Items[i], nil
+		}
+	}
+	return nil, nil
+}
+
+// findCloneSnapshotClasses resolves the VolumeSnapshotClass for each of the PVCs. It returns the first PVC
+// that has no matching class, if any.
+func findCloneSnapshotClasses(pvcs []*core.PersistentVolumeClaim, snapshotClass string, now int64) ([]cloneSnapshot, *core.PersistentVolumeClaim, error) {
+	snapshots := make([]cloneSnapshot, 0, len(pvcs))
+	for _, pvc := range pvcs {
+		class := snapshotClass
+		if class == "" {
+			var err error
+			if class, err = findVolumeSnapshotClass(pvc); err != nil {
+				return nil, nil, err
+			}
+			if class == "" {
+				return nil, pvc, nil
+			}
+		}
+		snapshots = append(snapshots, cloneSnapshot{
+			PVC:   pvc.Name,
+			Name:  fmt.Sprintf("%s-clone-%d", pvc.Name, now),
+			Class: class,
+		})
+	}
+	return snapshots, nil, nil
+}
+
+// snapshotSteps clones the PVCs by taking CSI VolumeSnapshots of the source PVCs, copying them to the
+// destination namespace and creating the new PVCs from the copied snapshots.
+func (j *cloneJournal) snapshotSteps(pvcs []*core.PersistentVolumeClaim) []cloneStep {
+	pvcByName := make(map[string]*core.PersistentVolumeClaim, len(pvcs))
+	for _, pvc := range pvcs {
+		pvcByName[pvc.Name] = pvc
+	}
+
+	steps := []cloneStep{
+		{
+			name: "create-snapshots",
+			run: func() error {
+				// create all the snapshots before waiting for any of them, so that they are taken at the same time
+				for _, s := range j.Snapshots {
+					if err := j.track(kindVolumeSnapshot, srcNamespace, s.Name); err != nil {
+						return err
+					}
+					if err := createCloneSnapshot(pvcByName[s.PVC], s.Class, s.Name); err != nil {
+						return err
+					}
+				}
+				for _, s := range j.Snapshots {
+					if err := waitUntilVolumeSnapshotReadyToUse(vsClient, srcNamespace, s.Name); err != nil {
+						return err
+					}
+					klog.Infof("VolumeSnapshot %s/%s is ready to use", srcNamespace, s.Name)
+				}
+				return nil
+			},
+		},
+	}
+	if dstNamespace != srcNamespace {
+		steps = append(steps, cloneStep{
+			name: "copy-snapshots",
+			run: func() error {
+				for _, s := range j.Snapshots {
+					if err := j.track(kindVolumeSnapshotContent, "", copiedVolumeSnapshotContentName(s.Name)); err != nil {
+						return err
+					}
+					if err := j.track(kindVolumeSnapshot, dstNamespace, s.Name); err != nil {
+						return err
+					}
+					if _, err := copyVolumeSnapshot(s.Name); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		})
+	}
+	return append(steps,
+		cloneStep{
+			name: "create-pvcs",
+			run: func() error {
+				for _, s := range j.Snapshots {
+					claim, err := j.Overrides.apply(pvcByName[s.PVC])
+					if err != nil {
+						return err
+					}
+					err = j.createDestinationPVC(claim.Name, func() error {
+						return createPVCFromSnapshot(claim, s.Name)
+					})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+		cloneStep{
+			name: "cleanup-snapshots",
+			run: func() error {
+				for _, s := range j.Snapshots {
+					claim, err := j.Overrides.apply(pvcByName[s.PVC])
+					if err != nil {
+						return err
+					}
+					if err = releaseCloneSnapshots(claim, s.Name); err != nil {
+						return err
+					}
+				}
+				return nil
+			},
+		},
+	)
+}
+
+func createCloneSnapshot(pvc *core.PersistentVolumeClaim, snapshotClass, snapshotName string) error {
+	klog.Infof("Creating VolumeSnapshot %s/%s of PVC %s using VolumeSnapshotClass %s", srcNamespace, snapshotName, pvc.Name, snapshotClass)
+	_, err := vsClient.SnapshotV1().VolumeSnapshots(srcNamespace).Create(context.TODO(), &vsapi.VolumeSnapshot{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      snapshotName,
+			Namespace: srcNamespace,
+		},
+		Spec: vsapi.VolumeSnapshotSpec{
+			Source: vsapi.VolumeSnapshotSource{
+				PersistentVolumeClaimName: pointer.StringP(pvc.Name),
+			},
+			VolumeSnapshotClassName: pointer.StringP(snapshotClass),
+		},
+	}, metav1.CreateOptions{})
+	if err != nil && !kerr.IsAlreadyExists(err) {
+		return err
+	}
+	return nil
+}
+
+func createPVCFromSnapshot(pvc *core.PersistentVolumeClaim, snapshotName string) error {
+	klog.Infof("Creating PVC %s/%s from VolumeSnapshot %s", dstNamespace, pvc.Name, snapshotName)
+	claim := &core.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      pvc.Name,
+			Namespace: dstNamespace,
+		},
+		Spec: core.PersistentVolumeClaimSpec{
+			StorageClassName: pvc.Spec.StorageClassName,
+			Resources:        pvc.Spec.Resources,
+			AccessModes:      pvc.Spec.AccessModes,
+			VolumeMode:       pvc.Spec.VolumeMode,
+			DataSource: &core.TypedLocalObjectReference{
+				APIGroup: pointer.StringP(vsapi.GroupName),
+				Kind:     kindVolumeSnapshot,
+				Name:     snapshotName,
+			},
+		},
+	}
+	_, err := kubeClient.CoreV1().PersistentVolumeClaims(dstNamespace).Create(context.TODO(), claim, metav1.CreateOptions{})
+	return err
+}
+
+// releaseCloneSnapshots removes the temporary snapshots once the volume has been provisioned from them.
+func releaseCloneSnapshots(pvc *core.PersistentVolumeClaim, snapshotName string) error {
+	sc, err := pvcStorageClass(pvc)
+	if err != nil {
+		return err
+	}
+	if sc != nil && sc.VolumeBindingMode != nil && *sc.VolumeBindingMode == storage.VolumeBindingWaitForFirstConsumer {
+		klog.Infof("StorageClass %s provisions the PVC when a Pod uses it. Delete VolumeSnapshot %s from namespace %s and %s after that.", sc.Name, snapshotName, srcNamespace, dstNamespace)
+		return nil
+	}
+	if err = waitUntilPVCBound(dstNamespace, pvc.Name); err != nil {
+		return err
+	}
+	return cleanupCloneSnapshots(snapshotName)
+}
+
+func waitUntilPVCBound(ns, name string) error {
+	return wait.PollUntilContextTimeout(context.Background(), PullInterval, WaitTimeOut, true, func(ctx context.Context) (bool, error) {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		return pvc.Status.Phase == core.ClaimBound, nil
+	})
+}
+
+// cleanupCloneSnapshots deletes the temporary VolumeSnapshots. The copy in the destination namespace is bound to
+// a retained VolumeSnapshotContent, so the content is deleted explicitly while the snapshot itself is deleted
+// along with the source VolumeSnapshot.
+func cleanupCloneSnapshots(snapshotName string) error {
+	if dstNamespace != srcNamespace {
+		err := vsClient.SnapshotV1().VolumeSnapshots(dstNamespace).Delete(context.TODO(), snapshotName, metav1.DeleteOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+		err = vsClient.SnapshotV1().VolumeSnapshotContents().Delete(context.TODO(), copiedVolumeSnapshotContentName(snapshotName), metav1.DeleteOptions{})
+		if err != nil && !kerr.IsNotFound(err) {
+			return err
+		}
+	}
+	err := vsClient.SnapshotV1().VolumeSnapshots(srcNamespace).Delete(context.TODO(), snapshotName, metav1.DeleteOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis"
+
+	"github.com/spf13/cobra"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+var cloneWorkloadExample = templates.Examples(`
+		# Clone all the PVCs of a StatefulSet
+		stash clone workload statefulset/db -n demo --to-namespace=demo1
+
+		# Clone the PVCs of a Deployment along with the Deployment itself
+		stash clone workload deployment/app -n demo --to-namespace=demo1 --copy-manifest`)
+
+func NewCmdCloneWorkload() *cobra.Command {
+	repoOpt := repositoryOption{}
+	method := cloneMethodAuto
+	var (
+		snapshotClass string
+		keepOnFailure bool
+		copyManifest  bool
+	)
+	cmd := &cobra.Command{
+		Use:               "workload <kind>/<name>",
+		Short:             `Clone the PVCs of a workload`,
+		Long:              `Clone all the PVCs of a StatefulSet or Deployment into the destination namespace keeping their names, so that the workload picks them up there`,
+		Example:           cloneWorkloadExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || args[0] == "" {
+				return fmt.Errorf("workload is not provided")
+			}
+			kind, name, err := parseWorkload(args[0])
+			if err != nil {
+				return err
+			}
+
+			pvcs, err := workloadPVCs(kind, name)
+			if err != nil {
+				return err
+			}
+			if len(pvcs) == 0 {
+				return fmt.Errorf("no PVC found for %s %s/%s", kind, srcNamespace, name)
+			}
+
+			journal, err := newCloneJournal(name, pvcs, method, snapshotClass, repoOpt, nil)
+			if err != nil {
+				return err
+			}
+			if copyManifest {
+				journal.Workload = &clonedResource{Kind: kind, Namespace: srcNamespace, Name: name}
+				if err = journal.save(); err != nil {
+					return err
+				}
+			}
+			return runClone(journal, keepOnFailure)
+		},
+	}
+	cmd.Flags().StringVar(&method, "method", method, "Method to clone the PVCs. One of: snapshot|restic|auto. auto uses snapshot when a VolumeSnapshotClass matches the driver of every PVC")
+	cmd.Flags().StringVar(&snapshotClass, "volume-snapshot-class", snapshotClass, "Name of the VolumeSnapshotClass to use for the snapshot method")
+	cmd.Flags().BoolVar(&keepOnFailure, "keep-on-failure", keepOnFailure, "Keep the resources created so far if a step fails, so that the clone can be resumed with \"clone pvc --resume\"")
+	cmd.Flags().BoolVar(&copyManifest, "copy-manifest", copyManifest, "Copy the workload itself to the destination namespace after the PVCs have been cloned")
+	addRepositoryFlags(cmd, &repoOpt)
+	return cmd
+}
+
+func parseWorkload(ref string) (string, string, error) {
+	parts := strings.SplitN(ref, "/", 2)
+	if len(parts) != 2 || parts[1] == "" {
+		return "", "", fmt.Errorf("invalid workload %q. Use <kind>/<name> format (i.e. statefulset/db)", ref)
+	}
+	switch strings.ToLower(parts[0]) {
+	case "statefulset", "statefulsets", "sts":
+		return apis.KindStatefulSet, parts[1], nil
+	case "deployment", "deployments", "deploy":
+		return apis.KindDeployment, parts[1], nil
+	}
+	return "", "", fmt.Errorf("unsupported workload kind %q. Supported kinds are: statefulset, deployment", parts[0])
+}
+
+// workloadPVCs returns the PVCs used by the workload. For a StatefulSet, these are the claims created from its
+// volumeClaimTemplates (i.e. data-db-0, data-db-1) including the ones of scaled down replicas.
+func workloadPVCs(kind, name string) ([]*core.PersistentVolumeClaim, error) {
+	var claimNames []string
+	switch kind {
+	case apis.KindStatefulSet:
+		sts, err := kubeClient.AppsV1().StatefulSets(srcNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		pvcList, err := kubeClient.CoreV1().PersistentVolumeClaims(srcNamespace).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return nil, err
+		}
+		var pvcs []*core.PersistentVolumeClaim
+		for _, t := range sts.Spec.VolumeClaimTemplates {
+			prefix := fmt.Sprintf("%s-%s-", t.Name, sts.Name)
+			for i := range pvcList.Items {
+				ordinal := strings.TrimPrefix(pvcList.Items[i].Name, prefix)
+				if ordinal == pvcList.Items[i].Name {
+					continue
+				}
+				if _, err := strconv.Atoi(ordinal); err == nil {
+					pvcs = append(pvcs, &pvcList.Items[i])
+				}
+			}
+		}
+		return pvcs, nil
+	case apis.KindDeployment:
+		deployment, err := kubeClient.AppsV1().Deployments(srcNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		for _, vol := range deployment.Spec.Template.Spec.Volumes {
+			if vol.PersistentVolumeClaim != nil {
+				claimNames = append(claimNames, vol.PersistentVolumeClaim.ClaimName)
+			}
+		}
+	}
+
+	pvcs := make([]*core.PersistentVolumeClaim, 0, len(claimNames))
+	for _, claimName := range claimNames {
+		pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(srcNamespace).Get(context.TODO(), claimName, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		pvcs = append(pvcs, pvc)
+	}
+	return pvcs, nil
+}
+
+// copyWorkloadStep copies the workload manifest to the destination namespace. It runs after the PVCs have been
+// cloned so that the workload starts with the cloned data.
+func (j *cloneJournal) copyWorkloadStep() cloneStep {
+	w := j.Workload
+	return cloneStep{
+		name: "copy-workload",
+		run: func() error {
+			var err error
+			switch w.Kind {
+			case apis.KindStatefulSet:
+				_, err = dstKubeClient.AppsV1().StatefulSets(dstNamespace).Get(context.TODO(), w.Name, metav1.GetOptions{})
+			case apis.KindDeployment:
+				_, err = dstKubeClient.AppsV1().Deployments(dstNamespace).Get(context.TODO(), w.Name, metav1.GetOptions{})
+			}
+			if err == nil {
+				if j.tracked(w.Kind, dstNamespace, w.Name) {
+					return nil
+				}
+				return fmt.Errorf("%s %s/%s already exists", w.Kind, dstNamespace, w.Name)
+			}
+			if !kerr.IsNotFound(err) {
+				return err
+			}
+			if err = j.track(w.Kind, dstNamespace, w.Name); err != nil {
+				return err
+			}
+
+			klog.Infof("Copying %s %s/%s to the namespace: %s", w.Kind, srcNamespace, w.Name, dstNamespace)
+			switch w.Kind {
+			case apis.KindStatefulSet:
+				sts, err := kubeClient.AppsV1().StatefulSets(srcNamespace).Get(context.TODO(), w.Name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				_, err = dstKubeClient.AppsV1().StatefulSets(dstNamespace).Create(context.TODO(), &apps.StatefulSet{
+					ObjectMeta: copyObjectMeta(sts.ObjectMeta),
+					Spec:       sts.Spec,
+				}, metav1.CreateOptions{})
+				return err
+			case apis.KindDeployment:
+				deployment, err := kubeClient.AppsV1().Deployments(srcNamespace).Get(context.TODO(), w.Name, metav1.GetOptions{})
+				if err != nil {
+					return err
+				}
+				_, err = dstKubeClient.AppsV1().Deployments(dstNamespace).Create(context.TODO(), &apps.Deployment{
+					ObjectMeta: copyObjectMeta(deployment.ObjectMeta),
+					Spec:       deployment.Spec,
+				}, metav1.CreateOptions{})
+				return err
+			}
+			return fmt.Errorf("unsupported workload kind %s", w.Kind)
+		},
+	}
+}
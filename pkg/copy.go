@@ -17,19 +17,29 @@ limitations under the License.
 package pkg
 
 import (
+	"fmt"
+	"strings"
+
 	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
 
 	vs_cs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
 func NewCmdCopy(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
+	var toContext, toKubeconfig string
 	cmd := &cobra.Command{
 		Use:               "cp",
 		Short:             `Copy stash resources from one namespace to another namespace`,
+		Long:              `Copy stash resources from one namespace to another namespace of the same cluster or, using --to-context/--to-kubeconfig, of another cluster`,
 		DisableAutoGenTag: true,
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := clientGetter.ToRESTConfig()
@@ -57,7 +67,28 @@ func NewCmdCopy(clientGetter genericclioptions.RESTClientGetter) *cobra.Command
 				return err
 			}
 
-			return nil
+			dynamicClient, err = dynamic.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
+			if toContext == "" && toKubeconfig == "" {
+				dstKubeClient, dstStashClient, dstVsClient, dstDynamicClient = kubeClient, stashClient, vsClient, dynamicClient
+				return nil
+			}
+
+			// keep the namespace unchanged when only the cluster differs
+			if dstNamespace == "" {
+				dstNamespace = srcNamespace
+			}
+			dstCfg, err := newDestinationConfig(toKubeconfig, toContext)
+			if err != nil {
+				return errors.Wrap(err, "failed to read destination kubeconfig")
+			}
+			if dstCfg.Host == cfg.Host && dstNamespace == srcNamespace {
+				return fmt.Errorf("source and destination are the same: cluster %s, namespace %s", cfg.Host, srcNamespace)
+			}
+			return setDestinationClients(dstCfg)
 		},
 	}
 
@@ -65,7 +96,116 @@ func NewCmdCopy(clientGetter genericclioptions.RESTClientGetter) *cobra.Command
 	cmd.AddCommand(NewCmdCopySecret())
 	cmd.AddCommand(NewCmdCopyVolumeSnapshot())
 	cmd.AddCommand(NewCmdCopyBackupConfiguration())
+	cmd.AddCommand(NewCmdCopyAll())
 
 	cmd.PersistentFlags().StringVar(&dstNamespace, "to-namespace", dstNamespace, "Destination namespace.")
+	cmd.PersistentFlags().StringVar(&toContext, "to-context", toContext, "Name of the kubeconfig context of the destination cluster.")
+	cmd.PersistentFlags().StringVar(&toKubeconfig, "to-kubeconfig", toKubeconfig, "Path to the kubeconfig file of the destination cluster.")
+	cmd.PersistentFlags().BoolVar(&overwrite, "overwrite", overwrite, "Overwrite the objects that already exist in the destination with a different content.")
+	cmd.PersistentFlags().StringSliceVar(&stripLabels, "strip-labels", stripLabels, "Keys of the labels that are not copied. A key ending with \"*\" matches every key with that prefix.")
+	cmd.PersistentFlags().StringSliceVar(&stripAnnotations, "strip-annotations", stripAnnotations, "Keys of the annotations that are not copied. A key ending with \"*\" matches every key with that prefix.")
+	cmd.PersistentFlags().BoolVar(&copyAsPaused, "copy-as-paused", copyAsPaused, "Pause the copied BackupConfigurations and BackupBatches so that they don't start taking backup immediately.")
 	return cmd
 }
+
+// newDestinationConfig builds the rest config of the destination cluster from the given kubeconfig file and context.
+// The default kubeconfig loading rules are used if the file is not specified.
+func newDestinationConfig(kubeconfig, kubeContext string) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	if kubeconfig != "" {
+		loadingRules.ExplicitPath = kubeconfig
+	}
+	overrides := &clientcmd.ConfigOverrides{
+		CurrentContext: kubeContext,
+	}
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides).ClientConfig()
+}
+
+func setDestinationClients(cfg *rest.Config) (err error) {
+	dstKubeClient, err = kubernetes.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	dstStashClient, err = cs.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	dstVsClient, err = vs_cs.NewForConfig(cfg)
+	if err != nil {
+		return err
+	}
+	dstDynamicClient, err = dynamic.NewForConfig(cfg)
+	return err
+}
+
+var (
+	// GitOps tools track the objects they manage with these labels and annotations.
+	// Keeping them on the copies makes the tools of the destination prune or revert the copies.
+	defaultStripLabels = []string{
+		"app.kubernetes.io/instance",
+		"app.kubernetes.io/managed-by",
+		"argocd.argoproj.io/*",
+		"kustomize.toolkit.fluxcd.io/*",
+		"helm.toolkit.fluxcd.io/*",
+		"controller-uid",
+		"batch.kubernetes.io/*",
+	}
+	defaultStripAnnotations = []string{
+		core.LastAppliedConfigAnnotation,
+		"argocd.argoproj.io/*",
+		"kustomize.toolkit.fluxcd.io/*",
+		"helm.toolkit.fluxcd.io/*",
+		"meta.helm.sh/*",
+	}
+)
+
+// copyObjectMeta returns the metadata of the copy of an object in the destination namespace.
+func copyObjectMeta(src metav1.ObjectMeta) metav1.ObjectMeta {
+	return metav1.ObjectMeta{
+		Name:        src.Name,
+		Namespace:   dstNamespace,
+		Labels:      filterMetadata(src.Labels, stripLabels),
+		Annotations: filterMetadata(src.Annotations, stripAnnotations),
+	}
+}
+
+func filterMetadata(in map[string]string, strip []string) map[string]string {
+	if len(in) == 0 {
+		return nil
+	}
+	out := map[string]string{}
+	for k, v := range in {
+		if !matchesAnyKey(k, strip) {
+			out[k] = v
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+func matchesAnyKey(key string, patterns []string) bool {
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(key, prefix) {
+				return true
+			}
+		} else if key == p {
+			return true
+		}
+	}
+	return false
+}
+
+// copiedNamespace points a namespace reference to the source namespace to the destination namespace.
+func copiedNamespace(ns string) string {
+	if ns == srcNamespace {
+		return dstNamespace
+	}
+	return ns
+}
+
+func newCopyConflictError(kind, ns, name string) error {
+	return fmt.Errorf("%s %s/%s already exists in the destination with a different content. Use --overwrite to replace it", kind, ns, name)
+}
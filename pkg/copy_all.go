@@ -0,0 +1,573 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	"github.com/spf13/cobra"
+	core "k8s.io/api/core/v1"
+	rbac "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/templates"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+const (
+	copyActionCreate    = "create"
+	copyActionUnchanged = "unchanged"
+	copyActionOverwrite = "overwrite"
+	copyActionConflict  = "conflict"
+	copyActionShared    = "shared"
+	copyActionExternal  = "external"
+	copyActionMissing   = "missing"
+)
+
+var copyAllExample = templates.Examples(`
+		# Print the plan to copy all the backup setup of namespace demo to namespace demo-dr
+		kubectl stash cp all --from=demo --to=demo-dr --dry-run
+
+		# Copy the BackupConfigurations, BackupBatches and Repositories labeled app=db along with their dependencies to another cluster
+		kubectl stash cp all --from=demo -l app=db --to-context=standby`)
+
+// copyKinds lists the kinds that can be copied in the order they are copied when they do not depend on each other.
+var copyKinds = []struct {
+	kind       string
+	gvr        schema.GroupVersionResource
+	namespaced bool
+}{
+	{v1beta1.ResourceKindFunction, v1beta1.SchemeGroupVersion.WithResource(v1beta1.ResourcePluralFunction), false},
+	{v1beta1.ResourceKindTask, v1beta1.SchemeGroupVersion.WithResource(v1beta1.ResourcePluralTask), false},
+	{"ClusterRole", rbac.SchemeGroupVersion.WithResource("clusterroles"), false},
+	{"Secret", core.SchemeGroupVersion.WithResource("secrets"), true},
+	{"ServiceAccount", core.SchemeGroupVersion.WithResource("serviceaccounts"), true},
+	{"Role", rbac.SchemeGroupVersion.WithResource("roles"), true},
+	{"RoleBinding", rbac.SchemeGroupVersion.WithResource("rolebindings"), true},
+	{apis.KindAppBinding, appcatalog.SchemeGroupVersion.WithResource(appcatalog.ResourceApps), true},
+	{v1alpha1.ResourceKindRepository, v1alpha1.SchemeGroupVersion.WithResource(v1alpha1.ResourcePluralRepository), true},
+	{v1beta1.ResourceKindBackupConfiguration, v1beta1.SchemeGroupVersion.WithResource(v1beta1.ResourcePluralBackupConfiguration), true},
+	{v1beta1.ResourceKindBackupBatch, v1beta1.SchemeGroupVersion.WithResource(v1beta1.ResourcePluralBackupBatch), true},
+}
+
+type copyNode struct {
+	kind      string
+	namespace string // empty for cluster-scoped objects
+	name      string
+	obj       *unstructured.Unstructured
+	deps      []string
+	action    string
+}
+
+func (n *copyNode) key() string {
+	return copyNodeKey(n.kind, n.namespace, n.name)
+}
+
+func (n *copyNode) String() string {
+	if n.namespace == "" {
+		return n.name
+	}
+	return n.namespace + "/" + n.name
+}
+
+func copyNodeKey(kind, ns, name string) string {
+	return kind + "/" + ns + "/" + name
+}
+
+type copyGraph struct {
+	nodes map[string]*copyNode
+	from  string
+	to    string
+}
+
+func NewCmdCopyAll() *cobra.Command {
+	var (
+		from     string
+		selector string
+		dryRun   bool
+	)
+	cmd := &cobra.Command{
+		Use:               "all",
+		Short:             `Copy the backup setup of a namespace along with its dependencies`,
+		Long:              `Copy BackupConfigurations, BackupBatches and Repositories along with the Secrets, Tasks, Functions, ServiceAccounts, RBAC and AppBindings they depend on, rewriting the namespace references`,
+		Example:           copyAllExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			sameCluster := dstKubeClient == kubeClient
+			if from != "" {
+				srcNamespace = from
+				if !sameCluster && !cmd.Flags().Changed("to") && !cmd.Flags().Changed("to-namespace") {
+					dstNamespace = srcNamespace
+				}
+			}
+			if dstNamespace == "" {
+				return fmt.Errorf("destination namespace is not provided")
+			}
+			if sameCluster && srcNamespace == dstNamespace {
+				return fmt.Errorf("source and destination namespace are the same")
+			}
+
+			g := &copyGraph{
+				nodes: map[string]*copyNode{},
+				from:  srcNamespace,
+				to:    dstNamespace,
+			}
+			if err := g.build(selector); err != nil {
+				return err
+			}
+			nodes := g.sorted()
+			nsExists, err := g.plan(nodes, sameCluster)
+			if err != nil {
+				return err
+			}
+			printCopyPlan(os.Stdout, nodes, dstNamespace, nsExists)
+
+			var blocked []string
+			for _, n := range nodes {
+				if n.action == copyActionMissing || n.action == copyActionConflict {
+					blocked = append(blocked, fmt.Sprintf("%s %s (%s)", n.kind, n, n.action))
+				}
+			}
+			if dryRun {
+				return nil
+			}
+			if len(blocked) > 0 {
+				return fmt.Errorf("nothing has been copied. Resolve the following first (use --overwrite to replace conflicting objects): %s", strings.Join(blocked, ", "))
+			}
+			return g.apply(nodes, nsExists)
+		},
+	}
+
+	cmd.Flags().StringVar(&from, "from", from, "Source namespace. Defaults to the namespace of the current context.")
+	cmd.Flags().StringVar(&dstNamespace, "to", dstNamespace, "Destination namespace. Same as --to-namespace.")
+	cmd.Flags().StringVarP(&selector, "selector", "l", selector, "Label selector to filter the BackupConfigurations, BackupBatches and Repositories to copy")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", dryRun, "Only print the plan without copying anything")
+	return cmd
+}
+
+// build adds the selected BackupConfigurations, BackupBatches and Repositories of the source namespace
+// and everything they reference to the graph.
+func (g *copyGraph) build(selector string) error {
+	for _, kind := range []string{v1beta1.ResourceKindBackupConfiguration, v1beta1.ResourceKindBackupBatch, v1alpha1.ResourceKindRepository} {
+		gvr, _ := copyKindInfo(kind)
+		list, err := dynamicClient.Resource(gvr).Namespace(g.from).List(context.TODO(), metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return err
+		}
+		for _, item := range list.Items {
+			if _, err := g.add(kind, g.from, item.GetName()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// add fetches an object from the source and recursively adds its dependencies.
+// It returns the key of the node.
+func (g *copyGraph) add(kind, ns, name string) (string, error) {
+	gvr, namespaced := copyKindInfo(kind)
+	if !namespaced {
+		ns = ""
+	}
+	key := copyNodeKey(kind, ns, name)
+	if _, ok := g.nodes[key]; ok {
+		return key, nil
+	}
+	n := &copyNode{kind: kind, namespace: ns, name: name}
+	g.nodes[key] = n
+
+	// objects of other namespaces are referenced as they are
+	if namespaced && ns != g.from {
+		n.action = copyActionExternal
+		return key, nil
+	}
+
+	obj, err := dynamicClient.Resource(gvr).Namespace(ns).Get(context.TODO(), name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		n.action = copyActionMissing
+		return key, nil
+	} else if err != nil {
+		return "", err
+	}
+	n.obj = obj
+	return key, g.addDependencies(n)
+}
+
+func (g *copyGraph) addDependencies(n *copyNode) error {
+	depend := func(kind, ns, name string) error {
+		if name == "" {
+			return nil
+		}
+		if ns == "" {
+			ns = g.from
+		}
+		key, err := g.add(kind, ns, name)
+		if err != nil {
+			return err
+		}
+		n.deps = append(n.deps, key)
+		return nil
+	}
+
+	switch n.kind {
+	case v1beta1.ResourceKindBackupConfiguration:
+		bc := &v1beta1.BackupConfiguration{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(n.obj.Object, bc); err != nil {
+			return err
+		}
+		if err := depend(v1alpha1.ResourceKindRepository, bc.Spec.Repository.Namespace, bc.Spec.Repository.Name); err != nil {
+			return err
+		}
+		return g.addTemplateDependencies(bc.Spec.BackupConfigurationTemplateSpec, depend)
+	case v1beta1.ResourceKindBackupBatch:
+		bb := &v1beta1.BackupBatch{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(n.obj.Object, bb); err != nil {
+			return err
+		}
+		if err := depend(v1alpha1.ResourceKindRepository, bb.Spec.Repository.Namespace, bb.Spec.Repository.Name); err != nil {
+			return err
+		}
+		for _, member := range bb.Spec.Members {
+			if err := g.addTemplateDependencies(member, depend); err != nil {
+				return err
+			}
+		}
+	case v1alpha1.ResourceKindRepository:
+		repo := &v1alpha1.Repository{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(n.obj.Object, repo); err != nil {
+			return err
+		}
+		return depend("Secret", "", repo.Spec.Backend.StorageSecretName)
+	case v1beta1.ResourceKindTask:
+		task := &v1beta1.Task{}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(n.obj.Object, task); err != nil {
+			return err
+		}
+		for _, step := range task.Spec.Steps {
+			if err := depend(v1beta1.ResourceKindFunction, "", step.Name); err != nil {
+				return err
+			}
+		}
+	case apis.KindAppBinding:
+		for _, field := range []string{"secret", "tlsSecret"} {
+			secret, _, _ := unstructured.NestedString(n.obj.Object, "spec", field, "name")
+			if err := depend("Secret", "", secret); err != nil {
+				return err
+			}
+		}
+	case "ServiceAccount":
+		// the RoleBindings of the ServiceAccount are copied along with it
+		rbList, err := kubeClient.RbacV1().RoleBindings(g.from).List(context.TODO(), metav1.ListOptions{})
+		if err != nil {
+			return err
+		}
+		for _, rb := range rbList.Items {
+			for _, subject := range rb.Subjects {
+				if subject.Kind == rbac.ServiceAccountKind && subject.Name == n.name && (subject.Namespace == "" || subject.Namespace == g.from) {
+					key, err := g.add("RoleBinding", g.from, rb.Name)
+					if err != nil {
+						return err
+					}
+					if rbNode := g.nodes[key]; !containsString(rbNode.deps, n.key()) {
+						rbNode.deps = append(rbNode.deps, n.key())
+					}
+					break
+				}
+			}
+		}
+	case "RoleBinding":
+		kind, _, _ := unstructured.NestedString(n.obj.Object, "roleRef", "kind")
+		name, _, _ := unstructured.NestedString(n.obj.Object, "roleRef", "name")
+		return depend(kind, "", name)
+	}
+	return nil
+}
+
+func (g *copyGraph) addTemplateDependencies(spec v1beta1.BackupConfigurationTemplateSpec, depend func(kind, ns, name string) error) error {
+	if err := depend(v1beta1.ResourceKindTask, "", spec.Task.Name); err != nil {
+		return err
+	}
+	if spec.RuntimeSettings.Pod != nil {
+		if err := depend("ServiceAccount", "", spec.RuntimeSettings.Pod.ServiceAccountName); err != nil {
+			return err
+		}
+	}
+	if spec.Target != nil && spec.Target.Ref.Kind == apis.KindAppBinding {
+		return depend(apis.KindAppBinding, spec.Target.Ref.Namespace, spec.Target.Ref.Name)
+	}
+	return nil
+}
+
+// sorted returns the nodes so that every node comes after its dependencies.
+func (g *copyGraph) sorted() []*copyNode {
+	keys := make([]string, 0, len(g.nodes))
+	for key := range g.nodes {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		ri, rj := copyKindRank(g.nodes[keys[i]].kind), copyKindRank(g.nodes[keys[j]].kind)
+		if ri != rj {
+			return ri < rj
+		}
+		return keys[i] < keys[j]
+	})
+
+	var result []*copyNode
+	visited := map[string]bool{}
+	var visit func(key string)
+	visit = func(key string) {
+		if visited[key] {
+			return
+		}
+		visited[key] = true
+		n := g.nodes[key]
+		for _, dep := range n.deps {
+			visit(dep)
+		}
+		result = append(result, n)
+	}
+	for _, key := range keys {
+		visit(key)
+	}
+	return result
+}
+
+// plan decides what to do with each node by comparing it with the destination.
+// It also reports whether the destination namespace already exists.
+func (g *copyGraph) plan(nodes []*copyNode, sameCluster bool) (bool, error) {
+	_, err := dstKubeClient.CoreV1().Namespaces().Get(context.TODO(), g.to, metav1.GetOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return false, err
+	}
+	nsExists := err == nil
+
+	for _, n := range nodes {
+		if n.action != "" {
+			continue
+		}
+		gvr, namespaced := copyKindInfo(n.kind)
+		if !namespaced && sameCluster {
+			n.action = copyActionShared
+			continue
+		}
+		if namespaced && !nsExists {
+			n.action = copyActionCreate
+			continue
+		}
+
+		existing, err := dstDynamicClient.Resource(gvr).Namespace(g.destinationNamespace(n)).Get(context.TODO(), n.name, metav1.GetOptions{})
+		if kerr.IsNotFound(err) {
+			n.action = copyActionCreate
+			continue
+		} else if err != nil {
+			return false, err
+		}
+		switch {
+		case equality.Semantic.DeepEqual(copyContent(existing), copyContent(g.rewrite(n.obj))):
+			n.action = copyActionUnchanged
+		case overwrite:
+			n.action = copyActionOverwrite
+		default:
+			n.action = copyActionConflict
+		}
+	}
+	return nsExists, nil
+}
+
+func (g *copyGraph) apply(nodes []*copyNode, nsExists bool) error {
+	if !nsExists {
+		klog.Infof("Creating namespace %s", g.to)
+		_, err := dstKubeClient.CoreV1().Namespaces().Create(context.TODO(), &core.Namespace{
+			ObjectMeta: metav1.ObjectMeta{Name: g.to},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, n := range nodes {
+		gvr, _ := copyKindInfo(n.kind)
+		client := dstDynamicClient.Resource(gvr).Namespace(g.destinationNamespace(n))
+		obj := g.rewrite(n.obj)
+
+		switch n.action {
+		case copyActionCreate:
+			if _, err := client.Create(context.TODO(), obj, metav1.CreateOptions{}); err != nil {
+				return fmt.Errorf("failed to create %s %s: %w", n.kind, n, err)
+			}
+		case copyActionOverwrite:
+			existing, err := client.Get(context.TODO(), n.name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			obj.SetResourceVersion(existing.GetResourceVersion())
+			if _, err := client.Update(context.TODO(), obj, metav1.UpdateOptions{}); err != nil {
+				return fmt.Errorf("failed to overwrite %s %s: %w", n.kind, n, err)
+			}
+		default:
+			continue
+		}
+		klog.Infof("%s %s has been copied successfully.", n.kind, n)
+	}
+	return nil
+}
+
+func (g *copyGraph) destinationNamespace(n *copyNode) string {
+	if n.namespace == "" {
+		return ""
+	}
+	return g.to
+}
+
+// rewrite returns a copy of the source object that can be created in the destination.
+// The server populated metadata, the status and the stripped labels and annotations are dropped and every "namespace" field
+// referring to the source namespace is pointed to the destination namespace.
+func (g *copyGraph) rewrite(obj *unstructured.Unstructured) *unstructured.Unstructured {
+	out := obj.DeepCopy()
+	delete(out.Object, "status")
+	if out.GetKind() == "ServiceAccount" {
+		// token Secrets are generated for the new ServiceAccount
+		delete(out.Object, "secrets")
+	}
+
+	meta := map[string]interface{}{
+		"name": obj.GetName(),
+	}
+	if obj.GetNamespace() != "" {
+		meta["namespace"] = g.to
+	}
+	delete(out.Object, "metadata")
+	rewriteNamespaceRefs(out.Object, g.from, g.to)
+	out.Object["metadata"] = meta
+
+	if labels := filterMetadata(obj.GetLabels(), stripLabels); len(labels) > 0 {
+		out.SetLabels(labels)
+	}
+	if annotations := filterMetadata(obj.GetAnnotations(), stripAnnotations); len(annotations) > 0 {
+		out.SetAnnotations(annotations)
+	}
+	if copyAsPaused && (obj.GetKind() == v1beta1.ResourceKindBackupConfiguration || obj.GetKind() == v1beta1.ResourceKindBackupBatch) {
+		_ = unstructured.SetNestedField(out.Object, true, "spec", "paused")
+	}
+	return out
+}
+
+func rewriteNamespaceRefs(v interface{}, from, to string) {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		for k, field := range val {
+			if s, ok := field.(string); ok && k == "namespace" && s == from {
+				val[k] = to
+				continue
+			}
+			rewriteNamespaceRefs(field, from, to)
+		}
+	case []interface{}:
+		for _, item := range val {
+			rewriteNamespaceRefs(item, from, to)
+		}
+	}
+}
+
+// copyContent returns the fields of an object that are compared to detect conflicts.
+func copyContent(obj *unstructured.Unstructured) map[string]interface{} {
+	content := map[string]interface{}{}
+	for k, v := range obj.Object {
+		switch k {
+		case "metadata", "status", "apiVersion", "kind", "secrets":
+			continue
+		}
+		content[k] = v
+	}
+	return content
+}
+
+func copyKindInfo(kind string) (schema.GroupVersionResource, bool) {
+	for _, k := range copyKinds {
+		if k.kind == kind {
+			return k.gvr, k.namespaced
+		}
+	}
+	return schema.GroupVersionResource{}, true
+}
+
+func copyKindRank(kind string) int {
+	for i, k := range copyKinds {
+		if k.kind == kind {
+			return i
+		}
+	}
+	return len(copyKinds)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func printCopyPlan(out io.Writer, nodes []*copyNode, dstNs string, nsExists bool) {
+	w := tabwriter.NewWriter(out, TableMinWidth, TableTabWidth, TablePadding, TablePadChar, 0)
+	_, _ = fmt.Fprintln(w, "STEP\tKIND\tSOURCE\tDESTINATION\tACTION\tDEPENDS ON")
+	step := 0
+	if !nsExists {
+		step++
+		_, _ = fmt.Fprintf(w, "%d\tNamespace\t-\t%s\t%s\t-\n", step, dstNs, copyActionCreate)
+	}
+	for _, n := range nodes {
+		step++
+		dst := "-"
+		switch {
+		case n.action == copyActionExternal || n.action == copyActionMissing:
+		case n.namespace == "":
+			dst = n.name
+		default:
+			dst = dstNs + "/" + n.name
+		}
+		deps := make([]string, 0, len(n.deps))
+		for _, dep := range n.deps {
+			parts := strings.SplitN(dep, "/", 3)
+			deps = append(deps, parts[0]+"/"+parts[2])
+		}
+		depends := "-"
+		if len(deps) > 0 {
+			depends = strings.Join(deps, ",")
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", strconv.Itoa(step), n.kind, n, dst, n.action, depends)
+	}
+	_ = w.Flush()
+}
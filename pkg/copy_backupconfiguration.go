@@ -23,6 +23,8 @@ import (
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 )
@@ -57,20 +59,43 @@ func ensureBackupConfiguration(name string) error {
 	// Repository holds the backend information, In Restic driver mechanism, Repository is used to backup.
 	// For that need to insure Repository and Secret
 	if backupConfig.Spec.Driver != v1beta1.VolumeSnapshotter {
-		// ensure Repository and Secret
-		err = ensureRepository(backupConfig.Spec.Repository.Name)
-		if err != nil {
-			return err
+		repoNamespace := backupConfig.Spec.Repository.Namespace
+		if repoNamespace == "" || repoNamespace == srcNamespace {
+			// ensure Repository and Secret
+			err = ensureRepository(backupConfig.Spec.Repository.Name)
+			if err != nil {
+				return err
+			}
+		} else {
+			klog.Infof("Repository %s/%s is not in the source namespace. It will be referenced as it is.", repoNamespace, backupConfig.Spec.Repository.Name)
 		}
 	}
-	// copy the BackupConfiguration to the destination namespace
-	meta := metav1.ObjectMeta{
-		Name:        backupConfig.Name,
-		Namespace:   dstNamespace,
-		Labels:      backupConfig.Labels,
-		Annotations: backupConfig.Annotations,
+
+	// point the references to the source namespace to the destination namespace
+	backupConfig.Spec.Repository.Namespace = copiedNamespace(backupConfig.Spec.Repository.Namespace)
+	if backupConfig.Spec.Target != nil {
+		backupConfig.Spec.Target.Ref.Namespace = copiedNamespace(backupConfig.Spec.Target.Ref.Namespace)
 	}
-	_, err = createBackupConfiguration(backupConfig, meta)
+	if copyAsPaused {
+		backupConfig.Spec.Paused = true
+	}
+
+	// check whether the BackupConfiguration already exists in the destination with a different spec
+	existing, err := dstStashClient.StashV1beta1().BackupConfigurations(dstNamespace).Get(context.TODO(), backupConfig.Name, metav1.GetOptions{})
+	if err == nil {
+		if equality.Semantic.DeepEqual(existing.Spec, backupConfig.Spec) {
+			klog.Infof("BackupConfiguration %s/%s is already up to date.", dstNamespace, backupConfig.Name)
+			return nil
+		}
+		if !overwrite {
+			return newCopyConflictError("BackupConfiguration", dstNamespace, backupConfig.Name)
+		}
+	} else if !kerr.IsNotFound(err) {
+		return err
+	}
+
+	// copy the BackupConfiguration to the destination namespace
+	_, err = createBackupConfiguration(dstStashClient, backupConfig, copyObjectMeta(backupConfig.ObjectMeta), metav1.PatchOptions{})
 	if err != nil {
 		return err
 	}
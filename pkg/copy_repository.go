@@ -21,6 +21,8 @@ import (
 	"fmt"
 
 	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
 )
@@ -59,14 +61,22 @@ func ensureRepository(name string) error {
 	if err != nil {
 		return err
 	}
-	// copy the Repository to the destination namespace
-	meta := metav1.ObjectMeta{
-		Name:        repository.Name,
-		Namespace:   dstNamespace,
-		Labels:      repository.Labels,
-		Annotations: repository.Annotations,
+	// check whether the Repository already exists in the destination with a different spec
+	existing, err := dstStashClient.StashV1alpha1().Repositories(dstNamespace).Get(context.TODO(), repository.Name, metav1.GetOptions{})
+	if err == nil {
+		if equality.Semantic.DeepEqual(existing.Spec, repository.Spec) {
+			klog.Infof("Repository %s/%s is already up to date.", dstNamespace, repository.Name)
+			return nil
+		}
+		if !overwrite {
+			return newCopyConflictError("Repository", dstNamespace, repository.Name)
+		}
+	} else if !kerr.IsNotFound(err) {
+		return err
 	}
-	_, err = createRepository(repository, meta)
+
+	// copy the Repository to the destination namespace
+	_, err = createRepository(dstStashClient, repository, copyObjectMeta(repository.ObjectMeta), metav1.PatchOptions{})
 	if err != nil {
 		return err
 	}
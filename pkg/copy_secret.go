@@ -22,7 +22,10 @@ import (
 
 	"github.com/spf13/cobra"
 	core "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog/v2"
 	core_util "kmodules.xyz/client-go/core/v1"
 )
@@ -57,14 +60,22 @@ func ensureSecret(name string) error {
 	}
 
 	klog.Infof("Copying Storage Secret %s to %s namespace", secret.Namespace, dstNamespace)
-	// copy the Secret to the destination namespace
-	meta := metav1.ObjectMeta{
-		Name:        secret.Name,
-		Namespace:   dstNamespace,
-		Labels:      secret.Labels,
-		Annotations: secret.Annotations,
+	// check whether the Secret already exists in the destination with a different content
+	existing, err := dstKubeClient.CoreV1().Secrets(dstNamespace).Get(context.TODO(), secret.Name, metav1.GetOptions{})
+	if err == nil {
+		if equality.Semantic.DeepEqual(existing.Data, secret.Data) {
+			klog.Infof("Secret %s/%s is already up to date.", dstNamespace, secret.Name)
+			return nil
+		}
+		if !overwrite {
+			return newCopyConflictError("Secret", dstNamespace, secret.Name)
+		}
+	} else if !kerr.IsNotFound(err) {
+		return err
 	}
-	_, err = createSecret(secret, meta)
+
+	// copy the Secret to the destination namespace
+	_, err = createSecret(dstKubeClient, secret, copyObjectMeta(secret.ObjectMeta))
 	if err != nil {
 		return err
 	}
@@ -73,8 +84,8 @@ func ensureSecret(name string) error {
 	return err
 }
 
-func createSecret(secret *core.Secret, meta metav1.ObjectMeta) (*core.Secret, error) {
-	secret, _, err := core_util.CreateOrPatchSecret(context.TODO(), kubeClient, meta, func(in *core.Secret) *core.Secret {
+func createSecret(client kubernetes.Interface, secret *core.Secret, meta metav1.ObjectMeta) (*core.Secret, error) {
+	secret, _, err := core_util.CreateOrPatchSecret(context.TODO(), client, meta, func(in *core.Secret) *core.Secret {
 		in.Data = secret.Data
 		return in
 	}, metav1.PatchOptions{})
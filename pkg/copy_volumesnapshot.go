@@ -21,8 +21,13 @@ import (
 	"fmt"
 
 	vsapi "github.com/kubernetes-csi/external-snapshotter/client/v7/apis/volumesnapshot/v1"
+	vs_cs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
 	"github.com/spf13/cobra"
+	"gomodules.xyz/pointer"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
 	"k8s.io/klog/v2"
 	vsu "kmodules.xyz/csi-utils/volumesnapshot/v1"
 )
@@ -31,45 +36,141 @@ func NewCmdCopyVolumeSnapshot() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:               "volumesnapshot",
 		Short:             `Copy VolumeSnapshot`,
-		Long:              `Copy VolumeSnapshot from one namespace to another namespace`,
+		Long:              `Copy VolumeSnapshot from one namespace to another namespace by binding a new pre-provisioned VolumeSnapshotContent with the same snapshot handle to the copy`,
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if len(args) == 0 || args[0] == "" {
 				return fmt.Errorf("volumeSnapshot name not found")
 			}
 
-			volumeSnapshotName := args[0]
+			_, err := copyVolumeSnapshot(args[0])
+			return err
+		},
+	}
 
-			// get source VolumeSnapshot object
-			vs, err := vsClient.SnapshotV1().VolumeSnapshots(srcNamespace).Get(context.TODO(), volumeSnapshotName, metav1.GetOptions{})
-			if err != nil {
-				return err
-			}
+	return cmd
+}
 
-			// copy the VolumeSnapshot to new namespace
-			meta := metav1.ObjectMeta{
-				Name:        vs.Name,
-				Namespace:   dstNamespace,
-				Labels:      vs.Labels,
-				Annotations: vs.Annotations,
-			}
-			vs, err = createVolumeSnapshot(vs, meta)
-			if err != nil {
-				return err
-			}
+// copyVolumeSnapshot copies a ready to use VolumeSnapshot from the source namespace to the destination
+// namespace and waits until the copy is ready to use.
+func copyVolumeSnapshot(name string) (*vsapi.VolumeSnapshot, error) {
+	// get source VolumeSnapshot object
+	vs, err := vsClient.SnapshotV1().VolumeSnapshots(srcNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if vs.Status == nil || vs.Status.BoundVolumeSnapshotContentName == nil || vs.Status.ReadyToUse == nil || !*vs.Status.ReadyToUse {
+		return nil, fmt.Errorf("VolumeSnapshot %s/%s is not ready to use yet", vs.Namespace, vs.Name)
+	}
+
+	// get the VolumeSnapshotContent bound to the source VolumeSnapshot to find the snapshot handle
+	content, err := vsClient.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), *vs.Status.BoundVolumeSnapshotContentName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	snapshotHandle := content.Spec.Source.SnapshotHandle
+	if content.Status != nil && content.Status.SnapshotHandle != nil {
+		snapshotHandle = content.Status.SnapshotHandle
+	}
+	if snapshotHandle == nil {
+		return nil, fmt.Errorf("VolumeSnapshotContent %s does not have a snapshot handle", content.Name)
+	}
+
+	contentName := copiedVolumeSnapshotContentName(vs.Name)
+	// check whether the VolumeSnapshot already exists in the destination
+	existing, err := dstVsClient.SnapshotV1().VolumeSnapshots(dstNamespace).Get(context.TODO(), vs.Name, metav1.GetOptions{})
+	if err == nil {
+		if existing.Spec.Source.VolumeSnapshotContentName != nil && *existing.Spec.Source.VolumeSnapshotContentName == contentName {
+			klog.Infof("VolumeSnapshot %s/%s is already up to date.", dstNamespace, vs.Name)
+			return existing, waitUntilVolumeSnapshotReadyToUse(dstVsClient, dstNamespace, vs.Name)
+		}
+		// the source of a VolumeSnapshot is immutable, so it can't be overwritten
+		return nil, fmt.Errorf("VolumeSnapshot %s/%s already exists in the destination with a different source. Delete it first to copy again", dstNamespace, vs.Name)
+	} else if !kerr.IsNotFound(err) {
+		return nil, err
+	}
 
-			klog.Infof("VolumeSnapshot %s/%s has been copied to %s namespace successfully.", vs.Namespace, vs.Name, dstNamespace)
-			return nil
+	// create a pre-provisioned VolumeSnapshotContent that points to the same snapshot.
+	// The content is retained so that deleting the copy never deletes the snapshot of the source.
+	klog.Infof("Creating VolumeSnapshotContent %s with snapshot handle %s", contentName, *snapshotHandle)
+	_, err = createVolumeSnapshotContent(dstVsClient, &vsapi.VolumeSnapshotContent{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: contentName,
+		},
+		Spec: vsapi.VolumeSnapshotContentSpec{
+			VolumeSnapshotRef: core.ObjectReference{
+				APIVersion: vsapi.SchemeGroupVersion.String(),
+				Kind:       "VolumeSnapshot",
+				Namespace:  dstNamespace,
+				Name:       vs.Name,
+			},
+			DeletionPolicy:          vsapi.VolumeSnapshotContentRetain,
+			Driver:                  content.Spec.Driver,
+			VolumeSnapshotClassName: content.Spec.VolumeSnapshotClassName,
+			Source: vsapi.VolumeSnapshotContentSource{
+				SnapshotHandle: snapshotHandle,
+			},
+			SourceVolumeMode: content.Spec.SourceVolumeMode,
 		},
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return cmd
+	// copy the VolumeSnapshot to new namespace and bind it to the new VolumeSnapshotContent
+	copied := vs.DeepCopy()
+	copied.Spec.Source = vsapi.VolumeSnapshotSource{
+		VolumeSnapshotContentName: pointer.StringP(contentName),
+	}
+	vs, err = createVolumeSnapshot(dstVsClient, copied, copyObjectMeta(vs.ObjectMeta))
+	if err != nil {
+		return nil, err
+	}
+
+	klog.Infof("Waiting for VolumeSnapshot %s/%s to be ready to use", vs.Namespace, vs.Name)
+	if err = waitUntilVolumeSnapshotReadyToUse(dstVsClient, vs.Namespace, vs.Name); err != nil {
+		return nil, err
+	}
+	klog.Infof("VolumeSnapshot %s/%s has been copied to %s namespace successfully.", srcNamespace, vs.Name, dstNamespace)
+	return vs, nil
 }
 
-func createVolumeSnapshot(vs *vsapi.VolumeSnapshot, meta metav1.ObjectMeta) (*vsapi.VolumeSnapshot, error) {
-	vs, _, err := vsu.CreateOrPatchVolumeSnapshot(context.TODO(), vsClient, meta, func(in *vsapi.VolumeSnapshot) *vsapi.VolumeSnapshot {
+func copiedVolumeSnapshotContentName(vsName string) string {
+	return fmt.Sprintf("snapcontent-%s-%s", dstNamespace, vsName)
+}
+
+func createVolumeSnapshot(client vs_cs.Interface, vs *vsapi.VolumeSnapshot, meta metav1.ObjectMeta) (*vsapi.VolumeSnapshot, error) {
+	vs, _, err := vsu.CreateOrPatchVolumeSnapshot(context.TODO(), client, meta, func(in *vsapi.VolumeSnapshot) *vsapi.VolumeSnapshot {
 		in.Spec = vs.Spec
 		return in
 	}, metav1.PatchOptions{})
 	return vs, err
 }
+
+func createVolumeSnapshotContent(client vs_cs.Interface, content *vsapi.VolumeSnapshotContent) (*vsapi.VolumeSnapshotContent, error) {
+	cur, err := client.SnapshotV1().VolumeSnapshotContents().Create(context.TODO(), content, metav1.CreateOptions{})
+	if kerr.IsAlreadyExists(err) {
+		// a previous attempt has created the content but failed before creating the VolumeSnapshot
+		cur, err = client.SnapshotV1().VolumeSnapshotContents().Get(context.TODO(), content.Name, metav1.GetOptions{})
+		if err == nil && (cur.Spec.Source.SnapshotHandle == nil || *cur.Spec.Source.SnapshotHandle != *content.Spec.Source.SnapshotHandle) {
+			return nil, fmt.Errorf("VolumeSnapshotContent %s already exists with a different snapshot handle", content.Name)
+		}
+	}
+	return cur, err
+}
+
+func waitUntilVolumeSnapshotReadyToUse(client vs_cs.Interface, ns, name string) error {
+	return wait.PollUntilContextTimeout(context.Background(), PullInterval, WaitTimeOut, true, func(ctx context.Context) (bool, error) {
+		vs, err := client.SnapshotV1().VolumeSnapshots(ns).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		if vs.Status == nil {
+			return false, nil
+		}
+		if vs.Status.Error != nil && vs.Status.Error.Message != nil {
+			return true, fmt.Errorf("VolumeSnapshot %s/%s has failed: %s", ns, name, *vs.Status.Error.Message)
+		}
+		return vs.Status.ReadyToUse != nil && *vs.Status.ReadyToUse, nil
+	})
+}
@@ -22,6 +22,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
 	"k8s.io/client-go/kubernetes"
 )
 
@@ -51,12 +52,19 @@ func NewCmdCreate(clientGetter genericclioptions.RESTClientGetter) *cobra.Comman
 				return err
 			}
 
+			dynamicClient, err = dynamic.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
 			return nil
 		},
 	}
-	cmd.AddCommand(NewCmdCreateRepository())
+	cmd.AddCommand(NewCmdCreateRepository(clientGetter))
 	cmd.AddCommand(NewCmdCreateBackupConfiguration())
 	cmd.AddCommand(NewCmdCreateRestoreSession())
+	cmd.AddCommand(NewCmdCreateBackupBatch())
+	cmd.AddCommand(NewCmdCreateRestoreBatch())
 
 	return cmd
 }
@@ -0,0 +1,205 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+
+	"github.com/spf13/cobra"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/templates"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+var createBackupBatchExample = templates.Examples(`
+		# Create a BackupBatch to backup a StatefulSet and a PVC in the same BackupSession
+		# --member=<kind>/<name>[:<paths>[:<volume mounts>]] where paths and volume mounts are comma separated
+		stash create backupbatch app-backup --namespace=demo --repo-name=gcs-repo --schedule="*/30 * * * *" \
+			--member=statefulset/db:/var/lib/mysql:data:/var/lib/mysql \
+			--member=pvc/uploads \
+			--keep-last=5 --prune`)
+
+type backupBatchOption struct {
+	members         []string
+	task            string
+	schedule        string
+	repository      kmapi.ObjectReference
+	retentionPolicy v1alpha1.RetentionPolicy
+}
+
+// batchMember is a target of a BackupBatch or RestoreBatch given as <kind>/<name>[:<paths>[:<volume mounts>]].
+type batchMember struct {
+	ref          v1beta1.TargetRef
+	paths        []string
+	volumeMounts []core.VolumeMount
+}
+
+func NewCmdCreateBackupBatch() *cobra.Command {
+	opt := backupBatchOption{}
+	manifestOpt := manifestOption{}
+	cmd := &cobra.Command{
+		Use:               "backupbatch",
+		Short:             `Create a new BackupBatch`,
+		Long:              `Create a new BackupBatch to backup multiple targets in a single BackupSession`,
+		Example:           createBackupBatchExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || args[0] == "" {
+				return fmt.Errorf("BackupBatch name is not provided")
+			}
+			if err := checkRetentionDryRunFlag(cmd); err != nil {
+				return err
+			}
+			if err := manifestOpt.complete(cmd); err != nil {
+				return err
+			}
+
+			backupBatch, err := opt.newBackupBatch(args[0], namespace)
+			if err != nil {
+				return err
+			}
+			if err = validateBackupBatch(backupBatch, manifestOpt.dryRun == cmdutil.DryRunClient); err != nil {
+				return err
+			}
+			if manifestOpt.dryRun == cmdutil.DryRunClient {
+				return printManifests(manifestOpt.output, backupBatch)
+			}
+			backupBatch, err = createBackupBatch(stashClient, backupBatch, manifestOpt.patchOptions())
+			if err != nil {
+				return err
+			}
+			if manifestOpt.dryRun == cmdutil.DryRunServer {
+				return printManifests(manifestOpt.output, backupBatch)
+			}
+			klog.Infof("BackupBatch %s/%s has been created successfully.", backupBatch.Namespace, backupBatch.Name)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringArrayVar(&opt.members, "member", opt.members, "Member of the batch in <kind>/<name>[:<paths>[:<volume mounts>]] format (i.e. deployment/app:/data:data:/data). Can be repeated")
+	cmd.Flags().StringVar(&opt.task, "task", opt.task, "Name of the Task for the PVC and AppBinding members. Defaults to pvc-backup for PVCs")
+	cmd.Flags().StringVar(&opt.repository.Name, "repo-name", opt.repository.Name, "Name of the Repository")
+	cmd.Flags().StringVar(&opt.repository.Namespace, "repo-namespace", opt.repository.Namespace, "Namespace of the Repository")
+	cmd.Flags().StringVar(&opt.schedule, "schedule", opt.schedule, "Schedule of the Backup")
+	addRetentionPolicyFlags(cmd, &opt.retentionPolicy)
+	addManifestFlags(cmd, &manifestOpt)
+	return cmd
+}
+
+func (opt backupBatchOption) newBackupBatch(name, namespace string) (*v1beta1.BackupBatch, error) {
+	backupBatch := &v1beta1.BackupBatch{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1beta1.BackupBatchSpec{
+			Schedule:        opt.schedule,
+			Repository:      opt.repository,
+			RetentionPolicy: getRetentionPolicy(backupConfigOption{retentionPolicy: opt.retentionPolicy}),
+		},
+	}
+	for _, m := range opt.members {
+		member, err := parseBatchMember(m)
+		if err != nil {
+			return nil, err
+		}
+		target := &v1beta1.BackupTarget{
+			Ref:          member.ref,
+			Paths:        member.paths,
+			VolumeMounts: member.volumeMounts,
+		}
+		var task v1beta1.TaskRef
+		switch member.ref.Kind {
+		case apis.KindPersistentVolumeClaim, apis.KindAppBinding:
+			// the snapshots of the job model members are told apart by their alias
+			target.Alias = member.ref.Name
+			task.Name = opt.task
+			if task.Name == "" && member.ref.Kind == apis.KindPersistentVolumeClaim {
+				task.Name = "pvc-backup"
+			}
+			if task.Name == "" {
+				return nil, fmt.Errorf("Task of member %s is not provided. Use --task", m)
+			}
+		}
+		backupBatch.Spec.Members = append(backupBatch.Spec.Members, v1beta1.BackupConfigurationTemplateSpec{
+			Task:   task,
+			Target: target,
+		})
+	}
+	return backupBatch, nil
+}
+
+func createBackupBatch(client cs.Interface, backupBatch *v1beta1.BackupBatch, opts metav1.PatchOptions) (*v1beta1.BackupBatch, error) {
+	backupBatch, _, err := v1beta1_util.CreateOrPatchBackupBatch(
+		context.TODO(),
+		client.StashV1beta1(),
+		backupBatch.ObjectMeta,
+		func(in *v1beta1.BackupBatch) *v1beta1.BackupBatch {
+			in.Spec = backupBatch.Spec
+			return in
+		},
+		opts,
+	)
+	return backupBatch, err
+}
+
+func parseBatchMember(s string) (*batchMember, error) {
+	// the volume mounts are the rest of the value as they contain ':' themselves
+	parts := strings.SplitN(s, ":", 3)
+	kind, name, err := parseBackupTarget(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("invalid member %q: %w", s, err)
+	}
+	member := &batchMember{
+		ref: v1beta1.TargetRef{
+			APIVersion: targetAPIVersion(kind),
+			Kind:       kind,
+			Name:       name,
+		},
+	}
+	if len(parts) > 1 && parts[1] != "" {
+		member.paths = strings.Split(parts[1], ",")
+	}
+	if len(parts) > 2 && parts[2] != "" {
+		if member.volumeMounts, err = getVolumeMounts(strings.Split(parts[2], ",")); err != nil {
+			return nil, fmt.Errorf("invalid member %q: %w", s, err)
+		}
+	}
+	return member, nil
+}
+
+func targetAPIVersion(kind string) string {
+	switch kind {
+	case apis.KindPersistentVolumeClaim, apis.KindReplicationController:
+		return core.SchemeGroupVersion.String()
+	case apis.KindAppBinding:
+		return appcatalog.SchemeGroupVersion.String()
+	}
+	return "apps/v1"
+}
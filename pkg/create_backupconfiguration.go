@@ -19,16 +19,19 @@ package pkg
 import (
 	"context"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
 	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
 
 	"github.com/spf13/cobra"
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 	"k8s.io/kubectl/pkg/util/templates"
 	kmapi "kmodules.xyz/client-go/api/v1"
 )
@@ -39,7 +42,9 @@ var createBackupConfigExample = templates.Examples(`
         # For Restic driver
         stash create backupconfig ss-backup --namespace=demo --repo-name=gcs-repo --schedule="*/4 * * * *" --target-apiversion=apps/v1 --target-kind=StatefulSet --target-name=stash-demo --paths=/source/data --volume-mounts=source-data:/source/data --keep-last=5 --prune=true
         # For VolumeSnapshotter driver
-         stash create backupconfig statefulset-volume-snapshot --namespace=demo --driver=VolumeSnapshotter --schedule="*/4 * * * *" --target-apiversion=apps/v1 --target-kind=StatefulSet --target-name=stash-demo --replica=1 --volumesnpashotclass=default-snapshot-class --keep-last=5 --prune=true`)
+         stash create backupconfig statefulset-volume-snapshot --namespace=demo --driver=VolumeSnapshotter --schedule="*/4 * * * *" --target-apiversion=apps/v1 --target-kind=StatefulSet --target-name=stash-demo --replica=1 --volumesnpashotclass=default-snapshot-class --keep-last=5 --prune=true
+        # Print the BackupConfiguration manifest instead of creating it
+        stash create backupconfig ss-backup --namespace=demo --repo-name=gcs-repo --schedule="*/4 * * * *" --target-apiversion=apps/v1 --target-kind=StatefulSet --target-name=stash-demo --paths=/source/data --volume-mounts=source-data:/source/data --keep-last=5 --prune=true --dry-run=client -o yaml`)
 
 type backupConfigOption struct {
 	paths        []string
@@ -57,6 +62,7 @@ type backupConfigOption struct {
 
 func NewCmdCreateBackupConfiguration() *cobra.Command {
 	backupConfigOpt := backupConfigOption{}
+	manifestOpt := manifestOption{}
 	cmd := &cobra.Command{
 		Use:               "backupconfig",
 		Short:             `Create a new BackupConfiguration`,
@@ -69,15 +75,31 @@ func NewCmdCreateBackupConfiguration() *cobra.Command {
 			}
 
 			backupConfigName := args[0]
+			if err := checkRetentionDryRunFlag(cmd); err != nil {
+				return err
+			}
+			if err := manifestOpt.complete(cmd); err != nil {
+				return err
+			}
 
 			backupConfig, err := backupConfigOpt.newBackupConfiguration(backupConfigName, namespace)
 			if err != nil {
 				return err
 			}
-			_, err = createBackupConfiguration(backupConfig, backupConfig.ObjectMeta)
+			// the client dry-run does not access the cluster, so only the inputs themselves are validated
+			if err = validateBackupConfiguration(backupConfig, manifestOpt.dryRun == cmdutil.DryRunClient); err != nil {
+				return err
+			}
+			if manifestOpt.dryRun == cmdutil.DryRunClient {
+				return printManifests(manifestOpt.output, backupConfig)
+			}
+			backupConfig, err = createBackupConfiguration(stashClient, backupConfig, backupConfig.ObjectMeta, manifestOpt.patchOptions())
 			if err != nil {
 				return err
 			}
+			if manifestOpt.dryRun == cmdutil.DryRunServer {
+				return printManifests(manifestOpt.output, backupConfig)
+			}
 			klog.Infof("BackupConfiguration %s/%s has been created successfully.", backupConfig.Namespace, backupConfig.Name)
 			return err
 		},
@@ -94,21 +116,44 @@ func NewCmdCreateBackupConfiguration() *cobra.Command {
 	cmd.Flags().StringVar(&backupConfigOpt.volumesnpashotclass, "volumesnpashotclass", backupConfigOpt.volumesnpashotclass, "Name of the VolumeSnapshotClass")
 	cmd.Flags().Int32Var(&backupConfigOpt.replica, "replica", backupConfigOpt.replica, "Replica specifies the number of replicas whose data should be backed up")
 
-	cmd.Flags().Int64Var(&backupConfigOpt.retentionPolicy.KeepLast, "keep-last", backupConfigOpt.retentionPolicy.KeepLast, "Specify value for retention strategy")
-	cmd.Flags().Int64Var(&backupConfigOpt.retentionPolicy.KeepHourly, "keep-hourly", backupConfigOpt.retentionPolicy.KeepHourly, "Specify value for retention strategy")
-	cmd.Flags().Int64Var(&backupConfigOpt.retentionPolicy.KeepDaily, "keep-daily", backupConfigOpt.retentionPolicy.KeepDaily, "Specify value for retention strategy")
-	cmd.Flags().Int64Var(&backupConfigOpt.retentionPolicy.KeepWeekly, "keep-weekly", backupConfigOpt.retentionPolicy.KeepWeekly, "Specify value for retention strategy")
-	cmd.Flags().Int64Var(&backupConfigOpt.retentionPolicy.KeepMonthly, "keep-monthly", backupConfigOpt.retentionPolicy.KeepMonthly, "Specify value for retention strategy")
-	cmd.Flags().Int64Var(&backupConfigOpt.retentionPolicy.KeepYearly, "keep-yearly", backupConfigOpt.retentionPolicy.KeepYearly, "Specify value for retention strategy")
-	cmd.Flags().BoolVar(&backupConfigOpt.retentionPolicy.Prune, "prune", backupConfigOpt.retentionPolicy.Prune, "Specify whether to prune old snapshot data")
-	cmd.Flags().BoolVar(&backupConfigOpt.retentionPolicy.DryRun, "dry-run", backupConfigOpt.retentionPolicy.DryRun, "Specify whether to test retention policy without deleting actual data")
+	addRetentionPolicyFlags(cmd, &backupConfigOpt.retentionPolicy)
 
 	cmd.Flags().StringSliceVar(&backupConfigOpt.paths, "paths", backupConfigOpt.paths, "List of paths to backup")
 	cmd.Flags().StringSliceVar(&backupConfigOpt.volumeMounts, "volume-mounts", backupConfigOpt.volumeMounts, "List of volumes and their mountPaths")
+	addManifestFlags(cmd, &manifestOpt)
 
 	return cmd
 }
 
+func addRetentionPolicyFlags(cmd *cobra.Command, retentionPolicy *v1alpha1.RetentionPolicy) {
+	addKeepRuleFlags(cmd, retentionPolicy)
+	cmd.Flags().BoolVar(&retentionPolicy.Prune, "prune", retentionPolicy.Prune, "Specify whether to prune old snapshot data")
+	cmd.Flags().BoolVar(&retentionPolicy.DryRun, "retention-dry-run", retentionPolicy.DryRun, "Specify whether to test retention policy without deleting actual data")
+}
+
+// checkRetentionDryRunFlag rejects the boolean form of --dry-run. It used to set the dry-run of the retention
+// policy, which is now set by --retention-dry-run, while --dry-run selects the dry-run strategy of the
+// manifests. Accepting it would silently print the objects instead of creating them.
+func checkRetentionDryRunFlag(cmd *cobra.Command) error {
+	flag := cmd.Flags().Lookup("dry-run")
+	if flag == nil || !flag.Changed {
+		return nil
+	}
+	if _, err := strconv.ParseBool(flag.Value.String()); err == nil || flag.Value.String() == flag.NoOptDefVal {
+		return fmt.Errorf(`boolean --dry-run is no longer supported. Use --retention-dry-run to test the retention policy or, --dry-run="none", "server" or "client" to print the objects`)
+	}
+	return nil
+}
+
+func addKeepRuleFlags(cmd *cobra.Command, retentionPolicy *v1alpha1.RetentionPolicy) {
+	cmd.Flags().Int64Var(&retentionPolicy.KeepLast, "keep-last", retentionPolicy.KeepLast, "Specify value for retention strategy")
+	cmd.Flags().Int64Var(&retentionPolicy.KeepHourly, "keep-hourly", retentionPolicy.KeepHourly, "Specify value for retention strategy")
+	cmd.Flags().Int64Var(&retentionPolicy.KeepDaily, "keep-daily", retentionPolicy.KeepDaily, "Specify value for retention strategy")
+	cmd.Flags().Int64Var(&retentionPolicy.KeepWeekly, "keep-weekly", retentionPolicy.KeepWeekly, "Specify value for retention strategy")
+	cmd.Flags().Int64Var(&retentionPolicy.KeepMonthly, "keep-monthly", retentionPolicy.KeepMonthly, "Specify value for retention strategy")
+	cmd.Flags().Int64Var(&retentionPolicy.KeepYearly, "keep-yearly", retentionPolicy.KeepYearly, "Specify value for retention strategy")
+}
+
 func (opt backupConfigOption) newBackupConfiguration(name string, namespace string) (*v1beta1.BackupConfiguration, error) {
 	backupConfig := &v1beta1.BackupConfiguration{
 		ObjectMeta: metav1.ObjectMeta{
@@ -138,16 +183,16 @@ func (opt backupConfigOption) newBackupConfiguration(name string, namespace stri
 	return backupConfig, nil
 }
 
-func createBackupConfiguration(backupConfig *v1beta1.BackupConfiguration, meta metav1.ObjectMeta) (*v1beta1.BackupConfiguration, error) {
+func createBackupConfiguration(client cs.Interface, backupConfig *v1beta1.BackupConfiguration, meta metav1.ObjectMeta, opts metav1.PatchOptions) (*v1beta1.BackupConfiguration, error) {
 	backupConfig, _, err := v1beta1_util.CreateOrPatchBackupConfiguration(
 		context.TODO(),
-		stashClient.StashV1beta1(),
+		client.StashV1beta1(),
 		meta,
 		func(in *v1beta1.BackupConfiguration) *v1beta1.BackupConfiguration {
 			in.Spec = backupConfig.Spec
 			return in
 		},
-		metav1.PatchOptions{},
+		opts,
 	)
 	return backupConfig, err
 }
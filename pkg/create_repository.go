@@ -18,22 +18,42 @@ package pkg
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 
 	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
 	"stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1alpha1/util"
+	"stash.appscode.dev/apimachinery/pkg/restic"
 
+	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/klog/v2"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 	"k8s.io/kubectl/pkg/util/templates"
+	core_util "kmodules.xyz/client-go/core/v1"
 	storage "kmodules.xyz/objectstore-api/api/v1"
 )
 
 var createRepositoryExample = templates.Examples(`
 		# Create a new repository
 		stash create repository --namespace=<namespace> <repository-name> [Flag]
-        stash create repository gcs-repo --namespace=demo --secret=gcs-secret --bucket=appscode-qa --prefix=/source/data --provider=gcs`)
+        stash create repository gcs-repo --namespace=demo --secret=gcs-secret --bucket=appscode-qa --prefix=/source/data --provider=gcs
+
+		# Create the storage Secret along with the repository, check that the bucket is reachable and, initialize the repository
+		stash create repository s3-repo --namespace=demo --secret=s3-secret --bucket=stash --prefix=demo --provider=s3 \
+			--from-env-file=s3.env --password=<restic-password> --verify --init
+
+		# Print the Repository manifest instead of creating it
+		stash create repository gcs-repo --namespace=demo --secret=gcs-secret --bucket=appscode-qa --provider=gcs --dry-run=client -o yaml`)
 
 type repositoryOption struct {
 	provider       string
@@ -42,10 +62,29 @@ type repositoryOption struct {
 	maxConnections int64
 	secret         string
 	prefix         string
+	region         string
+	insecureTLS    bool
+
+	// local backend
+	pvc       string
+	nfsServer string
+	nfsPath   string
+	hostPath  string
+	mountPath string
+	subPath   string
 }
 
-func NewCmdCreateRepository() *cobra.Command {
+type repositorySecretOption struct {
+	envFile   string
+	fromFiles []string
+	password  string
+}
+
+func NewCmdCreateRepository(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
 	repoOpt := repositoryOption{}
+	secretOpt := repositorySecretOption{}
+	manifestOpt := manifestOption{}
+	var verify, initRepo bool
 	cmd := &cobra.Command{
 		Use:               "repository",
 		Short:             `Create a new repository`,
@@ -58,10 +97,84 @@ func NewCmdCreateRepository() *cobra.Command {
 			}
 
 			repositoryName := args[0]
+			if repoOpt.secret == "" {
+				return fmt.Errorf("storage Secret is not provided. Use --secret")
+			}
+			if err := manifestOpt.complete(cmd); err != nil {
+				return err
+			}
+			backend, err := repoOpt.getBackendInfo()
+			if err != nil {
+				return err
+			}
+			repository := newRepository(backend, repositoryName, namespace)
+
+			// the client dry-run only prints the objects, so it does not need access to the cluster
+			if manifestOpt.dryRun == cmdutil.DryRunClient {
+				if !secretOpt.isSet() {
+					return printManifests(manifestOpt.output, repository)
+				}
+				secret, err := secretOpt.newStorageSecret(repoOpt.secret)
+				if err != nil {
+					return err
+				}
+				if err = validateStorageSecret(repoOpt.provider, secret); err != nil {
+					return err
+				}
+				return printManifests(manifestOpt.output, secret, repository)
+			}
+
+			var secret *core.Secret
+			if secretOpt.isSet() {
+				if secret, err = secretOpt.ensureStorageSecret(repoOpt.secret, manifestOpt.patchOptions()); err != nil {
+					return err
+				}
+			} else {
+				secret, err = kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), repoOpt.secret, metav1.GetOptions{})
+				if err != nil {
+					if kerr.IsNotFound(err) {
+						return fmt.Errorf("storage Secret %s/%s not found. Use --from-env-file, --from-file and --password to create it", namespace, repoOpt.secret)
+					}
+					return err
+				}
+			}
+			if err = validateStorageSecret(repoOpt.provider, secret); err != nil {
+				return err
+			}
+
+			if manifestOpt.dryRun == cmdutil.DryRunServer {
+				if verify || initRepo {
+					klog.Infof("Skipping backend verification and initialization in dry-run mode.")
+				}
+				repository, err = createRepository(stashClient, repository, repository.ObjectMeta, manifestOpt.patchOptions())
+				if err != nil {
+					return err
+				}
+				if secretOpt.isSet() {
+					return printManifests(manifestOpt.output, secret, repository)
+				}
+				return printManifests(manifestOpt.output, repository)
+			}
 
-			repository := newRepository(repoOpt, repositoryName, namespace)
+			initialized := false
+			if verify {
+				cfg, err := clientGetter.ToRESTConfig()
+				if err != nil {
+					return errors.Wrap(err, "failed to read kubeconfig")
+				}
+				if initialized, err = verifyBackend(cfg, repository); err != nil {
+					return err
+				}
+			}
+			if initRepo {
+				if initialized {
+					klog.Infof("Restic repository already exists in the backend. Skipping initialization.")
+				} else if err = initResticRepository(repository, secret); err != nil {
+					return err
+				}
+			}
 
-			repository, err := createRepository(repository, repository.ObjectMeta)
+			repository, err = createRepository(stashClient, repository, repository.ObjectMeta, manifestOpt.patchOptions())
 			if err != nil {
 				return err
 			}
@@ -69,45 +182,234 @@ func NewCmdCreateRepository() *cobra.Command {
 			return err
 		},
 	}
-	cmd.Flags().StringVar(&repoOpt.provider, "provider", repoOpt.provider, "Backend provider (i.e. gcs, s3, azure etc)")
+	addRepositoryFlags(cmd, &repoOpt)
+	cmd.Flags().StringVar(&secretOpt.envFile, "from-env-file", secretOpt.envFile, "Create the storage Secret from a file of KEY=VALUE lines (i.e. AWS_ACCESS_KEY_ID=...)")
+	cmd.Flags().StringArrayVar(&secretOpt.fromFiles, "from-file", secretOpt.fromFiles, "Add a key to the storage Secret from a file in KEY=path format (i.e. GOOGLE_SERVICE_ACCOUNT_JSON_KEY=sa.json)")
+	cmd.Flags().StringVar(&secretOpt.password, "password", secretOpt.password, "Restic password to store in the storage Secret")
+	cmd.Flags().BoolVar(&verify, "verify", verify, "Verify that the backend is reachable with the credentials of the storage Secret")
+	cmd.Flags().BoolVar(&initRepo, "init", initRepo, "Initialize the restic repository in the backend")
+	cmd.Flags().StringVar(&imgRestic.Registry, "docker-registry", imgRestic.Registry, "Docker image registry for restic cli")
+	cmd.Flags().StringVar(&imgRestic.Tag, "image-tag", imgRestic.Tag, "Restic docker image tag")
+	addManifestFlags(cmd, &manifestOpt)
+
+	return cmd
+}
+
+func addRepositoryFlags(cmd *cobra.Command, repoOpt *repositoryOption) {
+	cmd.Flags().StringVar(&repoOpt.provider, "provider", repoOpt.provider, "Backend provider (i.e. gcs, s3, azure, b2, swift, rest or local)")
 	cmd.Flags().StringVar(&repoOpt.bucket, "bucket", repoOpt.bucket, "Name of the cloud bucket/container")
-	cmd.Flags().StringVar(&repoOpt.endpoint, "endpoint", repoOpt.endpoint, "Endpoint for s3/s3 compatible backend")
+	cmd.Flags().StringVar(&repoOpt.endpoint, "endpoint", repoOpt.endpoint, "Endpoint for s3/s3 compatible backend or, URL of the rest server")
 	cmd.Flags().Int64Var(&repoOpt.maxConnections, "max-connections", repoOpt.maxConnections, "Specify maximum concurrent connections for GCS, Azure and B2 backend")
 	cmd.Flags().StringVar(&repoOpt.secret, "secret", repoOpt.secret, "Name of the Storage Secret")
 	cmd.Flags().StringVar(&repoOpt.prefix, "prefix", repoOpt.prefix, "Prefix denotes the directory inside the backend")
+	cmd.Flags().StringVar(&repoOpt.region, "region", repoOpt.region, "Region of the s3 bucket")
+	cmd.Flags().BoolVar(&repoOpt.insecureTLS, "insecure-tls", repoOpt.insecureTLS, "Skip TLS certificate verification of the s3 endpoint")
+	cmd.Flags().StringVar(&repoOpt.pvc, "pvc", repoOpt.pvc, "Name of the PVC to use as local backend")
+	cmd.Flags().StringVar(&repoOpt.nfsServer, "nfs-server", repoOpt.nfsServer, "Address of the NFS server to use as local backend")
+	cmd.Flags().StringVar(&repoOpt.nfsPath, "nfs-path", "/", "Exported path of the NFS server")
+	cmd.Flags().StringVar(&repoOpt.hostPath, "host-path", repoOpt.hostPath, "Directory of the host to use as local backend")
+	cmd.Flags().StringVar(&repoOpt.mountPath, "mount-path", repoOpt.mountPath, "Path where the local backend will be mounted inside the workload")
+	cmd.Flags().StringVar(&repoOpt.subPath, "sub-path", repoOpt.subPath, "Sub-directory of the local backend volume to store the repository")
+}
 
-	return cmd
+func (opt repositorySecretOption) isSet() bool {
+	return opt.envFile != "" || len(opt.fromFiles) > 0 || opt.password != ""
+}
+
+// ensureStorageSecret creates the storage Secret or, adds the provided keys to the existing one.
+func (opt repositorySecretOption) ensureStorageSecret(name string, opts metav1.PatchOptions) (*core.Secret, error) {
+	data, err := opt.data()
+	if err != nil {
+		return nil, err
+	}
+
+	secret, vt, err := core_util.CreateOrPatchSecret(context.TODO(), kubeClient, metav1.ObjectMeta{Name: name, Namespace: namespace}, func(in *core.Secret) *core.Secret {
+		if in.Data == nil {
+			in.Data = map[string][]byte{}
+		}
+		for k, v := range data {
+			in.Data[k] = v
+		}
+		return in
+	}, opts)
+	if err != nil {
+		return nil, err
+	}
+	klog.Infof("Storage Secret %s/%s has been %s successfully.", secret.Namespace, secret.Name, vt)
+	return secret, nil
+}
+
+// newStorageSecret returns the storage Secret built from the provided keys without creating it.
+func (opt repositorySecretOption) newStorageSecret(name string) (*core.Secret, error) {
+	data, err := opt.data()
+	if err != nil {
+		return nil, err
+	}
+	return &core.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+		Data:       data,
+	}, nil
+}
+
+func (opt repositorySecretOption) data() (map[string][]byte, error) {
+	data := map[string][]byte{}
+	if opt.envFile != "" {
+		envs, err := parseEnvFile(opt.envFile)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range envs {
+			data[k] = v
+		}
+	}
+	for _, f := range opt.fromFiles {
+		key, path, ok := strings.Cut(f, "=")
+		if !ok || key == "" || path == "" {
+			return nil, fmt.Errorf("invalid --from-file %q. Use KEY=path format", f)
+		}
+		value, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data[key] = value
+	}
+	if opt.password != "" {
+		data[restic.RESTIC_PASSWORD] = []byte(opt.password)
+	}
+	return data, nil
+}
+
+// parseEnvFile reads KEY=VALUE lines. Empty lines and lines starting with # are ignored.
+func parseEnvFile(path string) (map[string][]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	data := map[string][]byte{}
+	for i, line := range strings.Split(string(content), "\n") {
+		line = strings.TrimRight(line, "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		key = strings.TrimSpace(key)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", path, i+1)
+		}
+		if errs := validation.IsEnvVarName(key); len(errs) > 0 {
+			return nil, fmt.Errorf("%s:%d: invalid key %q: %s", path, i+1, key, strings.Join(errs, ", "))
+		}
+		data[key] = []byte(value)
+	}
+	return data, nil
 }
 
-func newRepository(opt repositoryOption, name string, namespace string) *v1alpha1.Repository {
+// validateStorageSecret checks that the Secret has the keys the provider needs. A provider that can
+// authenticate using the identity of the workload accepts a Secret without credentials.
+func validateStorageSecret(provider string, secret *core.Secret) error {
+	has := func(key string) bool {
+		return len(secret.Data[key]) > 0
+	}
+	var missing []string
+	require := func(keys ...string) {
+		for _, k := range keys {
+			if !has(k) {
+				missing = append(missing, k)
+			}
+		}
+	}
+	requirePair := func(a, b, identity string) {
+		switch {
+		case has(a) && !has(b):
+			missing = append(missing, b)
+		case !has(a) && has(b):
+			missing = append(missing, a)
+		case !has(a) && !has(b):
+			klog.Infof("Secret %s/%s has no %s and %s. The backend must be accessible using %s.", secret.Namespace, secret.Name, a, b, identity)
+		}
+	}
+
+	require(restic.RESTIC_PASSWORD)
+	switch provider {
+	case storage.ProviderS3:
+		requirePair(restic.AWS_ACCESS_KEY_ID, restic.AWS_SECRET_ACCESS_KEY, "the IAM role of the workload")
+	case storage.ProviderGCS:
+		requirePair(restic.GOOGLE_PROJECT_ID, restic.GOOGLE_SERVICE_ACCOUNT_JSON_KEY, "the workload identity")
+		if has(restic.GOOGLE_SERVICE_ACCOUNT_JSON_KEY) && !json.Valid(secret.Data[restic.GOOGLE_SERVICE_ACCOUNT_JSON_KEY]) {
+			return fmt.Errorf("%s of Secret %s/%s is not a valid JSON key", restic.GOOGLE_SERVICE_ACCOUNT_JSON_KEY, secret.Namespace, secret.Name)
+		}
+	case storage.ProviderAzure:
+		require(restic.AZURE_ACCOUNT_NAME, restic.AZURE_ACCOUNT_KEY)
+	case storage.ProviderB2:
+		require(restic.B2_ACCOUNT_ID, restic.B2_ACCOUNT_KEY)
+	case storage.ProviderSwift:
+		if !has(restic.OS_AUTH_URL) && !has(restic.ST_AUTH) && !has(restic.OS_STORAGE_URL) {
+			missing = append(missing, restic.OS_AUTH_URL+" (or "+restic.ST_AUTH+", "+restic.OS_STORAGE_URL+")")
+		}
+	case storage.ProviderRest:
+		if has(restic.REST_SERVER_USERNAME) != has(restic.REST_SERVER_PASSWORD) {
+			require(restic.REST_SERVER_USERNAME, restic.REST_SERVER_PASSWORD)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("storage Secret %s/%s is missing the keys required by the %s provider: %s", secret.Namespace, secret.Name, provider, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func newRepository(backend storage.Backend, name string, namespace string) *v1alpha1.Repository {
 	repository := &v1alpha1.Repository{
 		ObjectMeta: metav1.ObjectMeta{
 			Name:      name,
 			Namespace: namespace,
 		},
 		Spec: v1alpha1.RepositorySpec{
-			Backend: opt.getBackendInfo(),
+			Backend: backend,
 		},
 	}
 	return repository
 }
 
 // CreateOrPatch New Secret
-func createRepository(repository *v1alpha1.Repository, meta metav1.ObjectMeta) (*v1alpha1.Repository, error) {
+func createRepository(client cs.Interface, repository *v1alpha1.Repository, meta metav1.ObjectMeta, opts metav1.PatchOptions) (*v1alpha1.Repository, error) {
 	repository, _, err := util.CreateOrPatchRepository(
 		context.TODO(),
-		stashClient.StashV1alpha1(),
+		client.StashV1alpha1(),
 		meta, func(in *v1alpha1.Repository) *v1alpha1.Repository {
 			in.Spec = repository.Spec
 			return in
 		},
-		metav1.PatchOptions{},
+		opts,
 	)
 	return repository, err
 }
 
-func (opt repositoryOption) getBackendInfo() storage.Backend {
+func (opt repositoryOption) getBackendInfo() (storage.Backend, error) {
 	var backend storage.Backend
+	switch opt.provider {
+	case storage.ProviderGCS, storage.ProviderAzure, storage.ProviderS3, storage.ProviderB2, storage.ProviderSwift:
+		if opt.bucket == "" {
+			return backend, fmt.Errorf("bucket is not provided for the %s provider. Use --bucket", opt.provider)
+		}
+	case storage.ProviderRest:
+		if opt.endpoint == "" {
+			return backend, fmt.Errorf("URL of the rest server is not provided. Use --endpoint")
+		}
+	case storage.ProviderLocal:
+	case "":
+		return backend, fmt.Errorf("backend provider is not provided. Use --provider")
+	default:
+		return backend, fmt.Errorf("unknown backend provider %q. Supported providers are: %s", opt.provider, strings.Join([]string{
+			storage.ProviderGCS, storage.ProviderAzure, storage.ProviderS3, storage.ProviderB2, storage.ProviderSwift, storage.ProviderRest, storage.ProviderLocal,
+		}, ", "))
+	}
+	if opt.maxConnections > 0 && opt.provider != storage.ProviderGCS && opt.provider != storage.ProviderAzure && opt.provider != storage.ProviderB2 {
+		return backend, fmt.Errorf("--max-connections is not supported for the %s provider", opt.provider)
+	}
+	if (opt.region != "" || opt.insecureTLS) && opt.provider != storage.ProviderS3 {
+		return backend, fmt.Errorf("--region and --insecure-tls are only supported for the s3 provider")
+	}
+
 	switch opt.provider {
 	case storage.ProviderGCS:
 		backend = storage.Backend{
@@ -128,9 +430,11 @@ func (opt repositoryOption) getBackendInfo() storage.Backend {
 	case storage.ProviderS3:
 		backend = storage.Backend{
 			S3: &storage.S3Spec{
-				Bucket:   opt.bucket,
-				Prefix:   opt.prefix,
-				Endpoint: opt.endpoint,
+				Bucket:      opt.bucket,
+				Prefix:      opt.prefix,
+				Endpoint:    opt.endpoint,
+				Region:      opt.region,
+				InsecureTLS: opt.insecureTLS,
 			},
 		}
 	case storage.ProviderB2:
@@ -149,12 +453,55 @@ func (opt repositoryOption) getBackendInfo() storage.Backend {
 			},
 		}
 	case storage.ProviderRest:
+		// the rest server has no prefix field, the repository is addressed by the path of the URL
+		url := opt.endpoint
+		if prefix := strings.Trim(opt.prefix, "/"); prefix != "" {
+			url = strings.TrimSuffix(url, "/") + "/" + prefix
+		}
 		backend = storage.Backend{
 			Rest: &storage.RestServerSpec{
-				URL: opt.endpoint,
+				URL: url,
 			},
 		}
+	case storage.ProviderLocal:
+		local, err := opt.getLocalSpec()
+		if err != nil {
+			return backend, err
+		}
+		backend = storage.Backend{
+			Local: local,
+		}
 	}
 	backend.StorageSecretName = opt.secret
-	return backend
+	return backend, nil
+}
+
+func (opt repositoryOption) getLocalSpec() (*storage.LocalSpec, error) {
+	local := &storage.LocalSpec{
+		MountPath: opt.mountPath,
+		SubPath:   strings.Trim(opt.prefix+"/"+opt.subPath, "/"),
+	}
+	sources := 0
+	if opt.pvc != "" {
+		sources++
+		local.PersistentVolumeClaim = &core.PersistentVolumeClaimVolumeSource{ClaimName: opt.pvc}
+	}
+	if opt.nfsServer != "" {
+		sources++
+		local.NFS = &core.NFSVolumeSource{Server: opt.nfsServer, Path: opt.nfsPath}
+	}
+	if opt.hostPath != "" {
+		sources++
+		local.HostPath = &core.HostPathVolumeSource{Path: opt.hostPath}
+	}
+	if sources != 1 {
+		return nil, fmt.Errorf("exactly one of --pvc, --nfs-server or --host-path must be provided for the local provider")
+	}
+	if opt.mountPath == "" {
+		return nil, fmt.Errorf("mount path is not provided for the local provider. Use --mount-path")
+	}
+	if !filepath.IsAbs(opt.mountPath) {
+		return nil, fmt.Errorf("mount path %q must be an absolute path", opt.mountPath)
+	}
+	return local, nil
 }
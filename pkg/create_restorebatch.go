@@ -0,0 +1,221 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/templates"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+var createRestoreBatchExample = templates.Examples(`
+		# Restore every member of a BackupBatch from its latest snapshots
+		stash create restorebatch app-restore --namespace=demo --from-backupbatch=app-backup
+
+		# Restore the given members
+		stash create restorebatch app-restore --namespace=demo --repo-name=gcs-repo \
+			--member=statefulset/db:/var/lib/mysql:data:/var/lib/mysql \
+			--member=pvc/uploads`)
+
+type restoreBatchOption struct {
+	fromBackupBatch string
+	members         []string
+	task            string
+	snapshots       []string
+	executionOrder  string
+	repository      kmapi.ObjectReference
+}
+
+func NewCmdCreateRestoreBatch() *cobra.Command {
+	opt := restoreBatchOption{}
+	manifestOpt := manifestOption{}
+	cmd := &cobra.Command{
+		Use:               "restorebatch",
+		Short:             `Create a new RestoreBatch`,
+		Long:              `Create a new RestoreBatch to restore multiple targets. The members can be mirrored from a BackupBatch`,
+		Example:           createRestoreBatchExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || args[0] == "" {
+				return fmt.Errorf("RestoreBatch name is not provided")
+			}
+			if err := manifestOpt.complete(cmd); err != nil {
+				return err
+			}
+
+			restoreBatch, err := opt.newRestoreBatch(args[0], namespace)
+			if err != nil {
+				return err
+			}
+			if manifestOpt.dryRun == cmdutil.DryRunClient {
+				return printManifests(manifestOpt.output, restoreBatch)
+			}
+			restoreBatch, _, err = v1beta1_util.CreateOrPatchRestoreBatch(
+				context.TODO(),
+				stashClient.StashV1beta1(),
+				restoreBatch.ObjectMeta,
+				func(in *v1beta1.RestoreBatch) *v1beta1.RestoreBatch {
+					in.Spec = restoreBatch.Spec
+					return in
+				},
+				manifestOpt.patchOptions(),
+			)
+			if err != nil {
+				return err
+			}
+			if manifestOpt.dryRun == cmdutil.DryRunServer {
+				return printManifests(manifestOpt.output, restoreBatch)
+			}
+			klog.Infof("RestoreBatch %s/%s has been created successfully.", restoreBatch.Namespace, restoreBatch.Name)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.fromBackupBatch, "from-backupbatch", opt.fromBackupBatch, "Name of the BackupBatch whose members will be restored")
+	cmd.Flags().StringArrayVar(&opt.members, "member", opt.members, "Member of the batch in <kind>/<name>[:<paths>[:<volume mounts>]] format (i.e. deployment/app:/data:data:/data). Can be repeated")
+	cmd.Flags().StringVar(&opt.task, "task", opt.task, "Name of the Task for the PVC and AppBinding members. Defaults to pvc-restore for PVCs")
+	cmd.Flags().StringSliceVar(&opt.snapshots, "snapshots", opt.snapshots, "Snapshots to restore. Can not be used with members that have paths. The members without paths restore the latest snapshot by default")
+	cmd.Flags().StringVar(&opt.executionOrder, "execution-order", opt.executionOrder, "Order to restore the members in. One of: Parallel|Sequential")
+	cmd.Flags().StringVar(&opt.repository.Name, "repo-name", opt.repository.Name, "Name of the Repository. Defaults to the Repository of the BackupBatch")
+	cmd.Flags().StringVar(&opt.repository.Namespace, "repo-namespace", opt.repository.Namespace, "Namespace of the Repository")
+	addManifestFlags(cmd, &manifestOpt)
+	return cmd
+}
+
+func (opt restoreBatchOption) newRestoreBatch(name, namespace string) (*v1beta1.RestoreBatch, error) {
+	restoreBatch := &v1beta1.RestoreBatch{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+		},
+		Spec: v1beta1.RestoreBatchSpec{
+			Repository:     opt.repository,
+			ExecutionOrder: v1beta1.ExecutionOrder(opt.executionOrder),
+		},
+	}
+	switch restoreBatch.Spec.ExecutionOrder {
+	case "", v1beta1.Parallel, v1beta1.Sequential:
+	default:
+		return nil, fmt.Errorf("invalid execution order %q. Use %s or %s", opt.executionOrder, v1beta1.Parallel, v1beta1.Sequential)
+	}
+
+	if opt.fromBackupBatch != "" {
+		if len(opt.members) > 0 {
+			return nil, fmt.Errorf("--member can not be used with --from-backupbatch")
+		}
+		backupBatch, err := stashClient.StashV1beta1().BackupBatches(namespace).Get(context.TODO(), opt.fromBackupBatch, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		if restoreBatch.Spec.Repository.Name == "" {
+			restoreBatch.Spec.Repository = backupBatch.Spec.Repository
+		}
+		restoreBatch.Spec.Driver = backupBatch.Spec.Driver
+		for _, m := range backupBatch.Spec.Members {
+			if m.Target == nil {
+				continue
+			}
+			rule, err := opt.restoreRule(m.Target.Ref, m.Target.Paths)
+			if err != nil {
+				return nil, err
+			}
+			restoreBatch.Spec.Members = append(restoreBatch.Spec.Members, v1beta1.RestoreTargetSpec{
+				Task: v1beta1.TaskRef{Name: restoreTaskOf(m.Task.Name)},
+				Target: &v1beta1.RestoreTarget{
+					Ref:          m.Target.Ref,
+					Alias:        m.Target.Alias,
+					VolumeMounts: m.Target.VolumeMounts,
+					Replicas:     m.Target.Replicas,
+					Rules:        []v1beta1.Rule{rule},
+				},
+			})
+		}
+		return restoreBatch, nil
+	}
+
+	if len(opt.members) == 0 {
+		return nil, fmt.Errorf("no member is provided. Use --member or --from-backupbatch")
+	}
+	if restoreBatch.Spec.Repository.Name == "" {
+		return nil, fmt.Errorf("repository is not provided. Use --repo-name")
+	}
+	for _, m := range opt.members {
+		member, err := parseBatchMember(m)
+		if err != nil {
+			return nil, err
+		}
+		rule, err := opt.restoreRule(member.ref, member.paths)
+		if err != nil {
+			return nil, err
+		}
+		target := &v1beta1.RestoreTarget{
+			Ref:          member.ref,
+			VolumeMounts: member.volumeMounts,
+			Rules:        []v1beta1.Rule{rule},
+		}
+		var task v1beta1.TaskRef
+		switch member.ref.Kind {
+		case apis.KindPersistentVolumeClaim, apis.KindAppBinding:
+			// same alias as the one used by "create backupbatch"
+			target.Alias = member.ref.Name
+			task.Name = opt.task
+			if task.Name == "" && member.ref.Kind == apis.KindPersistentVolumeClaim {
+				task.Name = "pvc-restore"
+			}
+			if task.Name == "" {
+				return nil, fmt.Errorf("Task of member %s is not provided. Use --task", m)
+			}
+		}
+		restoreBatch.Spec.Members = append(restoreBatch.Spec.Members, v1beta1.RestoreTargetSpec{
+			Task:   task,
+			Target: target,
+		})
+	}
+	return restoreBatch, nil
+}
+
+// restoreRule returns the rule to restore a member. Stash rejects a rule with both paths and snapshots, as
+// a snapshot holds the data of its own paths, so the latest snapshot is only restored by default for the
+// members without paths.
+func (opt restoreBatchOption) restoreRule(ref v1beta1.TargetRef, paths []string) (v1beta1.Rule, error) {
+	switch {
+	case len(paths) > 0 && len(opt.snapshots) > 0:
+		return v1beta1.Rule{}, fmt.Errorf("--snapshots can not be used for %s/%s as it restores paths %s. A snapshot already contains the data of its paths", ref.Kind, ref.Name, strings.Join(paths, ","))
+	case len(paths) > 0:
+		return v1beta1.Rule{Paths: paths}, nil
+	case len(opt.snapshots) > 0:
+		return v1beta1.Rule{Snapshots: opt.snapshots}, nil
+	}
+	return v1beta1.Rule{Snapshots: []string{"latest"}}, nil
+}
+
+// restoreTaskOf returns the restore counterpart of a backup Task (i.e. pvc-backup -> pvc-restore,
+// postgres-backup-14.0 -> postgres-restore-14.0).
+func restoreTaskOf(backupTask string) string {
+	return strings.Replace(backupTask, "-backup", "-restore", 1)
+}
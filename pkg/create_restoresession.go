@@ -30,6 +30,7 @@ import (
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog/v2"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 	"k8s.io/kubectl/pkg/util/templates"
 	kmapi "kmodules.xyz/client-go/api/v1"
 	ofst "kmodules.xyz/offshoot-api/api/v1"
@@ -66,6 +67,7 @@ type volumeclaimTemplate struct {
 
 func NewCmdCreateRestoreSession() *cobra.Command {
 	restoreSessionOpt := restoreSessionOption{}
+	manifestOpt := manifestOption{}
 	cmd := &cobra.Command{
 		Use:               "restoresession",
 		Short:             `Create a new RestoreSession`,
@@ -78,16 +80,25 @@ func NewCmdCreateRestoreSession() *cobra.Command {
 			}
 
 			restoresessionName := args[0]
+			if err := manifestOpt.complete(cmd); err != nil {
+				return err
+			}
 
 			restoreSession, err := restoreSessionOpt.newRestoreSession(restoresessionName, namespace)
 			if err != nil {
-				return nil
+				return err
+			}
+			if manifestOpt.dryRun == cmdutil.DryRunClient {
+				return printManifests(manifestOpt.output, restoreSession)
 			}
 
-			restoreSession, err = createRestoreSession(restoreSession)
+			restoreSession, err = createRestoreSession(restoreSession, manifestOpt.patchOptions())
 			if err != nil {
 				return err
 			}
+			if manifestOpt.dryRun == cmdutil.DryRunServer {
+				return printManifests(manifestOpt.output, restoreSession)
+			}
 			klog.Infof("RestoreSession %s/%s has been created successfully.", restoreSession.Namespace, restoreSession.Name)
 			return err
 		},
@@ -113,6 +124,7 @@ func NewCmdCreateRestoreSession() *cobra.Command {
 	cmd.Flags().StringVar(&restoreSessionOpt.volumeClaimTemplate.storageClass, "claim.storageclass", restoreSessionOpt.volumeClaimTemplate.storageClass, "Name of the Storage secret for VolumeClaimTemplate")
 	cmd.Flags().StringVar(&restoreSessionOpt.volumeClaimTemplate.size, "claim.size", restoreSessionOpt.volumeClaimTemplate.size, "Total requested size of the VolumeClaimTemplate")
 	cmd.Flags().StringVar(&restoreSessionOpt.volumeClaimTemplate.dataSource, "claim.datasource", restoreSessionOpt.volumeClaimTemplate.dataSource, "DataSource of the VolumeClaimTemplate")
+	addManifestFlags(cmd, &manifestOpt)
 
 	return cmd
 }
@@ -143,7 +155,7 @@ func (opt restoreSessionOption) newRestoreSession(name string, namespace string)
 	return restoreSession, nil
 }
 
-func createRestoreSession(restoreSession *v1beta1.RestoreSession) (*v1beta1.RestoreSession, error) {
+func createRestoreSession(restoreSession *v1beta1.RestoreSession, opts metav1.PatchOptions) (*v1beta1.RestoreSession, error) {
 	restoreSession, _, err := v1beta1_util.CreateOrPatchRestoreSession(
 		context.TODO(),
 		stashClient.StashV1beta1(),
@@ -152,7 +164,7 @@ func createRestoreSession(restoreSession *v1beta1.RestoreSession) (*v1beta1.Rest
 			in.Spec = restoreSession.Spec
 			return in
 		},
-		metav1.PatchOptions{},
+		opts,
 	)
 	return restoreSession, err
 }
@@ -20,6 +20,7 @@ import (
 	"stash.appscode.dev/cli/pkg/debugger"
 
 	"github.com/spf13/cobra"
+	v "gomodules.xyz/x/version"
 	"k8s.io/kubectl/pkg/util/templates"
 )
 
@@ -32,15 +33,20 @@ func NewCmdDebugOperator() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:               "operator",
 		Short:             `Debug Stash operator`,
-		Long:              `Show debugging information for Stash operator`,
+		Long:              `Check the health of Stash operator (APIService, webhooks, CRDs, rollout, license and version compatibility) and show its logs`,
 		Example:           debugOperatorExample,
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			dbgr := debugger.NewDebugger(kubeClient, stashClient, aggrClient, namespace)
+			// report the health checks first, they are useful even if the operator pod is missing
+			healthErr := dbgr.ShowOperatorHealth(v.Version.Version)
 			if err := dbgr.ShowVersionInformation(); err != nil {
 				return err
 			}
-			return dbgr.DebugOperator()
+			if err := dbgr.DebugOperator(); err != nil {
+				return err
+			}
+			return healthErr
 		},
 	}
 	return cmd
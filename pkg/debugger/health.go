@@ -0,0 +1,419 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package debugger
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/apimachinery/apis/repositories"
+	"stash.appscode.dev/apimachinery/apis/stash"
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	"github.com/Masterminds/semver/v3"
+	admissionregistration "k8s.io/api/admissionregistration/v1"
+	apps "k8s.io/api/apps/v1"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	apiregistration "k8s.io/kube-aggregator/pkg/apis/apiregistration/v1"
+)
+
+const (
+	operatorAPIService = "v1alpha1.admission.stash.appscode.com"
+	licenseFileFlag    = "--license-file"
+)
+
+type HealthStatus string
+
+const (
+	HealthPass HealthStatus = "PASS"
+	HealthWarn HealthStatus = "WARN"
+	HealthFail HealthStatus = "FAIL"
+)
+
+// HealthCheck holds the outcome of a single operator health check.
+type HealthCheck struct {
+	Name    string
+	Status  HealthStatus
+	Message string
+}
+
+// expectedResources lists the Stash resources the operator must serve for the plugin to work.
+var expectedResources = map[string][]string{
+	v1alpha1.SchemeGroupVersion.String(): {
+		v1alpha1.ResourcePluralRepository,
+	},
+	v1beta1.SchemeGroupVersion.String(): {
+		v1beta1.ResourcePluralBackupConfiguration,
+		v1beta1.ResourcePluralBackupBatch,
+		v1beta1.ResourcePluralBackupSession,
+		v1beta1.ResourcePluralRestoreSession,
+		v1beta1.ResourcePluralRestoreBatch,
+		v1beta1.ResourcePluralTask,
+		v1beta1.ResourcePluralFunction,
+		v1beta1.ResourcePluralBackupBlueprint,
+	},
+}
+
+// CheckOperatorHealth runs all the operator health checks and returns their results.
+// pluginVersion is the version of this plugin which is compared against the operator image.
+func (opt *options) CheckOperatorHealth(pluginVersion string) []HealthCheck {
+	var checks []HealthCheck
+
+	apiSvc, err := opt.aggrClient.ApiregistrationV1().APIServices().Get(context.TODO(), operatorAPIService, metav1.GetOptions{})
+	if err != nil {
+		checks = append(checks, HealthCheck{Name: "APIService", Status: HealthFail, Message: err.Error()})
+	} else {
+		checks = append(checks, checkAPIService(apiSvc))
+	}
+
+	checks = append(checks, opt.checkWebhookConfigurations()...)
+	checks = append(checks, opt.checkServedResources()...)
+
+	if apiSvc == nil || apiSvc.Spec.Service == nil {
+		return checks
+	}
+	deployment, err := opt.getOperatorDeployment(apiSvc.Spec.Service.Namespace)
+	if err != nil {
+		return append(checks, HealthCheck{Name: "Operator Deployment", Status: HealthFail, Message: err.Error()})
+	}
+	checks = append(checks, checkDeploymentRollout(deployment))
+	checks = append(checks, opt.checkLicense(deployment))
+	checks = append(checks, checkVersionCompatibility(pluginVersion, deployment))
+	return checks
+}
+
+// ShowOperatorHealth prints the result of the operator health checks as a table.
+// It returns an error if any of the checks has failed.
+func (opt *options) ShowOperatorHealth(pluginVersion string) error {
+	checks := opt.CheckOperatorHealth(pluginVersion)
+
+	fmt.Println("\n==================[ Operator Health ]==================")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintf(w, "CHECK\tSTATUS\tMESSAGE\n")
+	var failed int
+	for _, c := range checks {
+		if c.Status == HealthFail {
+			failed++
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\n", c.Name, c.Status, c.Message)
+	}
+	_ = w.Flush()
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d operator health checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+func checkAPIService(apiSvc *apiregistration.APIService) HealthCheck {
+	check := HealthCheck{Name: "APIService " + apiSvc.Name}
+	if !apiSvc.Spec.InsecureSkipTLSVerify && len(apiSvc.Spec.CABundle) == 0 {
+		check.Status = HealthFail
+		check.Message = "caBundle is empty"
+		return check
+	}
+	for _, cond := range apiSvc.Status.Conditions {
+		if cond.Type != apiregistration.Available {
+			continue
+		}
+		if cond.Status == apiregistration.ConditionTrue {
+			check.Status = HealthPass
+			check.Message = "available"
+		} else {
+			check.Status = HealthFail
+			check.Message = fmt.Sprintf("not available (%s: %s)", cond.Reason, cond.Message)
+		}
+		return check
+	}
+	check.Status = HealthWarn
+	check.Message = "availability condition has not been reported yet"
+	return check
+}
+
+func (opt *options) checkWebhookConfigurations() []HealthCheck {
+	var checks []HealthCheck
+
+	validators, err := opt.kubeClient.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		checks = append(checks, HealthCheck{Name: "ValidatingWebhookConfiguration", Status: HealthFail, Message: err.Error()})
+	} else {
+		found := false
+		for _, cfg := range validators.Items {
+			var configs []admissionregistration.WebhookClientConfig
+			for _, wh := range cfg.Webhooks {
+				if isStashWebhook(wh.Name) {
+					configs = append(configs, wh.ClientConfig)
+				}
+			}
+			if len(configs) > 0 {
+				found = true
+				checks = append(checks, checkWebhookClientConfigs("ValidatingWebhookConfiguration "+cfg.Name, configs))
+			}
+		}
+		if !found {
+			checks = append(checks, HealthCheck{Name: "ValidatingWebhookConfiguration", Status: HealthWarn, Message: "no Stash validating webhook registered"})
+		}
+	}
+
+	mutators, err := opt.kubeClient.AdmissionregistrationV1().MutatingWebhookConfigurations().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		checks = append(checks, HealthCheck{Name: "MutatingWebhookConfiguration", Status: HealthFail, Message: err.Error()})
+	} else {
+		found := false
+		for _, cfg := range mutators.Items {
+			var configs []admissionregistration.WebhookClientConfig
+			for _, wh := range cfg.Webhooks {
+				if isStashWebhook(wh.Name) {
+					configs = append(configs, wh.ClientConfig)
+				}
+			}
+			if len(configs) > 0 {
+				found = true
+				checks = append(checks, checkWebhookClientConfigs("MutatingWebhookConfiguration "+cfg.Name, configs))
+			}
+		}
+		if !found {
+			checks = append(checks, HealthCheck{Name: "MutatingWebhookConfiguration", Status: HealthWarn, Message: "no Stash mutating webhook registered"})
+		}
+	}
+	return checks
+}
+
+func isStashWebhook(name string) bool {
+	return strings.HasSuffix(name, stash.GroupName)
+}
+
+func checkWebhookClientConfigs(name string, configs []admissionregistration.WebhookClientConfig) HealthCheck {
+	for _, cc := range configs {
+		if len(cc.CABundle) == 0 {
+			return HealthCheck{Name: name, Status: HealthFail, Message: "caBundle is empty"}
+		}
+		if err := validateCABundle(cc.CABundle); err != nil {
+			return HealthCheck{Name: name, Status: HealthFail, Message: err.Error()}
+		}
+	}
+	return HealthCheck{Name: name, Status: HealthPass, Message: fmt.Sprintf("%d webhook(s) with valid caBundle", len(configs))}
+}
+
+func validateCABundle(caBundle []byte) error {
+	block, _ := pem.Decode(caBundle)
+	if block == nil {
+		return fmt.Errorf("caBundle is not PEM encoded")
+	}
+	if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+		return fmt.Errorf("invalid caBundle: %v", err)
+	}
+	return nil
+}
+
+func (opt *options) checkServedResources() []HealthCheck {
+	var checks []HealthCheck
+	for _, gv := range []string{v1alpha1.SchemeGroupVersion.String(), v1beta1.SchemeGroupVersion.String()} {
+		check := HealthCheck{Name: "CRDs " + gv}
+		resources, err := opt.kubeClient.Discovery().ServerResourcesForGroupVersion(gv)
+		if err != nil {
+			check.Status = HealthFail
+			check.Message = fmt.Sprintf("version is not served: %v", err)
+			checks = append(checks, check)
+			continue
+		}
+		served := map[string]bool{}
+		for _, r := range resources.APIResources {
+			served[r.Name] = true
+		}
+		var missing []string
+		for _, r := range expectedResources[gv] {
+			if !served[r] {
+				missing = append(missing, r)
+			}
+		}
+		if len(missing) > 0 {
+			check.Status = HealthFail
+			check.Message = "missing resources: " + strings.Join(missing, ", ")
+		} else {
+			check.Status = HealthPass
+			check.Message = fmt.Sprintf("%d resources served", len(expectedResources[gv]))
+		}
+		checks = append(checks, check)
+	}
+
+	// the repositories API is served by the operator itself as an aggregated API
+	if _, err := opt.kubeClient.Discovery().ServerResourcesForGroupVersion(repositories.GroupName + "/v1alpha1"); err != nil {
+		checks = append(checks, HealthCheck{Name: "API " + repositories.GroupName, Status: HealthWarn, Message: err.Error()})
+	} else {
+		checks = append(checks, HealthCheck{Name: "API " + repositories.GroupName, Status: HealthPass, Message: "served"})
+	}
+	return checks
+}
+
+func (opt *options) getOperatorDeployment(namespace string) (*apps.Deployment, error) {
+	deployments, err := opt.kubeClient.AppsV1().Deployments(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments.Items {
+		if !strings.Contains(deployments.Items[i].Name, "stash") {
+			continue
+		}
+		if operatorContainer(&deployments.Items[i].Spec.Template.Spec) != nil {
+			return &deployments.Items[i], nil
+		}
+	}
+	return nil, fmt.Errorf("operator deployment not found in namespace %s", namespace)
+}
+
+func operatorContainer(podSpec *core.PodSpec) *core.Container {
+	for i := range podSpec.Containers {
+		if podSpec.Containers[i].Name == apis.OperatorContainer {
+			return &podSpec.Containers[i]
+		}
+	}
+	return nil
+}
+
+func checkDeploymentRollout(deployment *apps.Deployment) HealthCheck {
+	check := HealthCheck{Name: "Deployment " + deployment.Namespace + "/" + deployment.Name}
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := deployment.Status
+	switch {
+	case status.ObservedGeneration < deployment.Generation:
+		check.Status = HealthWarn
+		check.Message = "latest spec has not been observed by the deployment controller yet"
+	case status.UpdatedReplicas < replicas:
+		check.Status = HealthWarn
+		check.Message = fmt.Sprintf("rollout in progress: %d of %d replicas updated", status.UpdatedReplicas, replicas)
+	case status.AvailableReplicas < replicas:
+		check.Status = HealthFail
+		check.Message = fmt.Sprintf("%d of %d replicas available", status.AvailableReplicas, replicas)
+	default:
+		check.Status = HealthPass
+		check.Message = fmt.Sprintf("%d of %d replicas available", status.AvailableReplicas, replicas)
+	}
+	return check
+}
+
+func (opt *options) checkLicense(deployment *apps.Deployment) HealthCheck {
+	check := HealthCheck{Name: "License"}
+	c := operatorContainer(&deployment.Spec.Template.Spec)
+
+	var licenseFile string
+	for i, arg := range c.Args {
+		if strings.HasPrefix(arg, licenseFileFlag+"=") {
+			licenseFile = strings.TrimPrefix(arg, licenseFileFlag+"=")
+		} else if arg == licenseFileFlag && i+1 < len(c.Args) {
+			licenseFile = c.Args[i+1]
+		}
+	}
+	if licenseFile == "" {
+		check.Status = HealthWarn
+		check.Message = "operator is not configured with a license file"
+		return check
+	}
+
+	// find the Secret volume that provides the license file
+	for _, mnt := range c.VolumeMounts {
+		if filepath.Dir(licenseFile) != filepath.Clean(mnt.MountPath) {
+			continue
+		}
+		for _, vol := range deployment.Spec.Template.Spec.Volumes {
+			if vol.Name != mnt.Name || vol.Secret == nil {
+				continue
+			}
+			secret, err := opt.kubeClient.CoreV1().Secrets(deployment.Namespace).Get(context.TODO(), vol.Secret.SecretName, metav1.GetOptions{})
+			if kerr.IsNotFound(err) {
+				check.Status = HealthFail
+				check.Message = fmt.Sprintf("license Secret %s/%s not found", deployment.Namespace, vol.Secret.SecretName)
+				return check
+			}
+			if err != nil {
+				check.Status = HealthWarn
+				check.Message = fmt.Sprintf("failed to read license Secret: %v", err)
+				return check
+			}
+			if len(secret.Data[filepath.Base(licenseFile)]) == 0 {
+				check.Status = HealthFail
+				check.Message = fmt.Sprintf("license Secret %s/%s has no %q key", secret.Namespace, secret.Name, filepath.Base(licenseFile))
+				return check
+			}
+			check.Status = HealthPass
+			check.Message = fmt.Sprintf("license provided by Secret %s/%s", secret.Namespace, secret.Name)
+			return check
+		}
+	}
+	check.Status = HealthWarn
+	check.Message = fmt.Sprintf("license file %s is not mounted from a Secret", licenseFile)
+	return check
+}
+
+// imageTag returns the tag of an image reference, ignoring its digest and the port of its registry.
+func imageTag(image string) string {
+	if i := strings.Index(image, "@"); i >= 0 {
+		image = image[:i]
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	i := strings.LastIndex(name, ":")
+	if i < 0 {
+		return ""
+	}
+	return name[i+1:]
+}
+
+// checkVersionCompatibility compares the plugin version with the operator image version.
+// Matching minor versions are compatible, a minor version skew is reported as a warning
+// and a major version mismatch is reported as a failure.
+func checkVersionCompatibility(pluginVersion string, deployment *apps.Deployment) HealthCheck {
+	check := HealthCheck{Name: "Version Compatibility"}
+	image := operatorContainer(&deployment.Spec.Template.Spec).Image
+	operatorVer, err := semver.NewVersion(imageTag(image))
+	if err != nil {
+		check.Status = HealthWarn
+		check.Message = fmt.Sprintf("failed to detect operator version from image %s", image)
+		return check
+	}
+	pluginVer, err := semver.NewVersion(pluginVersion)
+	if err != nil {
+		check.Status = HealthWarn
+		check.Message = fmt.Sprintf("plugin version %q is not a release version, operator is %s", pluginVersion, operatorVer.Original())
+		return check
+	}
+
+	switch {
+	case pluginVer.Major() != operatorVer.Major():
+		check.Status = HealthFail
+		check.Message = fmt.Sprintf("plugin %s is not compatible with operator %s", pluginVer.Original(), operatorVer.Original())
+	case pluginVer.Minor() != operatorVer.Minor():
+		check.Status = HealthWarn
+		check.Message = fmt.Sprintf("plugin %s and operator %s are from different minor releases", pluginVer.Original(), operatorVer.Original())
+	default:
+		check.Status = HealthPass
+		check.Message = fmt.Sprintf("plugin %s, operator %s", pluginVer.Original(), operatorVer.Original())
+	}
+	return check
+}
@@ -0,0 +1,506 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"gomodules.xyz/pointer"
+	batch "k8s.io/api/batch/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/templates"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	meta_util "kmodules.xyz/client-go/meta"
+)
+
+const (
+	drillLabelKey            = "stash.appscode.com/drill"
+	drillTimeAnnotationKey   = "stash.appscode.com/last-drill-time"
+	drillResultAnnotationKey = "stash.appscode.com/last-drill-result"
+	drillReasonSucceeded     = "DrillSucceeded"
+	drillReasonFailed        = "DrillFailed"
+	drillMountPath           = "/data"
+	drillListImage           = "busybox:1.36"
+	// drillListScript prints the size and the path of all the files under the mount path, one file per line
+	drillListScript = "cd " + drillMountPath + " && find . -type f -exec stat -c '%s %n' {} +"
+)
+
+var drillExample = templates.Examples(`
+		# Restore the latest snapshot of a BackupConfiguration into a scratch namespace and tear it down
+		kubectl stash drill pvc-backup -n demo
+
+		# Verify that the restored data contains all the files of the snapshot with the same sizes
+		kubectl stash drill pvc-backup -n demo --verify-files
+
+		# Verify the restored data with a custom Job. The restored PVC is mounted at /data.
+		kubectl stash drill pvc-backup -n demo --verify-image=busybox --verify-command=sh,-c,"test -f /data/index.html"`)
+
+type drillOptions struct {
+	drillNamespace string
+	verifyImage    string
+	verifyCommand  []string
+	verifyFiles    bool
+	keepNamespace  bool
+	timeout        time.Duration
+}
+
+func NewCmdDrill(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
+	opt := drillOptions{
+		timeout: WaitTimeOut,
+	}
+	cmd := &cobra.Command{
+		Use:               "drill",
+		Short:             `Verify a backup by restoring it into a scratch namespace`,
+		Long:              `Restore the latest snapshot of a BackupConfiguration into an ephemeral namespace, optionally verify the restored data with a Job, record the result on the BackupConfiguration and tear everything down`,
+		Example:           drillExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || args[0] == "" {
+				return fmt.Errorf("BackupConfiguration name is not provided")
+			}
+			if opt.verifyFiles && opt.verifyImage != "" {
+				return fmt.Errorf("--verify-files and --verify-image can not be used together")
+			}
+
+			cfg, err := clientGetter.ToRESTConfig()
+			if err != nil {
+				return errors.Wrap(err, "failed to read kubeconfig")
+			}
+
+			namespace, _, err = clientGetter.ToRawKubeConfigLoader().Namespace()
+			if err != nil {
+				return err
+			}
+
+			kubeClient, err = kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
+			stashClient, err = cs.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+			dstKubeClient, dstStashClient = kubeClient, stashClient
+
+			bc, err := stashClient.StashV1beta1().BackupConfigurations(namespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			drillErr := opt.run(bc)
+			if err := recordDrillResult(bc, drillErr); err != nil {
+				klog.Errorf("Failed to record the drill result on BackupConfiguration %s/%s: %v", bc.Namespace, bc.Name, err)
+			}
+			if drillErr != nil {
+				return fmt.Errorf("drill of BackupConfiguration %s/%s failed: %w", bc.Namespace, bc.Name, drillErr)
+			}
+			klog.Infof("Drill of BackupConfiguration %s/%s has been succeeded.", bc.Namespace, bc.Name)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.drillNamespace, "drill-namespace", opt.drillNamespace, "Name of the scratch namespace to restore into. A unique name is generated if not provided.")
+	cmd.Flags().StringVar(&opt.verifyImage, "verify-image", opt.verifyImage, "Image of the verification Job. The restored PVC is mounted at "+drillMountPath)
+	cmd.Flags().StringSliceVar(&opt.verifyCommand, "verify-command", opt.verifyCommand, "Command of the verification Job")
+	cmd.Flags().BoolVar(&opt.verifyFiles, "verify-files", opt.verifyFiles, "Verify the restored data by comparing its files with the file list of the restored snapshot. Not supported for the local backend.")
+	cmd.Flags().StringVar(&imgRestic.Registry, "docker-registry", imgRestic.Registry, "Docker image registry for restic cli")
+	cmd.Flags().StringVar(&imgRestic.Tag, "image-tag", imgRestic.Tag, "Restic docker image tag")
+	cmd.Flags().BoolVar(&opt.keepNamespace, "keep-namespace", opt.keepNamespace, "Keep the scratch namespace after the drill for inspection")
+	cmd.Flags().DurationVar(&opt.timeout, "timeout", opt.timeout, "Timeout for the verification Job")
+	return cmd
+}
+
+func (opt *drillOptions) run(bc *v1beta1.BackupConfiguration) error {
+	if bc.Spec.Target == nil || bc.Spec.Target.Ref.Kind != apis.KindPersistentVolumeClaim {
+		return fmt.Errorf("drill supports only BackupConfigurations that target a %s", apis.KindPersistentVolumeClaim)
+	}
+	pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(bc.Namespace).Get(context.TODO(), bc.Spec.Target.Ref.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	// the restore helpers copy from srcNamespace to dstNamespace
+	srcNamespace = bc.Namespace
+	if bc.Spec.Repository.Namespace != "" {
+		srcNamespace = bc.Spec.Repository.Namespace
+	}
+	dstNamespace = opt.drillNamespace
+	if dstNamespace == "" {
+		dstNamespace = meta_util.NameWithSuffix("drill-"+bc.Name, strconv.FormatInt(time.Now().Unix(), 10))
+	}
+
+	klog.Infof("Creating scratch namespace %s", dstNamespace)
+	_, err = kubeClient.CoreV1().Namespaces().Create(context.TODO(), &core.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   dstNamespace,
+			Labels: map[string]string{drillLabelKey: bc.Name},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return err
+	}
+	if opt.keepNamespace {
+		defer klog.Infof("Scratch namespace %s has been kept for inspection", dstNamespace)
+	} else {
+		defer teardownDrillNamespace(dstNamespace)
+	}
+
+	repo, err := ensureDrillRepository(bc.Spec.Repository.Name)
+	if err != nil {
+		return err
+	}
+	if opt.verifyFiles && repo.Spec.Backend.Local != nil {
+		return fmt.Errorf("--verify-files is not supported for the local backend of Repository %s/%s", repo.Namespace, repo.Name)
+	}
+	if err = ensurePVC(pvc); err != nil {
+		return err
+	}
+	if err = restorePVC(pvc.Name, kmapi.ObjectReference{Name: bc.Spec.Repository.Name}); err != nil {
+		return err
+	}
+	klog.Infof("Latest snapshot has been restored into PVC %s/%s", dstNamespace, pvc.Name)
+
+	switch {
+	case opt.verifyFiles:
+		return opt.compareFiles(repo, pvc.Name)
+	case opt.verifyImage != "":
+		_, err = opt.runDrillJob(dstNamespace, pvc.Name, opt.verifyImage, opt.verifyCommand)
+		return err
+	}
+	return nil
+}
+
+// ensureDrillRepository copies the Repository and its Storage Secret into the scratch namespace.
+// The copy never wipes out the backend, so that tearing down the scratch namespace can not delete
+// the backed up data of the source Repository.
+func ensureDrillRepository(name string) (*v1alpha1.Repository, error) {
+	repository, err := stashClient.StashV1alpha1().Repositories(srcNamespace).Get(context.TODO(), name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if err = ensureSecret(repository.Spec.Backend.StorageSecretName); err != nil {
+		return nil, err
+	}
+
+	drillRepo := repository.DeepCopy()
+	drillRepo.Spec.WipeOut = false
+	if _, err = createRepository(dstStashClient, drillRepo, copyObjectMeta(repository.ObjectMeta), metav1.PatchOptions{}); err != nil {
+		return nil, err
+	}
+	klog.Infof("Repository %s/%s has been copied to %s namespace with wipeOut disabled.", repository.Namespace, repository.Name, dstNamespace)
+	return repository, nil
+}
+
+// compareFiles compares the files of the restored PVC with the file list of the latest snapshot of the
+// Repository, which is the snapshot that has been restored.
+func (opt *drillOptions) compareFiles(repo *v1alpha1.Repository, pvcName string) error {
+	expected, err := listSnapshotFiles(repo, apis.DefaultHost)
+	if err != nil {
+		return err
+	}
+	out, err := opt.runDrillJob(dstNamespace, pvcName, drillListImage, []string{"sh", "-c", drillListScript})
+	if err != nil {
+		return err
+	}
+	restored, err := parseFileList(out)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for path, size := range expected {
+		got, found := restored[path]
+		switch {
+		case !found:
+			problems = append(problems, fmt.Sprintf("%s: missing", path))
+		case got != size:
+			problems = append(problems, fmt.Sprintf("%s: size %d, expected %d", path, got, size))
+		}
+	}
+	for path := range restored {
+		if _, found := expected[path]; !found {
+			problems = append(problems, fmt.Sprintf("%s: not in the snapshot", path))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("restored data does not match the snapshot:\n%s", strings.Join(problems, "\n"))
+	}
+	klog.Infof("All the %d files of the snapshot have been restored with the expected sizes", len(expected))
+	return nil
+}
+
+// resticNode is an entry of the output of restic ls --json. The first entry describes the snapshot itself.
+type resticNode struct {
+	StructType string   `json:"struct_type"`
+	Type       string   `json:"type"`
+	Path       string   `json:"path"`
+	Size       int64    `json:"size"`
+	Paths      []string `json:"paths"`
+}
+
+// listSnapshotFiles returns the size of the regular files of the latest snapshot of the host, keyed by
+// their path relative to the backed up directory.
+func listSnapshotFiles(repo *v1alpha1.Repository, host string) (map[string]int64, error) {
+	secret, err := kubeClient.CoreV1().Secrets(repo.Namespace).Get(context.TODO(), repo.Spec.Backend.StorageSecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	scratchDir, err := newScratchDir()
+	if err != nil {
+		return nil, err
+	}
+	defer removeScratchDir(scratchDir)
+
+	caPath, err := dumpResticEnv(repo, secret, scratchDir)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"ls", "latest", "--host", host, "--json", "--no-cache"}
+	if caPath != "" {
+		args = append(args, "--cacert", caPath)
+	}
+	out, err := runResticInDocker(scratchDir, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the files of the latest snapshot: %w", err)
+	}
+	return parseSnapshotFiles(out)
+}
+
+func parseSnapshotFiles(out []byte) (map[string]int64, error) {
+	var roots []string
+	files := map[string]int64{}
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var node resticNode
+		if err := dec.Decode(&node); err != nil {
+			return nil, fmt.Errorf("failed to parse the output of restic ls: %w", err)
+		}
+		switch {
+		case node.StructType == "snapshot":
+			roots = node.Paths
+		case node.Type == "file":
+			files[relativeSnapshotPath(node.Path, roots)] = node.Size
+		}
+	}
+	return files, nil
+}
+
+// relativeSnapshotPath strips the backed up directory from the path, as the restore writes the content
+// of that directory into the root of the PVC.
+func relativeSnapshotPath(path string, roots []string) string {
+	for _, root := range roots {
+		if rel := strings.TrimPrefix(path, strings.TrimSuffix(root, "/")+"/"); rel != path {
+			return rel
+		}
+	}
+	return strings.TrimPrefix(path, "/")
+}
+
+// parseFileList parses the "<size> ./<path>" lines printed by drillListScript.
+func parseFileList(out string) (map[string]int64, error) {
+	files := map[string]int64{}
+	for _, line := range strings.Split(out, "\n") {
+		if line == "" {
+			continue
+		}
+		size, path, found := strings.Cut(line, " ")
+		if !found {
+			return nil, fmt.Errorf("unexpected line in the file list: %q", line)
+		}
+		n, err := strconv.ParseInt(size, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected line in the file list: %q", line)
+		}
+		files[strings.TrimPrefix(path, "./")] = n
+	}
+	return files, nil
+}
+
+// runDrillJob runs a Job that mounts the PVC read-only and returns the logs of its Pod.
+// The Job is deleted after it completes.
+func (opt *drillOptions) runDrillJob(ns, pvcName, image string, command []string) (string, error) {
+	job := &batch.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "stash-drill-",
+			Namespace:    ns,
+			Labels:       map[string]string{drillLabelKey: pvcName},
+		},
+		Spec: batch.JobSpec{
+			BackoffLimit: pointer.Int32P(0),
+			Template: core.PodTemplateSpec{
+				Spec: core.PodSpec{
+					RestartPolicy: core.RestartPolicyNever,
+					Containers: []core.Container{
+						{
+							Name:    "verify",
+							Image:   image,
+							Command: command,
+							VolumeMounts: []core.VolumeMount{
+								{
+									Name:      "data",
+									MountPath: drillMountPath,
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+					Volumes: []core.Volume{
+						{
+							Name: "data",
+							VolumeSource: core.VolumeSource{
+								PersistentVolumeClaim: &core.PersistentVolumeClaimVolumeSource{
+									ClaimName: pvcName,
+									ReadOnly:  true,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	job, err := kubeClient.BatchV1().Jobs(ns).Create(context.TODO(), job, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	klog.Infof("Running verification Job %s/%s", job.Namespace, job.Name)
+	defer func() {
+		propagation := metav1.DeletePropagationBackground
+		err := kubeClient.BatchV1().Jobs(ns).Delete(context.TODO(), job.Name, metav1.DeleteOptions{
+			PropagationPolicy: &propagation,
+		})
+		if err != nil {
+			klog.Errorf("Failed to delete Job %s/%s: %v", ns, job.Name, err)
+		}
+	}()
+
+	var failed bool
+	err = wait.PollUntilContextTimeout(context.Background(), PullInterval, opt.timeout, true, func(ctx context.Context) (bool, error) {
+		cur, err := kubeClient.BatchV1().Jobs(ns).Get(ctx, job.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, nil
+		}
+		if cur.Status.Succeeded > 0 {
+			return true, nil
+		}
+		if cur.Status.Failed > 0 {
+			failed = true
+			return true, nil
+		}
+		return false, nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("verification Job %s/%s did not complete: %w", ns, job.Name, err)
+	}
+
+	logs, logErr := jobLogs(ns, job.Name)
+	if failed {
+		return "", fmt.Errorf("verification Job %s/%s has been failed. Logs:\n%s", ns, job.Name, logs)
+	}
+	return strings.TrimSpace(logs), logErr
+}
+
+func jobLogs(ns, jobName string) (string, error) {
+	pods, err := kubeClient.CoreV1().Pods(ns).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: "job-name=" + jobName,
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(pods.Items) == 0 {
+		return "", fmt.Errorf("no Pod found for Job %s/%s", ns, jobName)
+	}
+	data, err := kubeClient.CoreV1().Pods(ns).GetLogs(pods.Items[0].Name, &core.PodLogOptions{}).DoRaw(context.TODO())
+	return string(data), err
+}
+
+func teardownDrillNamespace(ns string) {
+	klog.Infof("Deleting scratch namespace %s", ns)
+	err := kubeClient.CoreV1().Namespaces().Delete(context.TODO(), ns, metav1.DeleteOptions{})
+	if err != nil {
+		klog.Errorf("Failed to delete scratch namespace %s: %v", ns, err)
+	}
+}
+
+// recordDrillResult annotates the BackupConfiguration with the result of the drill and emits an Event for it.
+func recordDrillResult(bc *v1beta1.BackupConfiguration, drillErr error) error {
+	now := time.Now()
+	result, reason, eventType := "Succeeded", drillReasonSucceeded, core.EventTypeNormal
+	message := "Latest snapshot has been restored and verified successfully"
+	if drillErr != nil {
+		result, reason, eventType = "Failed", drillReasonFailed, core.EventTypeWarning
+		message = drillErr.Error()
+	}
+
+	_, _, err := v1beta1_util.PatchBackupConfiguration(context.TODO(), stashClient.StashV1beta1(), bc, func(in *v1beta1.BackupConfiguration) *v1beta1.BackupConfiguration {
+		if in.Annotations == nil {
+			in.Annotations = map[string]string{}
+		}
+		in.Annotations[drillTimeAnnotationKey] = now.UTC().Format(time.RFC3339)
+		in.Annotations[drillResultAnnotationKey] = result
+		return in
+	}, metav1.PatchOptions{})
+	if err != nil {
+		return err
+	}
+
+	event := &core.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: bc.Name + "-",
+			Namespace:    bc.Namespace,
+		},
+		InvolvedObject: core.ObjectReference{
+			APIVersion: v1beta1.SchemeGroupVersion.String(),
+			Kind:       v1beta1.ResourceKindBackupConfiguration,
+			Name:       bc.Name,
+			Namespace:  bc.Namespace,
+			UID:        bc.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Count:          1,
+		FirstTimestamp: metav1.NewTime(now),
+		LastTimestamp:  metav1.NewTime(now),
+		Source:         core.EventSource{Component: "kubectl-stash"},
+	}
+	_, err = kubeClient.CoreV1().Events(bc.Namespace).Create(context.TODO(), event, metav1.CreateOptions{})
+	return err
+}
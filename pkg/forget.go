@@ -0,0 +1,302 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+var forgetExample = templates.Examples(`
+		# Forget the snapshots of a host that are not among its last 10 and, are older than 90 days
+		stash forget gcs-repo --namespace=demo --keep-last=10 --host=host-0 --older-than=90d --prune
+
+		# Forget a list of snapshots in one go
+		stash forget gcs-repo --namespace=demo gcs-repo-c063d146 gcs-repo-7a1b2c3d
+
+		# Show the snapshots of a host that would be forgotten
+		stash forget gcs-repo --namespace=demo --selector=hostname=host-1 --dry-run`)
+
+type forgetOptions struct {
+	kubeClient *kubernetes.Clientset
+	config     *rest.Config
+	repo       *v1alpha1.Repository
+
+	retentionPolicy v1alpha1.RetentionPolicy
+	groupBy         []string
+	selector        string
+	hosts           []string
+	tags            []string
+	paths           []string
+	olderThan       string
+	prune           bool
+	dryRun          bool
+	yes             bool
+}
+
+func NewCmdForget(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
+	opt := forgetOptions{
+		groupBy: []string{"host", "paths"},
+	}
+
+	cmd := &cobra.Command{
+		Use:               "forget <repository> [snapshot...]",
+		Short:             `Forget snapshots of a Repository`,
+		Long:              `Forget the given snapshots, the snapshots matching the filters, or the snapshots that are not kept by a retention policy, using a single restic invocation`,
+		Example:           forgetExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || args[0] == "" {
+				return fmt.Errorf("repository name not found")
+			}
+			if len(args) == 1 && !hasKeepRule(opt.retentionPolicy) && opt.olderThan == "" && opt.selector == "" &&
+				len(opt.hosts) == 0 && len(opt.tags) == 0 && len(opt.paths) == 0 {
+				return fmt.Errorf("no snapshot selected. Provide snapshot IDs, keep rules, --older-than or a filter")
+			}
+
+			var err error
+			opt.config, err = clientGetter.ToRESTConfig()
+			if err != nil {
+				return errors.Wrap(err, "failed to read kubeconfig")
+			}
+			namespace, _, err = clientGetter.ToRawKubeConfigLoader().Namespace()
+			if err != nil {
+				return err
+			}
+			opt.kubeClient, err = kubernetes.NewForConfig(opt.config)
+			if err != nil {
+				return err
+			}
+			kubeClient = opt.kubeClient
+			stashClient, err = cs.NewForConfig(opt.config)
+			if err != nil {
+				return err
+			}
+
+			opt.repo, err = stashClient.StashV1alpha1().Repositories(namespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			snapshots, err := opt.selectSnapshots(args[1:])
+			if err != nil {
+				return err
+			}
+			if len(snapshots) == 0 {
+				klog.Infof("No snapshot of Repository %s/%s would be forgotten.", namespace, opt.repo.Name)
+				return nil
+			}
+			printForgetList(snapshots)
+			if opt.dryRun {
+				return nil
+			}
+			if !opt.yes && !confirm(fmt.Sprintf("Forget %d snapshots from Repository %s/%s?", len(snapshots), namespace, opt.repo.Name)) {
+				return errAborted
+			}
+
+			ids := make([]string, 0, len(snapshots))
+			for _, s := range snapshots {
+				ids = append(ids, opt.snapshotID(s.ID))
+			}
+			if opt.repo.Spec.Backend.Local != nil {
+				if err = opt.forgetFromLocalBackend(ids); err != nil {
+					return err
+				}
+			} else if err = opt.forgetViaDocker(ids); err != nil {
+				return err
+			}
+			klog.Infof("%d snapshots have been forgotten from Repository %s/%s", len(ids), namespace, opt.repo.Name)
+			return nil
+		},
+	}
+
+	addKeepRuleFlags(cmd, &opt.retentionPolicy)
+	cmd.Flags().StringArrayVar(&opt.retentionPolicy.KeepTags, "keep-tag", opt.retentionPolicy.KeepTags, "Keep snapshots with this comma separated list of tags")
+	cmd.Flags().StringSliceVar(&opt.groupBy, "group-by", opt.groupBy, "Apply the keep rules to the snapshots grouped by these fields (host, paths, tags)")
+	cmd.Flags().StringVarP(&opt.selector, "selector", "l", opt.selector, "Only consider the Snapshots matching this label selector (i.e. hostname=host-0)")
+	cmd.Flags().StringSliceVar(&opt.hosts, "host", opt.hosts, "Only consider the snapshots of these hosts")
+	cmd.Flags().StringSliceVar(&opt.tags, "tag", opt.tags, "Only consider the snapshots having these tags")
+	cmd.Flags().StringSliceVar(&opt.paths, "path", opt.paths, "Only consider the snapshots of these paths")
+	cmd.Flags().StringVar(&opt.olderThan, "older-than", opt.olderThan, "Only forget the snapshots older than this duration (e.g., 1y, 6mo, 90d, 24h)")
+	cmd.Flags().BoolVar(&opt.prune, "prune", opt.prune, "Remove the data that is no longer referenced after forgetting the snapshots")
+	cmd.Flags().BoolVar(&opt.dryRun, "dry-run", opt.dryRun, "Only list the snapshots that would be forgotten")
+	cmd.Flags().BoolVarP(&opt.yes, "yes", "y", opt.yes, "Don't ask for confirmation")
+
+	cmd.Flags().StringVar(&imgRestic.Registry, "docker-registry", imgRestic.Registry, "Docker image registry for restic cli")
+	cmd.Flags().StringVar(&imgRestic.Tag, "image-tag", imgRestic.Tag, "Restic docker image tag")
+
+	return cmd
+}
+
+// selectSnapshots returns the snapshots to forget. The snapshots of the Repository are narrowed down by the
+// given IDs and, the filters. The keep rules and --older-than then exclude the snapshots to keep.
+func (opt *forgetOptions) selectSnapshots(ids []string) ([]retentionSnapshot, error) {
+	snapshots, err := listRetentionSnapshots(opt.repo, opt.selector)
+	if err != nil {
+		return nil, err
+	}
+
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[opt.snapshotID(id)] = false
+	}
+	var candidates []retentionSnapshot
+	for _, snap := range snapshots {
+		if len(wanted) > 0 {
+			id := opt.snapshotID(snap.ID)
+			if _, ok := wanted[id]; !ok {
+				continue
+			}
+			wanted[id] = true
+		}
+		if opt.matches(snap) {
+			candidates = append(candidates, snap)
+		}
+	}
+	for id, found := range wanted {
+		if !found {
+			return nil, fmt.Errorf("snapshot %s not found in Repository %s/%s", id, opt.repo.Namespace, opt.repo.Name)
+		}
+	}
+
+	if hasKeepRule(opt.retentionPolicy) {
+		decisions, err := applyRetentionPolicy(candidates, opt.retentionPolicy, opt.groupBy)
+		if err != nil {
+			return nil, err
+		}
+		candidates = candidates[:0]
+		for _, d := range decisions {
+			if !d.Keep {
+				candidates = append(candidates, d.Snapshot)
+			}
+		}
+	}
+
+	if opt.olderThan != "" {
+		cutoff, err := cutoffTime(opt.olderThan, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		selected := candidates[:0]
+		for _, s := range candidates {
+			if s.Time.Before(cutoff) {
+				selected = append(selected, s)
+			}
+		}
+		candidates = selected
+	}
+	return candidates, nil
+}
+
+func (opt *forgetOptions) matches(s retentionSnapshot) bool {
+	if len(opt.hosts) > 0 && !containsString(opt.hosts, s.Host) {
+		return false
+	}
+	if len(opt.tags) > 0 && !containsAllTags(s.Tags, opt.tags) {
+		return false
+	}
+	for _, p := range opt.paths {
+		if !containsString(s.Paths, p) {
+			return false
+		}
+	}
+	return true
+}
+
+// snapshotID returns the restic ID of a snapshot given either by its ID or, by the name of its Snapshot
+// object (<repository>-<id>).
+func (opt *forgetOptions) snapshotID(name string) string {
+	return strings.TrimPrefix(name, opt.repo.Name+"-")
+}
+
+// forgetFromLocalBackend forgets the snapshots, and prunes the repository if --prune is set, in one
+// invocation of stash-enterprise in the pod that mounts the backend.
+func (opt *forgetOptions) forgetFromLocalBackend(ids []string) error {
+	// get the pod that mount this repository as volume
+	pod, err := getBackendMountingPod(opt.kubeClient, opt.repo)
+	if err != nil {
+		return err
+	}
+	command := []string{"/stash-enterprise", "forget"}
+	command = append(command, ids...)
+	if opt.prune {
+		command = append(command, "--prune")
+	}
+	command = append(command, "--repo-name", opt.repo.Name, "--repo-namespace", opt.repo.Namespace)
+	out, err := execCommandOnPod(opt.kubeClient, opt.config, pod, command)
+	if string(out) != "" {
+		klog.Infoln("Output:", string(out))
+	}
+	return enterpriseCommandError("forget", pod, err)
+}
+
+func (opt *forgetOptions) forgetViaDocker(ids []string) error {
+	// get source repository secret
+	secret, err := opt.kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), opt.repo.Spec.Backend.StorageSecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	scratchDir, err := newScratchDir()
+	if err != nil {
+		return err
+	}
+	defer removeScratchDir(scratchDir)
+
+	caPath, err := dumpResticEnv(opt.repo, secret, scratchDir)
+	if err != nil {
+		return err
+	}
+	args := []string{"forget"}
+	args = append(args, ids...)
+	args = append(args, "--no-cache")
+	if opt.prune {
+		args = append(args, "--prune")
+	}
+	if caPath != "" {
+		args = append(args, "--cacert", caPath)
+	}
+	out, err := runResticInDocker(scratchDir, args)
+	klog.Infoln("Output:", string(out))
+	return err
+}
+
+func printForgetList(snapshots []retentionSnapshot) {
+	w := tabwriter.NewWriter(os.Stdout, TableMinWidth, TableTabWidth, TablePadding, TablePadChar, 0)
+	_, _ = fmt.Fprintln(w, "SNAPSHOT\tHOST\tTIME\tPATHS")
+	for _, s := range snapshots {
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.ID, s.Host, s.Time.UTC().Format(OutputTimeFormat), strings.Join(s.Paths, ","))
+	}
+	_ = w.Flush()
+	fmt.Printf("%d snapshots would be forgotten.\n", len(snapshots))
+}
@@ -0,0 +1,690 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
+	"k8s.io/kubectl/pkg/util/templates"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+	storage "kmodules.xyz/objectstore-api/api/v1"
+)
+
+var initExample = templates.Examples(`
+		# Interactively configure the backup of a workload, PVC or database of the namespace
+		kubectl stash init -n demo
+
+		# Answer the questions using flags. Only the missing answers are prompted for.
+		kubectl stash init -n demo --target=statefulset/db --provider=gcs --bucket=stash-backup \
+			--from-env-file=gcs.env --password=<restic-password> --schedule="0 */6 * * *" --keep-last=5
+
+		# Backup a workload without any question
+		kubectl stash init -n demo --target=deployment/app --paths=/source/data --volume-mounts=data:/source/data \
+			--provider=s3 --bucket=stash-backup --endpoint=s3.amazonaws.com --from-env-file=s3.env --password=<restic-password> \
+			--schedule="0 */6 * * *" --keep-last=5
+
+		# Print the Secret, Repository and BackupConfiguration instead of creating them
+		kubectl stash init -n demo --target=pvc/data --dry-run=client -o yaml`)
+
+const defaultInitSchedule = "0 */6 * * *"
+
+type initOptions struct {
+	name      string
+	target    string
+	task      string
+	schedule  string
+	keepLast  int64
+	paths     []string
+	mounts    []string
+	manifest  manifestOption
+	repoOpt   repositoryOption
+	secretOpt repositorySecretOption
+
+	prompt *prompter
+	// existingSecret is set when the credentials of an existing storage Secret are reused
+	existingSecret bool
+	// generatedPassword is set when the restic password has been generated, so that it is shown to the user
+	generatedPassword bool
+}
+
+// backupTarget is a workload, PVC or AppBinding of the namespace that can be backed up.
+type backupTarget struct {
+	ref          v1beta1.TargetRef
+	volumeMounts []core.VolumeMount
+	appType      string
+}
+
+func (t backupTarget) String() string {
+	return t.ref.Kind + "/" + t.ref.Name
+}
+
+func NewCmdInit(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
+	opt := initOptions{}
+	cmd := &cobra.Command{
+		Use:               "init",
+		Short:             `Set up the backup of a target`,
+		Long:              `Discover the workloads, PVCs and AppBindings of the namespace and, create the storage Secret, Repository and BackupConfiguration to backup the selected one`,
+		Example:           initExample,
+		Args:              cobra.NoArgs,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := clientGetter.ToRESTConfig()
+			if err != nil {
+				return errors.Wrap(err, "failed to read kubeconfig")
+			}
+			namespace, _, err = clientGetter.ToRawKubeConfigLoader().Namespace()
+			if err != nil {
+				return err
+			}
+			kubeClient, err = kubernetes.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+			stashClient, err = cs.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+			dynamicClient, err = dynamic.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
+			if err = opt.manifest.complete(cmd); err != nil {
+				return err
+			}
+
+			opt.prompt = newPrompter(os.Stdin, os.Stderr)
+			secret, repository, backupConfig, err := opt.build()
+			if err != nil {
+				return err
+			}
+			if opt.manifest.dryRun == cmdutil.DryRunClient {
+				return opt.print(secret, repository, backupConfig)
+			}
+			return opt.apply(secret, repository, backupConfig)
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.name, "name", opt.name, "Base name of the created objects. Defaults to the name of the target")
+	cmd.Flags().StringVar(&opt.target, "target", opt.target, "Target to backup in <kind>/<name> format (i.e. deployment/app, statefulset/db, pvc/data, appbinding/pg)")
+	cmd.Flags().StringVar(&opt.task, "task", opt.task, "Name of the Task to backup PVCs and databases")
+	cmd.Flags().StringVar(&opt.schedule, "schedule", opt.schedule, "Schedule of the backup in cron format")
+	cmd.Flags().Int64Var(&opt.keepLast, "keep-last", opt.keepLast, "Number of latest snapshots to keep")
+	cmd.Flags().StringSliceVar(&opt.paths, "paths", opt.paths, "List of paths of a workload to backup. Defaults to the mount paths of --volume-mounts")
+	cmd.Flags().StringSliceVar(&opt.mounts, "volume-mounts", opt.mounts, "List of volumes of a workload and their mountPaths in 'volName:mountPath[:subPath]' format. Inferred from the paths if not provided")
+	addRepositoryFlags(cmd, &opt.repoOpt)
+	cmd.Flags().StringVar(&opt.secretOpt.envFile, "from-env-file", opt.secretOpt.envFile, "Create the storage Secret from a file of KEY=VALUE lines (i.e. AWS_ACCESS_KEY_ID=...)")
+	cmd.Flags().StringArrayVar(&opt.secretOpt.fromFiles, "from-file", opt.secretOpt.fromFiles, "Add a key to the storage Secret from a file in KEY=path format (i.e. GOOGLE_SERVICE_ACCOUNT_JSON_KEY=sa.json)")
+	cmd.Flags().StringVar(&opt.secretOpt.password, "password", opt.secretOpt.password, "Restic password to store in the storage Secret. A random one is generated and printed once if empty")
+	addManifestFlags(cmd, &opt.manifest)
+	return cmd
+}
+
+func (opt *initOptions) build() (*core.Secret, *v1alpha1.Repository, *v1beta1.BackupConfiguration, error) {
+	target, err := opt.selectTarget()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if opt.name == "" {
+		opt.name = target.ref.Name
+	}
+	task, err := opt.selectTask(target)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	paths, mounts, err := opt.selectPaths(target)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	backend, err := opt.selectBackend()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	secret, err := opt.storageSecret()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if err = validateStorageSecret(opt.repoOpt.provider, secret); err != nil {
+		return nil, nil, nil, err
+	}
+	repository := newRepository(backend, opt.name+"-repo", namespace)
+
+	if err = opt.selectRetention(); err != nil {
+		return nil, nil, nil, err
+	}
+	backupConfigOpt := backupConfigOption{
+		targetRef:  target.ref,
+		repository: kmapi.ObjectReference{Name: repository.Name, Namespace: repository.Namespace},
+		schedule:   opt.schedule,
+		task:       task,
+		paths:      paths,
+	}
+	backupConfigOpt.retentionPolicy.KeepLast = opt.keepLast
+	backupConfigOpt.retentionPolicy.Prune = true
+	backupConfig, err := backupConfigOpt.newBackupConfiguration(opt.name+"-backup", namespace)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	backupConfig.Spec.Target.VolumeMounts = mounts
+	return secret, repository, backupConfig, nil
+}
+
+func (opt *initOptions) apply(secret *core.Secret, repository *v1alpha1.Repository, backupConfig *v1beta1.BackupConfiguration) error {
+	var err error
+	opts := opt.manifest.patchOptions()
+	if !opt.existingSecret {
+		if secret, err = opt.secretOpt.ensureStorageSecret(secret.Name, opts); err != nil {
+			return err
+		}
+	}
+	if repository, err = createRepository(stashClient, repository, repository.ObjectMeta, opts); err != nil {
+		return err
+	}
+	if backupConfig, err = createBackupConfiguration(stashClient, backupConfig, backupConfig.ObjectMeta, opts); err != nil {
+		return err
+	}
+	if opt.manifest.dryRun == cmdutil.DryRunServer {
+		return opt.print(secret, repository, backupConfig)
+	}
+	klog.Infof("Repository %s/%s has been created successfully.", repository.Namespace, repository.Name)
+	klog.Infof("BackupConfiguration %s/%s has been created successfully.", backupConfig.Namespace, backupConfig.Name)
+	if opt.generatedPassword {
+		fmt.Printf("Generated restic password: %s\nIt is stored in Secret %s/%s. Keep a copy of it, the backed up data can not be restored without it.\n",
+			opt.secretOpt.password, secret.Namespace, secret.Name)
+	}
+	return nil
+}
+
+func (opt *initOptions) print(secret *core.Secret, repository *v1alpha1.Repository, backupConfig *v1beta1.BackupConfiguration) error {
+	if opt.existingSecret {
+		return printManifests(opt.manifest.output, repository, backupConfig)
+	}
+	return printManifests(opt.manifest.output, secret, repository, backupConfig)
+}
+
+func (opt *initOptions) selectTarget() (*backupTarget, error) {
+	targets, err := discoverBackupTargets()
+	if err != nil {
+		return nil, err
+	}
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no workload, PVC or AppBinding found in namespace %s", namespace)
+	}
+	if opt.target != "" {
+		kind, name, err := parseBackupTarget(opt.target)
+		if err != nil {
+			return nil, err
+		}
+		for i := range targets {
+			if targets[i].ref.Kind == kind && targets[i].ref.Name == name {
+				return &targets[i], nil
+			}
+		}
+		return nil, fmt.Errorf("%s/%s not found in namespace %s", kind, name, namespace)
+	}
+
+	choices := make([]string, 0, len(targets))
+	for _, t := range targets {
+		choices = append(choices, t.String())
+	}
+	i, err := opt.prompt.choose("Which target do you want to backup?", choices)
+	if err != nil {
+		return nil, err
+	}
+	return &targets[i], nil
+}
+
+func parseBackupTarget(ref string) (string, string, error) {
+	kind, name, ok := strings.Cut(ref, "/")
+	if !ok || name == "" {
+		return "", "", fmt.Errorf("invalid target %q. Use <kind>/<name> format (i.e. deployment/app)", ref)
+	}
+	switch strings.ToLower(kind) {
+	case "deployment", "deployments", "deploy":
+		return apis.KindDeployment, name, nil
+	case "statefulset", "statefulsets", "sts":
+		return apis.KindStatefulSet, name, nil
+	case "daemonset", "daemonsets", "ds":
+		return apis.KindDaemonSet, name, nil
+	case "persistentvolumeclaim", "persistentvolumeclaims", "pvc":
+		return apis.KindPersistentVolumeClaim, name, nil
+	case "appbinding", "appbindings", "app":
+		return apis.KindAppBinding, name, nil
+	}
+	return "", "", fmt.Errorf("unsupported target kind %q. Supported kinds are: deployment, statefulset, daemonset, pvc, appbinding", kind)
+}
+
+// discoverBackupTargets lists the workloads, PVCs and AppBindings of the namespace. The volume mounts of the
+// workloads are inferred from the volumes that hold data (PVCs, emptyDirs and hostPaths).
+func discoverBackupTargets() ([]backupTarget, error) {
+	var targets []backupTarget
+	ctx := context.TODO()
+
+	deployments, err := kubeClient.AppsV1().Deployments(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments.Items {
+		targets = append(targets, backupTarget{
+			ref:          v1beta1.TargetRef{APIVersion: "apps/v1", Kind: apis.KindDeployment, Name: d.Name},
+			volumeMounts: dataVolumeMounts(d.Spec.Template.Spec, nil),
+		})
+	}
+	statefulSets, err := kubeClient.AppsV1().StatefulSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range statefulSets.Items {
+		var claims []string
+		for _, t := range s.Spec.VolumeClaimTemplates {
+			claims = append(claims, t.Name)
+		}
+		targets = append(targets, backupTarget{
+			ref:          v1beta1.TargetRef{APIVersion: "apps/v1", Kind: apis.KindStatefulSet, Name: s.Name},
+			volumeMounts: dataVolumeMounts(s.Spec.Template.Spec, claims),
+		})
+	}
+	daemonSets, err := kubeClient.AppsV1().DaemonSets(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range daemonSets.Items {
+		targets = append(targets, backupTarget{
+			ref:          v1beta1.TargetRef{APIVersion: "apps/v1", Kind: apis.KindDaemonSet, Name: d.Name},
+			volumeMounts: dataVolumeMounts(d.Spec.Template.Spec, nil),
+		})
+	}
+	pvcs, err := kubeClient.CoreV1().PersistentVolumeClaims(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range pvcs.Items {
+		targets = append(targets, backupTarget{
+			ref: v1beta1.TargetRef{APIVersion: "v1", Kind: apis.KindPersistentVolumeClaim, Name: p.Name},
+		})
+	}
+
+	apps, err := dynamicClient.Resource(appcatalog.SchemeGroupVersion.WithResource(appcatalog.ResourceApps)).Namespace(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil && !kerr.IsNotFound(err) {
+		return nil, err
+	}
+	if err == nil {
+		for _, u := range apps.Items {
+			app := &appcatalog.AppBinding{}
+			if err := runtime.DefaultUnstructuredConverter.FromUnstructured(u.Object, app); err != nil {
+				return nil, err
+			}
+			targets = append(targets, backupTarget{
+				ref:     v1beta1.TargetRef{APIVersion: appcatalog.SchemeGroupVersion.String(), Kind: apis.KindAppBinding, Name: app.Name},
+				appType: string(app.Spec.Type),
+			})
+		}
+	}
+	return targets, nil
+}
+
+// dataVolumeMounts returns the mounts of the volumes that hold data. Config, secret and projected volumes
+// are skipped as they are restored from their source objects.
+func dataVolumeMounts(spec core.PodSpec, claimTemplates []string) []core.VolumeMount {
+	dataVolumes := sets.New[string](claimTemplates...)
+	for _, v := range spec.Volumes {
+		if v.PersistentVolumeClaim != nil || v.EmptyDir != nil || v.HostPath != nil {
+			dataVolumes.Insert(v.Name)
+		}
+	}
+	var mounts []core.VolumeMount
+	seen := sets.New[string]()
+	for _, c := range spec.Containers {
+		for _, m := range c.VolumeMounts {
+			if dataVolumes.Has(m.Name) && !seen.Has(m.MountPath) {
+				seen.Insert(m.MountPath)
+				mounts = append(mounts, core.VolumeMount{Name: m.Name, MountPath: m.MountPath, SubPath: m.SubPath})
+			}
+		}
+	}
+	return mounts
+}
+
+// selectTask picks the Task to backup a PVC or a database. Workloads are backed up by the sidecar, so they
+// do not need one.
+func (opt *initOptions) selectTask(target *backupTarget) (string, error) {
+	var keyword string
+	switch target.ref.Kind {
+	case apis.KindPersistentVolumeClaim:
+		keyword = "pvc-backup"
+	case apis.KindAppBinding:
+		keyword = target.appType[strings.LastIndex(target.appType, "/")+1:]
+		if keyword == "" && opt.task == "" {
+			return "", fmt.Errorf("type of %s is not set, so its backup Task can not be found. Use --task", target)
+		}
+	default:
+		return opt.task, nil
+	}
+	if opt.task != "" {
+		return opt.task, nil
+	}
+
+	tasks, err := stashClient.StashV1beta1().Tasks().List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return "", err
+	}
+	var choices []string
+	for _, t := range tasks.Items {
+		if strings.Contains(t.Name, "backup") && strings.Contains(t.Name, keyword) {
+			choices = append(choices, t.Name)
+		}
+	}
+	if len(choices) == 0 {
+		return "", fmt.Errorf("no backup Task for %s found in the cluster. Install the Stash addon of %s or, use --task", target, keyword)
+	}
+	sort.Strings(choices)
+	if len(choices) == 1 {
+		klog.Infof("Using Task %s", choices[0])
+		return choices[0], nil
+	}
+	i, err := opt.prompt.choose("Which Task do you want to use?", choices)
+	if err != nil {
+		return "", err
+	}
+	return choices[i], nil
+}
+
+// selectPaths returns the paths of a workload to backup and the volume mounts that hold them. They are taken
+// from --paths and --volume-mounts if provided, otherwise asked for among the inferred mount paths.
+func (opt *initOptions) selectPaths(target *backupTarget) ([]string, []core.VolumeMount, error) {
+	if target.ref.Kind == apis.KindPersistentVolumeClaim || target.ref.Kind == apis.KindAppBinding {
+		if len(opt.paths) > 0 || len(opt.mounts) > 0 {
+			return nil, nil, fmt.Errorf("--paths and --volume-mounts are not supported for %s", target)
+		}
+		return nil, nil, nil
+	}
+
+	volumeMounts := target.volumeMounts
+	if len(opt.mounts) > 0 {
+		var err error
+		if volumeMounts, err = getVolumeMounts(opt.mounts); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(volumeMounts) == 0 {
+		return nil, nil, fmt.Errorf("%s does not mount any data volume", target)
+	}
+	paths := opt.paths
+	if len(paths) == 0 {
+		var defaults []string
+		for _, m := range volumeMounts {
+			defaults = append(defaults, m.MountPath)
+		}
+		if len(opt.mounts) > 0 {
+			paths = defaults
+		} else {
+			answer, err := opt.prompt.ask("Paths to backup (comma separated)", strings.Join(defaults, ","))
+			if err != nil {
+				return nil, nil, err
+			}
+			paths = strings.Split(answer, ",")
+		}
+	}
+	return mountsOfPaths(target, paths, volumeMounts)
+}
+
+// mountsOfPaths returns the non-empty paths along with the volume mounts that hold them.
+func mountsOfPaths(target *backupTarget, paths []string, volumeMounts []core.VolumeMount) ([]string, []core.VolumeMount, error) {
+	var selected []string
+	var mounts []core.VolumeMount
+	for _, p := range paths {
+		p = strings.TrimSpace(p)
+		if p == "" {
+			continue
+		}
+		var mount *core.VolumeMount
+		for i, m := range volumeMounts {
+			if p == m.MountPath || strings.HasPrefix(p, strings.TrimSuffix(m.MountPath, "/")+"/") {
+				mount = &volumeMounts[i]
+				break
+			}
+		}
+		if mount == nil {
+			return nil, nil, fmt.Errorf("path %s is not inside any data volume of %s", p, target)
+		}
+		selected = append(selected, p)
+		if !containsVolumeMount(mounts, *mount) {
+			mounts = append(mounts, *mount)
+		}
+	}
+	if len(selected) == 0 {
+		return nil, nil, fmt.Errorf("no path to backup is provided")
+	}
+	return selected, mounts, nil
+}
+
+func containsVolumeMount(mounts []core.VolumeMount, m core.VolumeMount) bool {
+	for _, v := range mounts {
+		if v.MountPath == m.MountPath {
+			return true
+		}
+	}
+	return false
+}
+
+func (opt *initOptions) selectBackend() (storage.Backend, error) {
+	r := &opt.repoOpt
+	var err error
+	if r.provider == "" {
+		providers := []string{storage.ProviderS3, storage.ProviderGCS, storage.ProviderAzure, storage.ProviderB2, storage.ProviderSwift, storage.ProviderRest, storage.ProviderLocal}
+		i, err := opt.prompt.choose("Where do you want to store the backed up data?", providers)
+		if err != nil {
+			return storage.Backend{}, err
+		}
+		r.provider = providers[i]
+	}
+	switch r.provider {
+	case storage.ProviderRest:
+		if r.endpoint == "" {
+			if r.endpoint, err = opt.prompt.ask("URL of the rest server", ""); err != nil {
+				return storage.Backend{}, err
+			}
+		}
+	case storage.ProviderLocal:
+		if r.pvc == "" && r.nfsServer == "" && r.hostPath == "" {
+			if r.pvc, err = opt.prompt.ask("Name of the PVC to store the backed up data", ""); err != nil {
+				return storage.Backend{}, err
+			}
+		}
+		if r.mountPath == "" {
+			r.mountPath = "/safe/data"
+		}
+	default:
+		if r.bucket == "" {
+			if r.bucket, err = opt.prompt.ask("Name of the bucket", ""); err != nil {
+				return storage.Backend{}, err
+			}
+		}
+		if r.provider == storage.ProviderS3 && r.endpoint == "" {
+			if r.endpoint, err = opt.prompt.ask("Endpoint of the s3 compatible storage", "s3.amazonaws.com"); err != nil {
+				return storage.Backend{}, err
+			}
+		}
+	}
+	if r.prefix == "" {
+		r.prefix = namespace + "/" + opt.name
+	}
+	if r.secret == "" {
+		r.secret = opt.name + "-backend"
+	}
+	return r.getBackendInfo()
+}
+
+// storageSecret returns the storage Secret to create. The credentials of an existing Secret are reused when
+// none are provided.
+func (opt *initOptions) storageSecret() (*core.Secret, error) {
+	name := opt.repoOpt.secret
+	if !opt.secretOpt.isSet() {
+		existing, err := kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err == nil {
+			klog.Infof("Using the existing storage Secret %s/%s", namespace, name)
+			opt.existingSecret = true
+			return existing, nil
+		}
+		if !kerr.IsNotFound(err) {
+			return nil, err
+		}
+		if opt.repoOpt.provider != storage.ProviderLocal {
+			if opt.secretOpt.envFile, err = opt.prompt.ask("File with the credentials of the backend in KEY=VALUE format", ""); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if opt.secretOpt.password == "" {
+		password, err := opt.prompt.password("Restic password to encrypt the backed up data (leave empty to generate one)")
+		if err != nil {
+			return nil, err
+		}
+		if password == "" {
+			if password, err = readOrGeneratePassword("", ""); err != nil {
+				return nil, err
+			}
+			opt.generatedPassword = true
+		}
+		opt.secretOpt.password = password
+	}
+
+	return opt.secretOpt.newStorageSecret(name)
+}
+
+func (opt *initOptions) selectRetention() error {
+	var err error
+	if opt.schedule == "" {
+		if opt.schedule, err = opt.prompt.ask("Schedule of the backup in cron format", defaultInitSchedule); err != nil {
+			return err
+		}
+	}
+	if _, err = parseCronSchedule(opt.schedule); err != nil {
+		return fmt.Errorf("invalid schedule %q: %w", opt.schedule, err)
+	}
+	if opt.keepLast == 0 {
+		answer, err := opt.prompt.ask("Number of latest snapshots to keep", "5")
+		if err != nil {
+			return err
+		}
+		if opt.keepLast, err = strconv.ParseInt(answer, 10, 64); err != nil {
+			return fmt.Errorf("invalid number of snapshots to keep %q", answer)
+		}
+	}
+	if opt.keepLast <= 0 {
+		return fmt.Errorf("invalid number of snapshots to keep %d. It must be greater than zero", opt.keepLast)
+	}
+	return nil
+}
+
+// prompter asks questions on out and reads the answers line by line from in.
+type prompter struct {
+	in  *bufio.Reader
+	fd  int
+	out io.Writer
+}
+
+func newPrompter(in *os.File, out io.Writer) *prompter {
+	return &prompter{in: bufio.NewReader(in), fd: int(in.Fd()), out: out}
+}
+
+func (p *prompter) readLine() (string, error) {
+	line, err := p.in.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", fmt.Errorf("failed to read the answer: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
+
+func (p *prompter) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	answer, err := p.readLine()
+	if err != nil {
+		return "", err
+	}
+	if answer == "" {
+		return def, nil
+	}
+	return answer, nil
+}
+
+func (p *prompter) choose(question string, choices []string) (int, error) {
+	fmt.Fprintln(p.out, question)
+	for i, c := range choices {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, c)
+	}
+	for {
+		answer, err := p.ask("Enter a number", "")
+		if err != nil {
+			return 0, err
+		}
+		if i, err := strconv.Atoi(answer); err == nil && i >= 1 && i <= len(choices) {
+			return i - 1, nil
+		}
+		for i, c := range choices {
+			if answer == c {
+				return i, nil
+			}
+		}
+		fmt.Fprintf(p.out, "Invalid choice %q\n", answer)
+	}
+}
+
+// password reads an answer without echoing it when the input is a terminal.
+func (p *prompter) password(question string) (string, error) {
+	fmt.Fprintf(p.out, "%s: ", question)
+	if !term.IsTerminal(p.fd) {
+		return p.readLine()
+	}
+	answer, err := term.ReadPassword(p.fd)
+	fmt.Fprintln(p.out)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(answer)), nil
+}
@@ -17,10 +17,16 @@ limitations under the License.
 package pkg
 
 import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
 	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/client-go/kubernetes"
 )
@@ -58,5 +64,52 @@ func NewCmdKey(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
 	cmd.AddCommand(NewCmdListKeys(clientGetter))
 	cmd.AddCommand(NewCmdUpdateKey(clientGetter))
 	cmd.AddCommand(NewCmdRemoveKey(clientGetter))
+	cmd.AddCommand(NewCmdRotateKey(clientGetter))
 	return cmd
 }
+
+// readNewPassword reads the new password from either the password file or, the Secret key.
+func (opt *keyOptions) readNewPassword() error {
+	if opt.File == "" && opt.newPasswordSecret == "" {
+		return fmt.Errorf("either --new-password-file or --new-password-secret is required")
+	}
+	var err error
+	opt.newPassword, err = readPassword(opt.File, opt.newPasswordSecret)
+	return err
+}
+
+// readPassword reads a password from a file or, from a Secret key given in name/key format.
+// It returns an empty string if neither is provided.
+func readPassword(file, secretRef string) (string, error) {
+	var password string
+	switch {
+	case file != "" && secretRef != "":
+		return "", fmt.Errorf("password file and password Secret can not be used together")
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	case secretRef != "":
+		name, key, ok := strings.Cut(secretRef, "/")
+		if !ok || name == "" || key == "" {
+			return "", fmt.Errorf("invalid password Secret %q. Use name/key format", secretRef)
+		}
+		secret, err := kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+		if err != nil {
+			return "", err
+		}
+		data, found := secret.Data[key]
+		if !found {
+			return "", fmt.Errorf("key %q not found in Secret %s/%s", key, namespace, name)
+		}
+		password = strings.TrimRight(string(data), "\r\n")
+	default:
+		return "", nil
+	}
+	if password == "" {
+		return "", fmt.Errorf("password is empty")
+	}
+	return password, nil
+}
@@ -19,7 +19,6 @@ package pkg
 import (
 	"context"
 	"fmt"
-	"os"
 	"path/filepath"
 
 	"stash.appscode.dev/apimachinery/pkg/restic"
@@ -94,15 +93,17 @@ func (opt *keyOptions) listResticKeys() error {
 		return err
 	}
 
-	if err = os.MkdirAll(ScratchDir, 0o755); err != nil {
+	// keep the dumped credentials in a private directory of this invocation
+	opt.scratchDir, err = newScratchDir()
+	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(ScratchDir)
+	defer removeScratchDir(opt.scratchDir)
 
 	// configure restic wrapper
 	extraOpt := util.ExtraOptions{
 		StorageSecret: secret,
-		ScratchDir:    ScratchDir,
+		ScratchDir:    opt.scratchDir,
 	}
 	// configure setupOption
 	setupOpt, err := util.SetupOptionsForRepository(*opt.repo, extraOpt)
@@ -116,7 +117,7 @@ func (opt *keyOptions) listResticKeys() error {
 	}
 
 	opt.localDirs = cliLocalDirectories{
-		configDir: filepath.Join(ScratchDir, configDirName),
+		configDir: filepath.Join(opt.scratchDir, configDirName),
 	}
 
 	// dump restic's environments into `restic-env` file.
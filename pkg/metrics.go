@@ -0,0 +1,383 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/prometheus/common/expfmt"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/templates"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+const (
+	metricsFormatText        = "text"
+	metricsFormatOpenMetrics = "openmetrics"
+)
+
+var metricsExample = templates.Examples(`
+		# Print the backup metrics of the current namespace in Prometheus text format
+		kubectl stash metrics
+
+		# Write the backup metrics of all namespaces in OpenMetrics format for the node exporter textfile collector
+		kubectl stash metrics -A --format=openmetrics > /var/lib/node_exporter/textfile/stash.prom
+
+		# Serve the backup metrics at :9090/metrics
+		kubectl stash metrics -A --serve=:9090`)
+
+type metricsOptions struct {
+	format        string
+	serve         string
+	allNamespaces bool
+}
+
+func NewCmdMetrics(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
+	opt := metricsOptions{
+		format: metricsFormatText,
+	}
+	cmd := &cobra.Command{
+		Use:               "metrics",
+		Short:             `Export backup state as Prometheus metrics`,
+		Long:              `Compute Prometheus metrics from BackupConfigurations, BackupBatches, BackupSessions and Repositories and print them once or serve them over HTTP`,
+		Example:           metricsExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := clientGetter.ToRESTConfig()
+			if err != nil {
+				return errors.Wrap(err, "failed to read kubeconfig")
+			}
+
+			namespace, _, err = clientGetter.ToRawKubeConfigLoader().Namespace()
+			if err != nil {
+				return err
+			}
+
+			stashClient, err = cs.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
+			ns := namespace
+			if opt.allNamespaces {
+				ns = metav1.NamespaceAll
+			}
+			registry := prometheus.NewRegistry()
+			if err := registry.Register(newMetricsCollector(stashClient, ns)); err != nil {
+				return err
+			}
+
+			if opt.serve != "" {
+				return serveMetrics(opt.serve, registry)
+			}
+			return writeMetrics(os.Stdout, opt.format, registry)
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.format, "format", opt.format, "Output format of the metrics. One of: text|openmetrics")
+	cmd.Flags().StringVar(&opt.serve, "serve", opt.serve, "Serve the metrics at the /metrics path of this address (e.g., :9090) instead of printing them once")
+	cmd.Flags().BoolVarP(&opt.allNamespaces, "all-namespaces", "A", opt.allNamespaces, "Export the metrics of all namespaces")
+	return cmd
+}
+
+func writeMetrics(w io.Writer, format string, gatherer prometheus.Gatherer) error {
+	var fmtType expfmt.FormatType
+	switch format {
+	case metricsFormatText:
+		fmtType = expfmt.TypeTextPlain
+	case metricsFormatOpenMetrics:
+		fmtType = expfmt.TypeOpenMetrics
+	default:
+		return fmt.Errorf("unknown metrics format %q. Supported formats are: text, openmetrics", format)
+	}
+
+	families, err := gatherer.Gather()
+	if err != nil {
+		return err
+	}
+	enc := expfmt.NewEncoder(w, expfmt.NewFormat(fmtType))
+	for _, mf := range families {
+		if err := enc.Encode(mf); err != nil {
+			return err
+		}
+	}
+	if closer, ok := enc.(expfmt.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func serveMetrics(addr string, gatherer prometheus.Gatherer) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(gatherer, promhttp.HandlerOpts{
+		EnableOpenMetrics: true,
+	}))
+	klog.Infof("Serving metrics at %s/metrics", addr)
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+var (
+	targetLabels     = []string{"namespace", "invoker_kind", "invoker_name", "target_kind", "target_name"}
+	repositoryLabels = append(append([]string{}, targetLabels...), "repository")
+
+	lastSuccessDesc = prometheus.NewDesc(
+		"stash_last_success_timestamp",
+		"Unix timestamp of the last successful backup of the target (0 if it never succeeded)",
+		targetLabels, nil,
+	)
+	sessionDurationDesc = prometheus.NewDesc(
+		"stash_session_duration_seconds",
+		"Time taken by the latest completed backup of the target",
+		targetLabels, nil,
+	)
+	backupPausedDesc = prometheus.NewDesc(
+		"stash_backup_paused",
+		"Whether the backup of the target is paused (1) or not (0)",
+		targetLabels, nil,
+	)
+	repositorySizeDesc = prometheus.NewDesc(
+		"stash_repository_size_bytes",
+		"Total size of the repository the target is backed up into",
+		repositoryLabels, nil,
+	)
+	repositorySnapshotCountDesc = prometheus.NewDesc(
+		"stash_repository_snapshot_count",
+		"Number of snapshots in the repository the target is backed up into",
+		repositoryLabels, nil,
+	)
+	scrapeErrorDesc = prometheus.NewDesc(
+		"stash_scrape_error",
+		"Whether reading the Stash resources failed (1) or not (0)",
+		nil, nil,
+	)
+)
+
+type metricsCollector struct {
+	client    cs.Interface
+	namespace string
+}
+
+// newMetricsCollector returns a prometheus.Collector that computes the backup state metrics from
+// the BackupConfigurations, BackupBatches, BackupSessions and Repositories of the namespace.
+// The resources are read on every scrape.
+func newMetricsCollector(client cs.Interface, namespace string) prometheus.Collector {
+	return &metricsCollector{
+		client:    client,
+		namespace: namespace,
+	}
+}
+
+type metricsInvoker struct {
+	namespace  string
+	kind       string
+	name       string
+	paused     bool
+	targets    []v1beta1.TargetRef
+	repository kmapi.ObjectReference
+}
+
+type targetState struct {
+	lastSuccess time.Time
+	duration    *time.Duration
+}
+
+func (c *metricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lastSuccessDesc
+	ch <- sessionDurationDesc
+	ch <- backupPausedDesc
+	ch <- repositorySizeDesc
+	ch <- repositorySnapshotCountDesc
+	ch <- scrapeErrorDesc
+}
+
+func (c *metricsCollector) Collect(ch chan<- prometheus.Metric) {
+	if err := c.collect(ch); err != nil {
+		klog.Errorf("failed to collect Stash metrics: %v", err)
+		ch <- prometheus.MustNewConstMetric(scrapeErrorDesc, prometheus.GaugeValue, 1)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(scrapeErrorDesc, prometheus.GaugeValue, 0)
+}
+
+func (c *metricsCollector) collect(ch chan<- prometheus.Metric) error {
+	invokers, err := c.listInvokers()
+	if err != nil {
+		return err
+	}
+
+	sessions, err := c.client.StashV1beta1().BackupSessions(c.namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	states := targetStates(sessions.Items)
+
+	repoList, err := c.client.StashV1alpha1().Repositories(c.namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	repos := map[string]v1alpha1.Repository{}
+	for _, repo := range repoList.Items {
+		repos[repo.Namespace+"/"+repo.Name] = repo
+	}
+
+	for _, inv := range invokers {
+		repoNamespace := inv.repository.Namespace
+		if repoNamespace == "" {
+			repoNamespace = inv.namespace
+		}
+		repo, repoFound := repos[repoNamespace+"/"+inv.repository.Name]
+
+		for _, target := range inv.targets {
+			labels := []string{inv.namespace, inv.kind, inv.name, target.Kind, target.Name}
+			state := states[stateKey(inv.namespace, inv.kind, inv.name, target)]
+
+			var lastSuccess float64
+			if !state.lastSuccess.IsZero() {
+				lastSuccess = float64(state.lastSuccess.Unix())
+			}
+			ch <- prometheus.MustNewConstMetric(lastSuccessDesc, prometheus.GaugeValue, lastSuccess, labels...)
+			if state.duration != nil {
+				ch <- prometheus.MustNewConstMetric(sessionDurationDesc, prometheus.GaugeValue, state.duration.Seconds(), labels...)
+			}
+			ch <- prometheus.MustNewConstMetric(backupPausedDesc, prometheus.GaugeValue, boolToFloat(inv.paused), labels...)
+
+			if repoFound {
+				repoLabels := make([]string, len(labels), len(labels)+1)
+				copy(repoLabels, labels)
+				repoLabels = append(repoLabels, repoNamespace+"/"+repo.Name)
+				if size, err := parseSize(repo.Status.TotalSize); err == nil {
+					ch <- prometheus.MustNewConstMetric(repositorySizeDesc, prometheus.GaugeValue, float64(size), repoLabels...)
+				}
+				ch <- prometheus.MustNewConstMetric(repositorySnapshotCountDesc, prometheus.GaugeValue, float64(repo.Status.SnapshotCount), repoLabels...)
+			}
+		}
+	}
+	return nil
+}
+
+func (c *metricsCollector) listInvokers() ([]metricsInvoker, error) {
+	var invokers []metricsInvoker
+	bcList, err := c.client.StashV1beta1().BackupConfigurations(c.namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, bc := range bcList.Items {
+		inv := metricsInvoker{
+			namespace:  bc.Namespace,
+			kind:       v1beta1.ResourceKindBackupConfiguration,
+			name:       bc.Name,
+			paused:     bc.Spec.Paused,
+			repository: bc.Spec.Repository,
+		}
+		if bc.Spec.Target != nil {
+			inv.targets = append(inv.targets, bc.Spec.Target.Ref)
+		}
+		invokers = append(invokers, inv)
+	}
+
+	bbList, err := c.client.StashV1beta1().BackupBatches(c.namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, bb := range bbList.Items {
+		inv := metricsInvoker{
+			namespace:  bb.Namespace,
+			kind:       v1beta1.ResourceKindBackupBatch,
+			name:       bb.Name,
+			paused:     bb.Spec.Paused,
+			repository: bb.Spec.Repository,
+		}
+		for _, member := range bb.Spec.Members {
+			if member.Target != nil {
+				inv.targets = append(inv.targets, member.Target.Ref)
+			}
+		}
+		invokers = append(invokers, inv)
+	}
+	return invokers, nil
+}
+
+// targetStates finds the last successful and the latest completed backup of every target.
+func targetStates(sessions []v1beta1.BackupSession) map[string]targetState {
+	states := map[string]targetState{}
+	completedAt := map[string]time.Time{}
+	for _, bs := range sessions {
+		created := bs.CreationTimestamp.Time
+		for _, target := range bs.Status.Targets {
+			key := stateKey(bs.Namespace, bs.Spec.Invoker.Kind, bs.Spec.Invoker.Name, target.Ref)
+			state := states[key]
+			switch target.Phase {
+			case v1beta1.TargetBackupSucceeded:
+				if created.After(state.lastSuccess) {
+					state.lastSuccess = created
+				}
+			case v1beta1.TargetBackupFailed:
+			default:
+				continue
+			}
+			if created.After(completedAt[key]) {
+				completedAt[key] = created
+				d := targetDuration(target)
+				state.duration = &d
+			}
+			states[key] = state
+		}
+	}
+	return states
+}
+
+// targetDuration sums up the backup durations of all the hosts of a target.
+func targetDuration(target v1beta1.BackupTargetStatus) time.Duration {
+	var total time.Duration
+	for _, host := range target.Stats {
+		if d, err := time.ParseDuration(host.Duration); err == nil {
+			total += d
+		}
+	}
+	return total
+}
+
+func stateKey(namespace, invokerKind, invokerName string, target v1beta1.TargetRef) string {
+	return namespace + "/" + invokerKind + "/" + invokerName + "/" + target.Kind + "/" + target.Name
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
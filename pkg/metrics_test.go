@@ -0,0 +1,129 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	"stash.appscode.dev/apimachinery/client/clientset/versioned/fake"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kmapi "kmodules.xyz/client-go/api/v1"
+)
+
+func TestMetricsCollector(t *testing.T) {
+	created := time.Date(2026, 10, 1, 2, 0, 0, 0, time.UTC)
+	target := v1beta1.TargetRef{APIVersion: "apps/v1", Kind: "Deployment", Name: "app"}
+
+	client := fake.NewSimpleClientset(
+		&v1beta1.BackupConfiguration{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-backup", Namespace: "demo"},
+			Spec: v1beta1.BackupConfigurationSpec{
+				Repository: kmapi.ObjectReference{Name: "gcs-repo"},
+				BackupConfigurationTemplateSpec: v1beta1.BackupConfigurationTemplateSpec{
+					Target: &v1beta1.BackupTarget{Ref: target},
+				},
+			},
+		},
+		&v1beta1.BackupSession{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-backup-1", Namespace: "demo", CreationTimestamp: metav1.NewTime(created)},
+			Spec: v1beta1.BackupSessionSpec{
+				Invoker: v1beta1.BackupInvokerRef{Kind: v1beta1.ResourceKindBackupConfiguration, Name: "app-backup"},
+			},
+			Status: v1beta1.BackupSessionStatus{
+				Phase: v1beta1.BackupSessionSucceeded,
+				Targets: []v1beta1.BackupTargetStatus{{
+					Ref:   target,
+					Phase: v1beta1.TargetBackupSucceeded,
+					Stats: []v1beta1.HostBackupStats{{Hostname: "host-0", Duration: "30s"}},
+				}},
+			},
+		},
+		&v1beta1.BackupSession{
+			ObjectMeta: metav1.ObjectMeta{Name: "app-backup-2", Namespace: "demo", CreationTimestamp: metav1.NewTime(created.Add(time.Hour))},
+			Spec: v1beta1.BackupSessionSpec{
+				Invoker: v1beta1.BackupInvokerRef{Kind: v1beta1.ResourceKindBackupConfiguration, Name: "app-backup"},
+			},
+			Status: v1beta1.BackupSessionStatus{
+				Phase: v1beta1.BackupSessionFailed,
+				Targets: []v1beta1.BackupTargetStatus{{
+					Ref:   target,
+					Phase: v1beta1.TargetBackupFailed,
+					Stats: []v1beta1.HostBackupStats{{Hostname: "host-0", Duration: "45s"}},
+				}},
+			},
+		},
+		&v1alpha1.Repository{
+			ObjectMeta: metav1.ObjectMeta{Name: "gcs-repo", Namespace: "demo"},
+			Status: v1alpha1.RepositoryStatus{
+				TotalSize:     "1.000 GiB",
+				SnapshotCount: 5,
+			},
+		},
+	)
+
+	// the object tracker guesses the resource of the objects passed to NewSimpleClientset wrongly for
+	// BackupBatch ("backupbatchs"), so it is created through the client
+	_, err := client.StashV1beta1().BackupBatches("demo").Create(context.TODO(), &v1beta1.BackupBatch{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-batch", Namespace: "demo"},
+		Spec: v1beta1.BackupBatchSpec{
+			Repository: kmapi.ObjectReference{Name: "missing-repo"},
+			Paused:     true,
+			Members: []v1beta1.BackupConfigurationTemplateSpec{
+				{Target: &v1beta1.BackupTarget{Ref: v1beta1.TargetRef{Kind: "StatefulSet", Name: "db"}}},
+				{Target: &v1beta1.BackupTarget{Ref: v1beta1.TargetRef{Kind: "PersistentVolumeClaim", Name: "data"}}},
+			},
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `
+# HELP stash_backup_paused Whether the backup of the target is paused (1) or not (0)
+# TYPE stash_backup_paused gauge
+stash_backup_paused{invoker_kind="BackupBatch",invoker_name="db-batch",namespace="demo",target_kind="PersistentVolumeClaim",target_name="data"} 1
+stash_backup_paused{invoker_kind="BackupBatch",invoker_name="db-batch",namespace="demo",target_kind="StatefulSet",target_name="db"} 1
+stash_backup_paused{invoker_kind="BackupConfiguration",invoker_name="app-backup",namespace="demo",target_kind="Deployment",target_name="app"} 0
+# HELP stash_last_success_timestamp Unix timestamp of the last successful backup of the target (0 if it never succeeded)
+# TYPE stash_last_success_timestamp gauge
+stash_last_success_timestamp{invoker_kind="BackupBatch",invoker_name="db-batch",namespace="demo",target_kind="PersistentVolumeClaim",target_name="data"} 0
+stash_last_success_timestamp{invoker_kind="BackupBatch",invoker_name="db-batch",namespace="demo",target_kind="StatefulSet",target_name="db"} 0
+stash_last_success_timestamp{invoker_kind="BackupConfiguration",invoker_name="app-backup",namespace="demo",target_kind="Deployment",target_name="app"} 1.79082e+09
+# HELP stash_repository_size_bytes Total size of the repository the target is backed up into
+# TYPE stash_repository_size_bytes gauge
+stash_repository_size_bytes{invoker_kind="BackupConfiguration",invoker_name="app-backup",namespace="demo",repository="demo/gcs-repo",target_kind="Deployment",target_name="app"} 1.073741824e+09
+# HELP stash_repository_snapshot_count Number of snapshots in the repository the target is backed up into
+# TYPE stash_repository_snapshot_count gauge
+stash_repository_snapshot_count{invoker_kind="BackupConfiguration",invoker_name="app-backup",namespace="demo",repository="demo/gcs-repo",target_kind="Deployment",target_name="app"} 5
+# HELP stash_scrape_error Whether reading the Stash resources failed (1) or not (0)
+# TYPE stash_scrape_error gauge
+stash_scrape_error 0
+# HELP stash_session_duration_seconds Time taken by the latest completed backup of the target
+# TYPE stash_session_duration_seconds gauge
+stash_session_duration_seconds{invoker_kind="BackupConfiguration",invoker_name="app-backup",namespace="demo",target_kind="Deployment",target_name="app"} 45
+`
+	if err := testutil.CollectAndCompare(newMetricsCollector(client, metav1.NamespaceAll), strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,270 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+
+	vs_cs "github.com/kubernetes-csi/external-snapshotter/client/v7/clientset/versioned"
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+var migratePVCExample = templates.Examples(`
+		# Move the data of a PVC to a new PVC of a CSI StorageClass and, switch the workloads to it
+		stash migrate-pvc data -n demo --storage-class=csi-standard
+
+		# Grow a PVC whose StorageClass does not support volume expansion
+		stash migrate-pvc data -n demo --size=20Gi --name=data-large --provider=s3 --bucket=stash --secret=s3-secret`)
+
+// pvcWorkload is a workload that mounts the migrated PVC through its Pod template.
+type pvcWorkload struct {
+	kind     string
+	name     string
+	volume   string
+	replicas int32
+}
+
+func NewCmdMigratePVC(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
+	repoOpt := repositoryOption{}
+	method := cloneMethodAuto
+	var (
+		snapshotClass string
+		overrides     pvcOverrides
+	)
+	cmd := &cobra.Command{
+		Use:               "migrate-pvc",
+		Short:             `Migrate a PVC to a new PVC`,
+		Long:              `Copy the data of a PVC into a new PVC with a different StorageClass, size or access modes and, switch the workloads using the PVC to the new one`,
+		Example:           migratePVCExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || args[0] == "" {
+				return fmt.Errorf("PVC name is not provided ")
+			}
+			if overrides.StorageClass == "" && overrides.Size == "" && len(overrides.AccessModes) == 0 {
+				return fmt.Errorf("nothing to migrate. Provide at least one of --storage-class, --size or --access-modes")
+			}
+
+			cfg, err := clientGetter.ToRESTConfig()
+			if err != nil {
+				return errors.Wrap(err, "failed to read kubeconfig")
+			}
+			srcNamespace, _, err = clientGetter.ToRawKubeConfigLoader().Namespace()
+			if err != nil {
+				return err
+			}
+			dstNamespace = srcNamespace
+			if kubeClient, err = kubernetes.NewForConfig(cfg); err != nil {
+				return err
+			}
+			if stashClient, err = cs.NewForConfig(cfg); err != nil {
+				return err
+			}
+			if vsClient, err = vs_cs.NewForConfig(cfg); err != nil {
+				return err
+			}
+			dstKubeClient, dstStashClient, dstVsClient = kubeClient, stashClient, vsClient
+
+			pvc, err := kubeClient.CoreV1().PersistentVolumeClaims(srcNamespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if overrides.Name == "" {
+				overrides.Name = pvc.Name + "-migrated"
+			}
+			if overrides.Name == pvc.Name {
+				return fmt.Errorf("name of the new PVC must be different from %s", pvc.Name)
+			}
+			pvcs := []*core.PersistentVolumeClaim{pvc}
+			// validate before scaling down the workloads, as the used size is read from the Pods mounting the PVC
+			if err = overrides.validate(pvcs); err != nil {
+				return err
+			}
+
+			workloads, err := findPVCWorkloads(pvc.Name)
+			if err != nil {
+				return err
+			}
+			if err = scaleDownWorkloads(workloads, pvc.Name); err != nil {
+				return err
+			}
+
+			journal, err := newCloneJournal(pvc.Name, pvcs, method, snapshotClass, repoOpt, &overrides)
+			if err == nil {
+				err = runClone(journal, false)
+			}
+			if err != nil {
+				return restoreWorkloads(workloads, err)
+			}
+
+			if err = switchWorkloads(workloads, overrides.Name); err != nil {
+				return err
+			}
+			klog.Infof("PVC %s/%s has been migrated to %s. Delete PVC %s once you have verified the data.", srcNamespace, pvc.Name, overrides.Name, pvc.Name)
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&method, "method", method, "Method to copy the data. One of: snapshot|restic|auto. auto uses snapshot when the new PVC can be provisioned from a VolumeSnapshot")
+	cmd.Flags().StringVar(&snapshotClass, "volume-snapshot-class", snapshotClass, "Name of the VolumeSnapshotClass to use for the snapshot method")
+	addRepositoryFlags(cmd, &repoOpt)
+	addPVCOverrideFlags(cmd, &overrides)
+	cmd.Flags().Lookup("name").Usage = "Name of the new PVC. Defaults to <pvc>-migrated"
+	return cmd
+}
+
+// findPVCWorkloads returns the Deployments and StatefulSets that mount the PVC through their Pod template.
+// A PVC created from the volumeClaimTemplates of a StatefulSet can not be swapped as the templates are immutable.
+func findPVCWorkloads(claimName string) ([]pvcWorkload, error) {
+	var workloads []pvcWorkload
+	deployments, err := kubeClient.AppsV1().Deployments(srcNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range deployments.Items {
+		if vol := claimVolume(d.Spec.Template.Spec.Volumes, claimName); vol != "" {
+			workloads = append(workloads, pvcWorkload{kind: apis.KindDeployment, name: d.Name, volume: vol, replicas: replicasOf(d.Spec.Replicas)})
+		}
+	}
+	statefulSets, err := kubeClient.AppsV1().StatefulSets(srcNamespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, sts := range statefulSets.Items {
+		for _, t := range sts.Spec.VolumeClaimTemplates {
+			prefix := fmt.Sprintf("%s-%s-", t.Name, sts.Name)
+			if strings.HasPrefix(claimName, prefix) {
+				return nil, fmt.Errorf("PVC %s is created from the volumeClaimTemplates of StatefulSet %s and can not be migrated in place", claimName, sts.Name)
+			}
+		}
+		if vol := claimVolume(sts.Spec.Template.Spec.Volumes, claimName); vol != "" {
+			workloads = append(workloads, pvcWorkload{kind: apis.KindStatefulSet, name: sts.Name, volume: vol, replicas: replicasOf(sts.Spec.Replicas)})
+		}
+	}
+	return workloads, nil
+}
+
+func claimVolume(volumes []core.Volume, claimName string) string {
+	for _, vol := range volumes {
+		if vol.PersistentVolumeClaim != nil && vol.PersistentVolumeClaim.ClaimName == claimName {
+			return vol.Name
+		}
+	}
+	return ""
+}
+
+func replicasOf(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
+
+// scaleDownWorkloads scales the workloads to zero and waits until no Pod mounts the PVC,
+// so that the data does not change while it is being copied. If it fails, the workloads
+// already scaled down are scaled back to their original replicas.
+func scaleDownWorkloads(workloads []pvcWorkload, claimName string) error {
+	for i, w := range workloads {
+		klog.Infof("Scaling down %s %s/%s", w.kind, srcNamespace, w.name)
+		if err := patchWorkload(w, []byte(`{"spec":{"replicas":0}}`)); err != nil {
+			return restoreWorkloads(workloads[:i], fmt.Errorf("failed to scale down %s %s/%s: %w", w.kind, srcNamespace, w.name, err))
+		}
+	}
+	err := wait.PollUntilContextTimeout(context.Background(), PullInterval, WaitTimeOut, true, func(ctx context.Context) (bool, error) {
+		pods, err := kubeClient.CoreV1().Pods(srcNamespace).List(ctx, metav1.ListOptions{})
+		if err != nil {
+			return false, nil
+		}
+		for i := range pods.Items {
+			if podUsesPVC(&pods.Items[i], claimName) {
+				return false, nil
+			}
+		}
+		return true, nil
+	})
+	if err != nil {
+		return restoreWorkloads(workloads, fmt.Errorf("PVC %s/%s is still in use after scaling down its workloads: %w", srcNamespace, claimName, err))
+	}
+	return nil
+}
+
+// restoreWorkloads scales the workloads back to their original replicas after the cause.
+func restoreWorkloads(workloads []pvcWorkload, cause error) error {
+	if len(workloads) == 0 {
+		return cause
+	}
+	klog.Infof("Migration failed: %v. Scaling the workloads back up.", cause)
+	if err := switchWorkloads(workloads, ""); err != nil {
+		return fmt.Errorf("%v. Failed to scale up the workloads: %v", cause, err)
+	}
+	return cause
+}
+
+// switchWorkloads points the volume of the workloads to the new PVC, if provided, and scales them back to their original replicas.
+func switchWorkloads(workloads []pvcWorkload, claimName string) error {
+	for _, w := range workloads {
+		spec := map[string]any{
+			"replicas": w.replicas,
+		}
+		if claimName != "" {
+			klog.Infof("Switching %s %s/%s to PVC %s", w.kind, srcNamespace, w.name, claimName)
+			spec["template"] = map[string]any{
+				"spec": map[string]any{
+					"volumes": []any{
+						map[string]any{
+							"name":                  w.volume,
+							"persistentVolumeClaim": map[string]any{"claimName": claimName},
+						},
+					},
+				},
+			}
+		}
+		patch, err := json.Marshal(map[string]any{"spec": spec})
+		if err != nil {
+			return err
+		}
+		if err = patchWorkload(w, patch); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func patchWorkload(w pvcWorkload, patch []byte) error {
+	var err error
+	switch w.kind {
+	case apis.KindDeployment:
+		_, err = kubeClient.AppsV1().Deployments(srcNamespace).Patch(context.TODO(), w.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	case apis.KindStatefulSet:
+		_, err = kubeClient.AppsV1().StatefulSets(srcNamespace).Patch(context.TODO(), w.name, types.StrategicMergePatchType, patch, metav1.PatchOptions{})
+	}
+	return err
+}
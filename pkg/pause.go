@@ -49,7 +49,10 @@ func NewCmdPause(clientGetter genericclioptions.RESTClientGetter) *cobra.Command
 		},
 	}
 	cmd.AddCommand(NewCmdPauseBackup())
+	cmd.AddCommand(NewCmdPauseList())
 	cmd.PersistentFlags().StringVar(&backupConfig, "backupconfig", backupConfig, "Name of the BackupConfiguration to pause")
 	cmd.PersistentFlags().StringVar(&backupBatch, "backupbatch", backupBatch, "Name of the BackupBatch to pause")
+	cmd.PersistentFlags().StringVarP(&backupSelector, "selector", "l", backupSelector, "Label selector of the BackupConfigurations and BackupBatches")
+	cmd.PersistentFlags().BoolVarP(&allNamespaces, "all-namespaces", "A", allNamespaces, "Select the BackupConfigurations and BackupBatches of all namespaces")
 	return cmd
 }
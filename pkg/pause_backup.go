@@ -19,6 +19,7 @@ package pkg
 import (
 	"context"
 	"fmt"
+	"time"
 
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
 	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
@@ -29,71 +30,215 @@ import (
 	"k8s.io/kubectl/pkg/util/templates"
 )
 
+const (
+	pausedReasonAnnotationKey = "stash.appscode.com/paused-reason"
+	pausedAtAnnotationKey     = "stash.appscode.com/paused-at"
+	pausedUntilAnnotationKey  = "stash.appscode.com/paused-until"
+)
+
 var pauseBackupExample = templates.Examples(`
 		# Pause a BackupConfigration
 		stash pause backup --namespace=<namespace> --backupconfig=<backupconfiguration-name>
-        stash pause backup --namespace=demo --backupconfig=sample-mongodb-backup`)
+        stash pause backup --namespace=demo --backupconfig=sample-mongodb-backup
+
+		# Pause the backups of a team in all namespaces during a maintenance window
+		stash pause backup -l team=payments -A --reason="storage migration" --until=2026-10-20T06:00Z`)
+
+type pauseBackupOptions struct {
+	reason string
+	until  string
+	yes    bool
+}
 
 func NewCmdPauseBackup() *cobra.Command {
+	opt := pauseBackupOptions{}
 	cmd := &cobra.Command{
 		Use:               "backup",
 		Short:             `Pause backup`,
-		Long:              `Pause backup by setting "paused" field of BackupConfiguration/BackupBatch to "true"`,
+		Long:              `Pause backup by setting "paused" field of BackupConfiguration/BackupBatch to "true". The reason and expiry of the pause are recorded as annotations`,
 		Example:           pauseBackupExample,
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if backupConfig == "" && backupBatch == "" {
-				return fmt.Errorf("neither BackupConfiguration nor BackupBatch name has been provided")
+			if backupConfig == "" && backupBatch == "" && backupSelector == "" && !allNamespaces {
+				return fmt.Errorf("neither BackupConfiguration nor BackupBatch name has been provided. Use --backupconfig, --backupbatch, --selector or --all-namespaces")
 			}
-			if backupConfig != "" {
-				if err := setBackupConfigurationPausedField(true); err != nil {
+			now := time.Now()
+			annotations := map[string]string{
+				pausedAtAnnotationKey: now.UTC().Format(time.RFC3339),
+			}
+			if opt.reason != "" {
+				annotations[pausedReasonAnnotationKey] = opt.reason
+			}
+			if opt.until != "" {
+				until, err := parsePauseUntil(opt.until, now)
+				if err != nil {
 					return err
 				}
-				klog.Infof("BackupConfiguration %s/%s has been paused successfully.", namespace, backupConfig)
-			} else {
-				if err := setBackupBatchPausedField(true); err != nil {
+				annotations[pausedUntilAnnotationKey] = until.UTC().Format(time.RFC3339)
+			}
+
+			invokers, err := selectBackupInvokers()
+			if err != nil {
+				return err
+			}
+			if len(invokers) == 0 {
+				klog.Infoln("No BackupConfiguration or BackupBatch matched.")
+				return nil
+			}
+			// pausing every backup of the cluster is rarely intended, so ask before doing it
+			if allNamespaces && backupSelector == "" && !opt.yes &&
+				!confirm(fmt.Sprintf("No --selector has been provided. Pause all the %d BackupConfigurations and BackupBatches of all namespaces?", len(invokers))) {
+				return errAborted
+			}
+			for _, inv := range invokers {
+				if err := setBackupInvokerPaused(inv, true, annotations); err != nil {
 					return err
 				}
-				klog.Infof("BackupBatch %s/%s has been paused successfully.", namespace, backupBatch)
+				klog.Infof("%s %s/%s has been paused successfully.", inv.kind, inv.meta.Namespace, inv.meta.Name)
 			}
 			return nil
 		},
 	}
+	cmd.Flags().StringVar(&opt.reason, "reason", opt.reason, "Reason of pausing the backup")
+	cmd.Flags().BoolVarP(&opt.yes, "yes", "y", opt.yes, "Don't ask for confirmation when pausing the backups of all namespaces without --selector")
+	cmd.Flags().StringVar(&opt.until, "until", opt.until, "Time until when the backup should stay paused (RFC3339 time, i.e. 2026-10-20T06:00Z, or a duration, i.e. 6h). Use \"resume --expired\" to resume the backups after it")
 	return cmd
 }
 
-func setBackupConfigurationPausedField(value bool) error {
-	bc, err := stashClient.StashV1beta1().BackupConfigurations(namespace).Get(context.TODO(), backupConfig, metav1.GetOptions{})
+// parsePauseUntil parses the expiry of a pause given either as a time or, as a duration from now.
+func parsePauseUntil(s string, now time.Time) (time.Time, error) {
+	if d, err := time.ParseDuration(s); err == nil {
+		if d <= 0 {
+			return time.Time{}, fmt.Errorf("--until must be in the future")
+		}
+		return now.Add(d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04Z07:00", "2006-01-02T15:04", "2006-01-02"} {
+		t, err := time.Parse(layout, s)
+		if err != nil {
+			continue
+		}
+		if !t.After(now) {
+			return time.Time{}, fmt.Errorf("--until %q is not in the future", s)
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --until %q. Use an RFC3339 time (i.e. 2026-10-20T06:00Z) or a duration (i.e. 6h)", s)
+}
+
+// backupInvoker is a BackupConfiguration or a BackupBatch.
+type backupInvoker struct {
+	kind   string
+	meta   metav1.ObjectMeta
+	paused bool
+}
+
+// pausedUntil returns the expiry of the pause of the invoker, if there is any.
+func (inv backupInvoker) pausedUntil() (time.Time, bool) {
+	until, err := time.Parse(time.RFC3339, inv.meta.Annotations[pausedUntilAnnotationKey])
 	if err != nil {
-		return err
+		return time.Time{}, false
 	}
-	_, _, err = v1beta1_util.PatchBackupConfiguration(
-		context.TODO(),
-		stashClient.StashV1beta1(),
-		bc,
-		func(in *v1beta1.BackupConfiguration) *v1beta1.BackupConfiguration {
-			in.Spec.Paused = value
-			return in
-		},
-		metav1.PatchOptions{},
-	)
-	return err
+	return until, true
 }
 
-func setBackupBatchPausedField(value bool) error {
-	bb, err := stashClient.StashV1beta1().BackupBatches(namespace).Get(context.TODO(), backupBatch, metav1.GetOptions{})
+// selectBackupInvokers returns the BackupConfiguration or BackupBatch given by name or, the ones matching
+// the selector in the namespace or in all namespaces.
+func selectBackupInvokers() ([]backupInvoker, error) {
+	if (backupConfig != "" || backupBatch != "") && (backupSelector != "" || allNamespaces) {
+		return nil, fmt.Errorf("--backupconfig and --backupbatch can not be used together with --selector or --all-namespaces")
+	}
+	if backupConfig != "" {
+		bc, err := stashClient.StashV1beta1().BackupConfigurations(namespace).Get(context.TODO(), backupConfig, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return []backupInvoker{{kind: v1beta1.ResourceKindBackupConfiguration, meta: bc.ObjectMeta, paused: bc.Spec.Paused}}, nil
+	}
+	if backupBatch != "" {
+		bb, err := stashClient.StashV1beta1().BackupBatches(namespace).Get(context.TODO(), backupBatch, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		return []backupInvoker{{kind: v1beta1.ResourceKindBackupBatch, meta: bb.ObjectMeta, paused: bb.Spec.Paused}}, nil
+	}
+
+	ns := namespace
+	if allNamespaces {
+		ns = metav1.NamespaceAll
+	}
+	opts := metav1.ListOptions{LabelSelector: backupSelector}
+	var invokers []backupInvoker
+	bcs, err := stashClient.StashV1beta1().BackupConfigurations(ns).List(context.TODO(), opts)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	for _, bc := range bcs.Items {
+		invokers = append(invokers, backupInvoker{kind: v1beta1.ResourceKindBackupConfiguration, meta: bc.ObjectMeta, paused: bc.Spec.Paused})
+	}
+	bbs, err := stashClient.StashV1beta1().BackupBatches(ns).List(context.TODO(), opts)
+	if err != nil {
+		return nil, err
 	}
-	_, _, err = v1beta1_util.PatchBackupBatch(
-		context.TODO(),
-		stashClient.StashV1beta1(),
-		bb,
-		func(in *v1beta1.BackupBatch) *v1beta1.BackupBatch {
-			in.Spec.Paused = value
+	for _, bb := range bbs.Items {
+		invokers = append(invokers, backupInvoker{kind: v1beta1.ResourceKindBackupBatch, meta: bb.ObjectMeta, paused: bb.Spec.Paused})
+	}
+	return invokers, nil
+}
+
+// setBackupInvokerPaused sets the "paused" field of the invoker. When pausing, the given annotations replace
+// the pause annotations of the invoker. When resuming, the pause annotations are removed.
+func setBackupInvokerPaused(inv backupInvoker, value bool, annotations map[string]string) error {
+	setAnnotations := func(in map[string]string) map[string]string {
+		for _, key := range []string{pausedReasonAnnotationKey, pausedAtAnnotationKey, pausedUntilAnnotationKey} {
+			delete(in, key)
+		}
+		if !value {
 			return in
-		},
-		metav1.PatchOptions{},
-	)
-	return err
+		}
+		if in == nil {
+			in = map[string]string{}
+		}
+		for k, v := range annotations {
+			in[k] = v
+		}
+		return in
+	}
+
+	switch inv.kind {
+	case v1beta1.ResourceKindBackupConfiguration:
+		bc, err := stashClient.StashV1beta1().BackupConfigurations(inv.meta.Namespace).Get(context.TODO(), inv.meta.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		_, _, err = v1beta1_util.PatchBackupConfiguration(
+			context.TODO(),
+			stashClient.StashV1beta1(),
+			bc,
+			func(in *v1beta1.BackupConfiguration) *v1beta1.BackupConfiguration {
+				in.Spec.Paused = value
+				in.Annotations = setAnnotations(in.Annotations)
+				return in
+			},
+			metav1.PatchOptions{},
+		)
+		return err
+	default:
+		bb, err := stashClient.StashV1beta1().BackupBatches(inv.meta.Namespace).Get(context.TODO(), inv.meta.Name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		_, _, err = v1beta1_util.PatchBackupBatch(
+			context.TODO(),
+			stashClient.StashV1beta1(),
+			bb,
+			func(in *v1beta1.BackupBatch) *v1beta1.BackupBatch {
+				in.Spec.Paused = value
+				in.Annotations = setAnnotations(in.Annotations)
+				return in
+			},
+			metav1.PatchOptions{},
+		)
+		return err
+	}
 }
@@ -0,0 +1,72 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+var pauseListExample = templates.Examples(`
+		# List the paused BackupConfigurations and BackupBatches of all namespaces
+		stash pause list -A`)
+
+func NewCmdPauseList() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "list",
+		Short:             `List paused backups`,
+		Long:              `List the paused BackupConfigurations and BackupBatches along with the reason and expiry of the pause`,
+		Example:           pauseListExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			invokers, err := selectBackupInvokers()
+			if err != nil {
+				return err
+			}
+			now := time.Now()
+			w := tabwriter.NewWriter(os.Stdout, TableMinWidth, TableTabWidth, TablePadding, TablePadChar, 0)
+			_, _ = fmt.Fprintln(w, "NAMESPACE\tKIND\tNAME\tREASON\tPAUSED-AT\tUNTIL")
+			for _, inv := range invokers {
+				if !inv.paused {
+					continue
+				}
+				until := inv.meta.Annotations[pausedUntilAnnotationKey]
+				if t, ok := inv.pausedUntil(); ok && !t.After(now) {
+					until += " (expired)"
+				}
+				_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", inv.meta.Namespace, inv.kind, inv.meta.Name,
+					valueOrNone(inv.meta.Annotations[pausedReasonAnnotationKey]),
+					valueOrNone(inv.meta.Annotations[pausedAtAnnotationKey]),
+					valueOrNone(until))
+			}
+			return w.Flush()
+		},
+	}
+	return cmd
+}
+
+func valueOrNone(s string) string {
+	if s == "" {
+		return "<none>"
+	}
+	return s
+}
@@ -220,15 +220,18 @@ func (opt *purgeOptions) getBlobStorageFromConfig() (*blob.Blob, error) {
 }
 
 func (opt *purgeOptions) parseDuration() (time.Time, error) {
-	// Parse duration string like "1y", "6mo", "30d", "24h"
+	return cutoffTime(opt.olderThan, time.Now())
+}
+
+// cutoffTime subtracts an age like "1y", "6mo", "30d", "24h" or a combination of them (i.e. "1y6mo") from now.
+func cutoffTime(age string, now time.Time) (time.Time, error) {
 	durationRegex := regexp.MustCompile(`(\d+)([ydhms]|mo)`)
-	matches := durationRegex.FindAllStringSubmatch(opt.olderThan, -1)
+	matches := durationRegex.FindAllStringSubmatch(age, -1)
 
 	if len(matches) == 0 {
-		return time.Time{}, fmt.Errorf("invalid duration format: %s", opt.olderThan)
+		return time.Time{}, fmt.Errorf("invalid duration format: %s", age)
 	}
 
-	now := time.Now()
 	cutoff := now
 
 	for _, match := range matches {
@@ -0,0 +1,561 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+const (
+	outputFormatTable    = "table"
+	outputFormatJSON     = "json"
+	outputFormatMarkdown = "markdown"
+	outputFormatHTML     = "html"
+
+	// maxScheduleSlots limits the number of schedule slots evaluated for a single target
+	maxScheduleSlots = 100000
+)
+
+var reportExample = templates.Examples(`
+		# Show the backup report of the last 30 days for the current namespace
+		kubectl stash report --since=30d
+
+		# Generate a Markdown report for all namespaces and fail if any target has not succeeded in the last 2 days
+		kubectl stash report -A --since=30d --output=markdown --max-age-since-success=2d`)
+
+type reportOptions struct {
+	since              string
+	output             string
+	maxAgeSinceSuccess string
+	allNamespaces      bool
+}
+
+// targetReport holds the aggregated backup history of a BackupConfiguration or BackupBatch.
+type targetReport struct {
+	Namespace       string     `json:"namespace"`
+	Kind            string     `json:"kind"`
+	Name            string     `json:"name"`
+	Schedule        string     `json:"schedule"`
+	Paused          bool       `json:"paused"`
+	HistoryFrom     *time.Time `json:"historyFrom,omitempty"`
+	Sessions        int        `json:"sessions"`
+	Succeeded       int        `json:"succeeded"`
+	Failed          int        `json:"failed"`
+	Skipped         int        `json:"skipped"`
+	SuccessRate     float64    `json:"successRate"`
+	AvgDuration     string     `json:"avgDuration"`
+	P95Duration     string     `json:"p95Duration"`
+	MissedSchedules int        `json:"missedSchedules"`
+	LastSuccess     *time.Time `json:"lastSuccess,omitempty"`
+	SinceSuccess    string     `json:"timeSinceLastSuccess"`
+	Repository      string     `json:"repository,omitempty"`
+	RepositorySize  string     `json:"repositorySize,omitempty"`
+	SnapshotCount   int64      `json:"snapshotCount"`
+	SizeGrowth      string     `json:"sizeGrowth"`
+	Violation       bool       `json:"violation"`
+}
+
+// reportInvoker is the common information of a BackupConfiguration or BackupBatch needed to build a report.
+type reportInvoker struct {
+	namespace  string
+	kind       string
+	name       string
+	schedule   string
+	paused     bool
+	created    time.Time
+	repository string
+}
+
+func NewCmdReport(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
+	opt := reportOptions{
+		since:  "30d",
+		output: outputFormatTable,
+	}
+	cmd := &cobra.Command{
+		Use:               "report",
+		Short:             `Show backup history and SLA report`,
+		Long:              `Aggregate BackupSessions of each BackupConfiguration and BackupBatch to show success rate, durations, missed schedules and repository growth`,
+		Example:           reportExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := clientGetter.ToRESTConfig()
+			if err != nil {
+				return errors.Wrap(err, "failed to read kubeconfig")
+			}
+
+			namespace, _, err = clientGetter.ToRawKubeConfigLoader().Namespace()
+			if err != nil {
+				return err
+			}
+
+			stashClient, err = cs.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
+			ns := namespace
+			if opt.allNamespaces {
+				ns = metav1.NamespaceAll
+			}
+			return opt.run(ns)
+		},
+	}
+
+	cmd.Flags().StringVar(&opt.since, "since", opt.since, "Only include BackupSessions created within this duration (e.g., 30d, 6mo, 1y)")
+	cmd.Flags().StringVarP(&opt.output, "output", "o", opt.output, "Output format. One of: table|json|markdown|html")
+	cmd.Flags().StringVar(&opt.maxAgeSinceSuccess, "max-age-since-success", opt.maxAgeSinceSuccess, "Exit with an error if the last successful backup of any target is older than this duration (e.g., 1d, 36h)")
+	cmd.Flags().BoolVarP(&opt.allNamespaces, "all-namespaces", "A", opt.allNamespaces, "Report the targets of all namespaces")
+	return cmd
+}
+
+func (opt *reportOptions) run(ns string) error {
+	now := time.Now()
+	since, err := cutoffTime(opt.since, now)
+	if err != nil {
+		return fmt.Errorf("invalid --since: %w", err)
+	}
+	var successDeadline time.Time
+	if opt.maxAgeSinceSuccess != "" {
+		if successDeadline, err = cutoffTime(opt.maxAgeSinceSuccess, now); err != nil {
+			return fmt.Errorf("invalid --max-age-since-success: %w", err)
+		}
+	}
+
+	invokers, err := listReportInvokers(stashClient, ns)
+	if err != nil {
+		return err
+	}
+	bsList, err := stashClient.StashV1beta1().BackupSessions(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	repoList, err := stashClient.StashV1alpha1().Repositories(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+
+	reports := buildBackupReports(invokers, bsList.Items, repoList.Items, since, now, successDeadline)
+	if err := writeReports(os.Stdout, opt.output, reports); err != nil {
+		return err
+	}
+
+	var violations []string
+	for _, r := range reports {
+		if r.Violation {
+			violations = append(violations, fmt.Sprintf("%s %s/%s", r.Kind, r.Namespace, r.Name))
+		}
+	}
+	if len(violations) > 0 {
+		return fmt.Errorf("last successful backup is older than %s for: %s", opt.maxAgeSinceSuccess, strings.Join(violations, ", "))
+	}
+	return nil
+}
+
+func listReportInvokers(client cs.Interface, ns string) ([]reportInvoker, error) {
+	var invokers []reportInvoker
+	bcList, err := client.StashV1beta1().BackupConfigurations(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, bc := range bcList.Items {
+		invokers = append(invokers, reportInvoker{
+			namespace:  bc.Namespace,
+			kind:       v1beta1.ResourceKindBackupConfiguration,
+			name:       bc.Name,
+			schedule:   bc.Spec.Schedule,
+			paused:     bc.Spec.Paused,
+			created:    bc.CreationTimestamp.Time,
+			repository: repositoryKey(bc.Namespace, bc.Spec.Repository.Namespace, bc.Spec.Repository.Name),
+		})
+	}
+	bbList, err := client.StashV1beta1().BackupBatches(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for _, bb := range bbList.Items {
+		invokers = append(invokers, reportInvoker{
+			namespace:  bb.Namespace,
+			kind:       v1beta1.ResourceKindBackupBatch,
+			name:       bb.Name,
+			schedule:   bb.Spec.Schedule,
+			paused:     bb.Spec.Paused,
+			created:    bb.CreationTimestamp.Time,
+			repository: repositoryKey(bb.Namespace, bb.Spec.Repository.Namespace, bb.Spec.Repository.Name),
+		})
+	}
+	return invokers, nil
+}
+
+func repositoryKey(invokerNamespace, repoNamespace, repoName string) string {
+	if repoName == "" {
+		return ""
+	}
+	if repoNamespace == "" {
+		repoNamespace = invokerNamespace
+	}
+	return repoNamespace + "/" + repoName
+}
+
+// buildBackupReports aggregates the BackupSessions created after since for every invoker. The last success
+// is looked up in all the BackupSessions, regardless of since. A zero successDeadline disables the last success
+// check. Paused invokers and, the ones created after the deadline are not checked.
+func buildBackupReports(invokers []reportInvoker, sessions []v1beta1.BackupSession, repos []v1alpha1.Repository, since, now, successDeadline time.Time) []targetReport {
+	sessionsByInvoker := map[string][]v1beta1.BackupSession{}
+	lastSuccessByInvoker := map[string]time.Time{}
+	for _, bs := range sessions {
+		key := invokerKey(bs.Namespace, bs.Spec.Invoker.Kind, bs.Spec.Invoker.Name)
+		if t := bs.CreationTimestamp.Time; bs.Status.Phase == v1beta1.BackupSessionSucceeded && t.After(lastSuccessByInvoker[key]) {
+			lastSuccessByInvoker[key] = t
+		}
+		if bs.CreationTimestamp.Time.Before(since) {
+			continue
+		}
+		sessionsByInvoker[key] = append(sessionsByInvoker[key], bs)
+	}
+	repoByKey := map[string]v1alpha1.Repository{}
+	for _, repo := range repos {
+		repoByKey[repo.Namespace+"/"+repo.Name] = repo
+	}
+
+	reports := make([]targetReport, 0, len(invokers))
+	for _, inv := range invokers {
+		owned := sessionsByInvoker[invokerKey(inv.namespace, inv.kind, inv.name)]
+		sort.Slice(owned, func(i, j int) bool {
+			return owned[i].CreationTimestamp.Before(&owned[j].CreationTimestamp)
+		})
+
+		r := targetReport{
+			Namespace:    inv.namespace,
+			Kind:         inv.kind,
+			Name:         inv.name,
+			Schedule:     inv.schedule,
+			Paused:       inv.paused,
+			Sessions:     len(owned),
+			Repository:   inv.repository,
+			SinceSuccess: "never",
+			SizeGrowth:   "-",
+		}
+
+		var (
+			durations []time.Duration
+			createdAt []time.Time
+		)
+		for i := range owned {
+			bs := &owned[i]
+			createdAt = append(createdAt, bs.CreationTimestamp.Time)
+			switch bs.Status.Phase {
+			case v1beta1.BackupSessionSucceeded:
+				r.Succeeded++
+			case v1beta1.BackupSessionFailed:
+				r.Failed++
+			case v1beta1.BackupSessionSkipped:
+				r.Skipped++
+			}
+			if d, err := time.ParseDuration(bs.Status.SessionDuration); err == nil {
+				durations = append(durations, d)
+			}
+		}
+
+		if completed := r.Succeeded + r.Failed; completed > 0 {
+			r.SuccessRate = math.Round(float64(r.Succeeded)/float64(completed)*1000) / 10
+		}
+		r.AvgDuration, r.P95Duration = durationStats(durations)
+
+		// BackupSessions are cleaned up according to backupHistoryLimit, so the schedule
+		// can only be verified from the oldest session that is still present.
+		from := since
+		if inv.created.After(from) {
+			from = inv.created
+		}
+		if len(owned) > 0 {
+			oldest := owned[0].CreationTimestamp.Time
+			r.HistoryFrom = &oldest
+			if oldest.After(from) {
+				from = oldest
+			}
+		}
+		if !inv.paused {
+			r.MissedSchedules = missedSchedules(inv.schedule, from, now, createdAt)
+		}
+
+		if t, ok := lastSuccessByInvoker[invokerKey(inv.namespace, inv.kind, inv.name)]; ok {
+			r.LastSuccess = &t
+			r.SinceSuccess = formatDuration(now.Sub(t))
+		}
+		if !successDeadline.IsZero() && !inv.paused && !inv.created.After(successDeadline) &&
+			(r.LastSuccess == nil || r.LastSuccess.Before(successDeadline)) {
+			r.Violation = true
+		}
+
+		if repo, ok := repoByKey[inv.repository]; ok {
+			r.RepositorySize = repo.Status.TotalSize
+			r.SnapshotCount = repo.Status.SnapshotCount
+			r.SizeGrowth = repositoryGrowth(repo.Status)
+		}
+		reports = append(reports, r)
+	}
+
+	sort.Slice(reports, func(i, j int) bool {
+		return invokerKey(reports[i].Namespace, reports[i].Kind, reports[i].Name) < invokerKey(reports[j].Namespace, reports[j].Kind, reports[j].Name)
+	})
+	return reports
+}
+
+// durationStats returns the average and the 95th percentile (nearest rank) of the durations.
+func durationStats(durations []time.Duration) (string, string) {
+	if len(durations) == 0 {
+		return "-", "-"
+	}
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var total time.Duration
+	for _, d := range sorted {
+		total += d
+	}
+	avg := total / time.Duration(len(sorted))
+	p95 := sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+	return avg.Round(time.Second).String(), p95.Round(time.Second).String()
+}
+
+// missedSchedules counts the schedule slots in [from, now) for which no BackupSession has been
+// created before the next slot. It returns -1 if the schedule can not be parsed.
+func missedSchedules(schedule string, from, now time.Time, createdAt []time.Time) int {
+	s, err := parseCronSchedule(schedule)
+	if err != nil {
+		return -1
+	}
+	sort.Slice(createdAt, func(i, j int) bool { return createdAt[i].Before(createdAt[j]) })
+
+	missed := 0
+	slot := s.next(from.Add(-time.Second))
+	for i := 0; i < maxScheduleSlots && !slot.IsZero() && slot.Before(now); i++ {
+		end := s.next(slot)
+		if end.IsZero() || end.After(now) {
+			end = now
+			// give the operator a minute to create the session of the current slot
+			if now.Sub(slot) < time.Minute {
+				break
+			}
+		}
+		idx := sort.Search(len(createdAt), func(i int) bool { return !createdAt[i].Before(slot) })
+		if idx == len(createdAt) || !createdAt[idx].Before(end) {
+			missed++
+		}
+		slot = s.next(slot)
+	}
+	return missed
+}
+
+// repositoryGrowth returns the average growth of the repository per day, from its size and the time span
+// between its first and last backup.
+func repositoryGrowth(status v1alpha1.RepositoryStatus) string {
+	if status.FirstBackupTime == nil || status.LastBackupTime == nil {
+		return "-"
+	}
+	size, err := parseSize(status.TotalSize)
+	if err != nil {
+		return "-"
+	}
+	days := status.LastBackupTime.Sub(status.FirstBackupTime.Time).Hours() / 24
+	if days < 1 {
+		return "-"
+	}
+	return formatSizeDelta(int64(float64(size)/days)) + "/day"
+}
+
+var sizeUnits = map[string]float64{
+	"B":   1,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+}
+
+// parseSize parses the sizes reported by restic (i.e. "1.234 GiB") into bytes.
+func parseSize(s string) (int64, error) {
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q: %v", s, err)
+	}
+	unit, ok := sizeUnits[fields[1]]
+	if !ok {
+		return 0, fmt.Errorf("unknown unit in size %q", s)
+	}
+	return int64(v * unit), nil
+}
+
+func formatSize(b int64) string {
+	v := float64(b)
+	switch {
+	case b > 1<<40:
+		return fmt.Sprintf("%.3f TiB", v/(1<<40))
+	case b > 1<<30:
+		return fmt.Sprintf("%.3f GiB", v/(1<<30))
+	case b > 1<<20:
+		return fmt.Sprintf("%.3f MiB", v/(1<<20))
+	case b > 1<<10:
+		return fmt.Sprintf("%.3f KiB", v/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", b)
+	}
+}
+
+func formatSizeDelta(b int64) string {
+	if b < 0 {
+		return "-" + formatSize(-b)
+	}
+	return "+" + formatSize(b)
+}
+
+func writeReports(w io.Writer, format string, reports []targetReport) error {
+	switch format {
+	case outputFormatTable:
+		return writeReportTable(w, reports)
+	case outputFormatJSON:
+		data, err := json.MarshalIndent(reports, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	case outputFormatMarkdown:
+		return writeReportMarkdown(w, reports)
+	case outputFormatHTML:
+		return reportHTMLTemplate.Execute(w, struct {
+			Generated string
+			Reports   []targetReport
+		}{time.Now().Format(OutputTimeFormat), reports})
+	default:
+		return fmt.Errorf("unknown output format %q. Supported formats are: table, json, markdown, html", format)
+	}
+}
+
+var reportColumns = []string{"NAMESPACE", "KIND", "NAME", "SESSIONS", "SUCCESS RATE", "AVG DURATION", "P95 DURATION", "MISSED", "SINCE LAST SUCCESS", "REPOSITORY SIZE", "SNAPSHOTS", "GROWTH"}
+
+func (r targetReport) columns() []string {
+	missed := strconv.Itoa(r.MissedSchedules)
+	if r.Paused {
+		missed = "paused"
+	} else if r.MissedSchedules < 0 {
+		missed = "invalid schedule"
+	}
+	size := r.RepositorySize
+	if size == "" {
+		size = "-"
+	}
+	sinceSuccess := r.SinceSuccess
+	if r.Violation {
+		sinceSuccess += " (!)"
+	}
+	return []string{
+		r.Namespace,
+		r.Kind,
+		r.Name,
+		strconv.Itoa(r.Sessions),
+		fmt.Sprintf("%.1f%%", r.SuccessRate),
+		r.AvgDuration,
+		r.P95Duration,
+		missed,
+		sinceSuccess,
+		size,
+		strconv.FormatInt(r.SnapshotCount, 10),
+		r.SizeGrowth,
+	}
+}
+
+func writeReportTable(out io.Writer, reports []targetReport) error {
+	w := tabwriter.NewWriter(out, TableMinWidth, TableTabWidth, TablePadding, TablePadChar, 0)
+	_, _ = fmt.Fprintln(w, strings.Join(reportColumns, "\t"))
+	for _, r := range reports {
+		_, _ = fmt.Fprintln(w, strings.Join(r.columns(), "\t"))
+	}
+	return w.Flush()
+}
+
+func writeReportMarkdown(w io.Writer, reports []targetReport) error {
+	var sb strings.Builder
+	sb.WriteString("| " + strings.Join(reportColumns, " | ") + " |\n")
+	sb.WriteString(strings.Repeat("| --- ", len(reportColumns)) + "|\n")
+	for _, r := range reports {
+		cols := r.columns()
+		for i := range cols {
+			cols[i] = strings.ReplaceAll(cols[i], "|", `\|`)
+		}
+		sb.WriteString("| " + strings.Join(cols, " | ") + " |\n")
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+var reportHTMLTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Stash Backup Report</title>
+<style>
+table { border-collapse: collapse; font-family: sans-serif; font-size: 14px; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+tr.violation { background-color: #fdd; }
+</style>
+</head>
+<body>
+<h1>Stash Backup Report</h1>
+<p>Generated at {{ .Generated }}</p>
+<table>
+<tr><th>Namespace</th><th>Kind</th><th>Name</th><th>Sessions</th><th>Success Rate</th><th>Avg Duration</th><th>P95 Duration</th><th>Missed</th><th>Since Last Success</th><th>Repository Size</th><th>Snapshots</th><th>Growth</th></tr>
+{{- range .Reports }}
+<tr{{ if .Violation }} class="violation"{{ end }}>{{ range .Columns }}<td>{{ . }}</td>{{ end }}</tr>
+{{- end }}
+</table>
+</body>
+</html>
+`))
+
+// Columns is used by the HTML template.
+func (r targetReport) Columns() []string {
+	return r.columns()
+}
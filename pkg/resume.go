@@ -22,13 +22,25 @@ import (
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/kubectl/pkg/util/templates"
 )
 
+var resumeExample = templates.Examples(`
+		# Resume the backups of all namespaces whose pause window has passed
+		stash resume --expired -A`)
+
 func NewCmdResume(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
 	cmd := &cobra.Command{
 		Use:               "resume",
 		Short:             `Resume Stash backup`,
+		Example:           resumeExample,
 		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !resumeExpired {
+				return cmd.Help()
+			}
+			return resumeBackups()
+		},
 		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := clientGetter.ToRESTConfig()
 			if err != nil {
@@ -51,5 +63,8 @@ func NewCmdResume(clientGetter genericclioptions.RESTClientGetter) *cobra.Comman
 	cmd.AddCommand(NewCmdResumeBackup())
 	cmd.PersistentFlags().StringVar(&backupConfig, "backupconfig", backupConfig, "Name of the Backupconfiguration")
 	cmd.PersistentFlags().StringVar(&backupBatch, "backupbatch", backupBatch, "Name of the BackupBatch")
+	cmd.PersistentFlags().StringVarP(&backupSelector, "selector", "l", backupSelector, "Label selector of the BackupConfigurations and BackupBatches")
+	cmd.PersistentFlags().BoolVarP(&allNamespaces, "all-namespaces", "A", allNamespaces, "Select the BackupConfigurations and BackupBatches of all namespaces")
+	cmd.PersistentFlags().BoolVar(&resumeExpired, "expired", resumeExpired, "Only resume the backups whose pause window (--until of pause backup) has passed")
 	return cmd
 }
@@ -18,6 +18,7 @@ package pkg
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/spf13/cobra"
 	"k8s.io/klog/v2"
@@ -27,7 +28,10 @@ import (
 var resumeBackupExample = templates.Examples(`
 		# Resume a BackupConfigration
 		stash resume backup --namespace=<namespace> --backupconfig=<backupconfiguration-name>
-        stash resume backup --namespace=demo --backupconfig=sample-mongodb-backup`)
+        stash resume backup --namespace=demo --backupconfig=sample-mongodb-backup
+
+		# Resume the paused backups of a team whose pause window has passed
+		stash resume backup -l team=payments -A --expired`)
 
 func NewCmdResumeBackup() *cobra.Command {
 	cmd := &cobra.Command{
@@ -37,24 +41,43 @@ func NewCmdResumeBackup() *cobra.Command {
 		Example:           resumeBackupExample,
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if backupConfig == "" && backupBatch == "" {
-				return fmt.Errorf("neither BackupConfiguration nor BackupBatch name has been provided")
-			}
-
-			if backupConfig != "" {
-				if err := setBackupConfigurationPausedField(false); err != nil {
-					return err
-				}
-				klog.Infof("BackupConfiguration %s/%s has been resumed successfully.", namespace, backupConfig)
-			} else {
-				if err := setBackupBatchPausedField(false); err != nil {
-					return err
-				}
-				klog.Infof("BackupBatch %s/%s has been resumed successfully.", namespace, backupBatch)
+			if backupConfig == "" && backupBatch == "" && backupSelector == "" && !allNamespaces && !resumeExpired {
+				return fmt.Errorf("neither BackupConfiguration nor BackupBatch name has been provided. Use --backupconfig, --backupbatch, --selector, --all-namespaces or --expired")
 			}
-			return nil
+			return resumeBackups()
 		},
 	}
 
 	return cmd
 }
+
+// resumeBackups resumes the selected BackupConfigurations and BackupBatches. With --expired, only the ones
+// whose pause window has passed are resumed.
+func resumeBackups() error {
+	invokers, err := selectBackupInvokers()
+	if err != nil {
+		return err
+	}
+	byName := backupConfig != "" || backupBatch != ""
+	now := time.Now()
+	resumed := 0
+	for _, inv := range invokers {
+		if !inv.paused && !byName {
+			continue
+		}
+		if resumeExpired {
+			if until, ok := inv.pausedUntil(); !ok || until.After(now) {
+				continue
+			}
+		}
+		if err := setBackupInvokerPaused(inv, false, nil); err != nil {
+			return err
+		}
+		resumed++
+		klog.Infof("%s %s/%s has been resumed successfully.", inv.kind, inv.meta.Namespace, inv.meta.Name)
+	}
+	if resumed == 0 {
+		klog.Infoln("No paused BackupConfiguration or BackupBatch matched.")
+	}
+	return nil
+}
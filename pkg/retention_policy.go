@@ -0,0 +1,165 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+)
+
+// retentionSnapshot is the part of a restic snapshot that the retention policy looks at.
+type retentionSnapshot struct {
+	ID    string
+	Time  time.Time
+	Host  string
+	Paths []string
+	Tags  []string
+}
+
+// retentionDecision tells whether the retention policy keeps a snapshot and, the rules that kept it.
+type retentionDecision struct {
+	Snapshot retentionSnapshot
+	Group    string
+	Keep     bool
+	Reasons  []string
+}
+
+var retentionGroupByFields = []string{"host", "paths", "tags"}
+
+// retentionBucket is a keep rule of the retention policy. A snapshot is kept by the rule when it is the
+// newest one of its period (i.e. day for keep-daily) and, the rule has not kept count periods yet.
+type retentionBucket struct {
+	rule  string
+	count int64
+	key   func(i int, t time.Time) int
+	last  int
+	taken bool
+}
+
+func newRetentionBuckets(p v1alpha1.RetentionPolicy) []*retentionBucket {
+	return []*retentionBucket{
+		{rule: "keep-last", count: p.KeepLast, key: func(i int, _ time.Time) int { return i }},
+		{rule: "keep-hourly", count: p.KeepHourly, key: func(_ int, t time.Time) int {
+			return t.Year()*1000000 + int(t.Month())*10000 + t.Day()*100 + t.Hour()
+		}},
+		{rule: "keep-daily", count: p.KeepDaily, key: func(_ int, t time.Time) int {
+			return t.Year()*10000 + int(t.Month())*100 + t.Day()
+		}},
+		{rule: "keep-weekly", count: p.KeepWeekly, key: func(_ int, t time.Time) int {
+			y, w := t.ISOWeek()
+			return y*100 + w
+		}},
+		{rule: "keep-monthly", count: p.KeepMonthly, key: func(_ int, t time.Time) int {
+			return t.Year()*100 + int(t.Month())
+		}},
+		{rule: "keep-yearly", count: p.KeepYearly, key: func(_ int, t time.Time) int {
+			return t.Year()
+		}},
+	}
+}
+
+// applyRetentionPolicy decides which snapshots the retention policy keeps the same way restic forget does.
+// The snapshots are grouped by the groupBy fields (host, paths and tags) and, the policy is applied to every
+// group independently. Periods are computed in UTC, the timezone of the backup sidecars and jobs. When the
+// policy has no keep rule, every snapshot is kept. The decisions are ordered by group and, newest first.
+func applyRetentionPolicy(snapshots []retentionSnapshot, p v1alpha1.RetentionPolicy, groupBy []string) ([]retentionDecision, error) {
+	for _, f := range groupBy {
+		if !containsString(retentionGroupByFields, f) {
+			return nil, fmt.Errorf("invalid group-by field %q. Supported fields are %s", f, strings.Join(retentionGroupByFields, ", "))
+		}
+	}
+
+	groups := map[string][]retentionSnapshot{}
+	var keys []string
+	for _, s := range snapshots {
+		key := retentionGroupKey(s, groupBy)
+		if _, ok := groups[key]; !ok {
+			keys = append(keys, key)
+		}
+		groups[key] = append(groups[key], s)
+	}
+	sort.Strings(keys)
+
+	decisions := make([]retentionDecision, 0, len(snapshots))
+	for _, key := range keys {
+		list := groups[key]
+		sort.SliceStable(list, func(i, j int) bool { return list[i].Time.After(list[j].Time) })
+
+		buckets := newRetentionBuckets(p)
+		for i, s := range list {
+			d := retentionDecision{Snapshot: s, Group: key}
+			if !hasKeepRule(p) {
+				d.Keep = true
+				d.Reasons = append(d.Reasons, "no keep rule")
+			}
+			for _, tag := range p.KeepTags {
+				if containsAllTags(s.Tags, strings.Split(tag, ",")) {
+					d.Keep = true
+					d.Reasons = append(d.Reasons, "keep-tag "+tag)
+					break
+				}
+			}
+			for _, b := range buckets {
+				if b.count <= 0 {
+					continue
+				}
+				k := b.key(i, s.Time.UTC())
+				if b.taken && k == b.last {
+					continue
+				}
+				d.Keep = true
+				d.Reasons = append(d.Reasons, b.rule)
+				b.last, b.taken = k, true
+				b.count--
+			}
+			decisions = append(decisions, d)
+		}
+	}
+	return decisions, nil
+}
+
+func retentionGroupKey(s retentionSnapshot, groupBy []string) string {
+	var parts []string
+	for _, f := range groupBy {
+		switch f {
+		case "host":
+			parts = append(parts, "host="+s.Host)
+		case "paths":
+			paths := append([]string(nil), s.Paths...)
+			sort.Strings(paths)
+			parts = append(parts, "paths="+strings.Join(paths, ","))
+		case "tags":
+			tags := append([]string(nil), s.Tags...)
+			sort.Strings(tags)
+			parts = append(parts, "tags="+strings.Join(tags, ","))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func containsAllTags(tags, want []string) bool {
+	for _, w := range want {
+		if !containsString(tags, w) {
+			return false
+		}
+	}
+	return true
+}
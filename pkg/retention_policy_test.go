@@ -0,0 +1,220 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+)
+
+func retentionSnap(id, ts, host string, tags ...string) retentionSnapshot {
+	t, err := time.Parse(time.RFC3339, ts)
+	if err != nil {
+		panic(err)
+	}
+	return retentionSnapshot{ID: id, Time: t, Host: host, Paths: []string{"/data"}, Tags: tags}
+}
+
+func TestApplyRetentionPolicy(t *testing.T) {
+	cases := []struct {
+		name      string
+		snapshots []retentionSnapshot
+		policy    v1alpha1.RetentionPolicy
+		groupBy   []string
+		// kept maps the ID of every kept snapshot to its reasons. The other snapshots must be forgotten.
+		kept map[string]string
+	}{
+		{
+			name: "keep-last",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2026-10-01T00:00:00Z", "host-0"),
+				retentionSnap("b", "2026-10-02T00:00:00Z", "host-0"),
+				retentionSnap("c", "2026-10-03T00:00:00Z", "host-0"),
+				retentionSnap("d", "2026-10-04T00:00:00Z", "host-0"),
+			},
+			policy: v1alpha1.RetentionPolicy{KeepLast: 2},
+			kept:   map[string]string{"d": "keep-last", "c": "keep-last"},
+		},
+		{
+			name: "keep-hourly keeps the newest snapshot of each hour",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2026-10-01T10:05:00Z", "host-0"),
+				retentionSnap("b", "2026-10-01T11:10:00Z", "host-0"),
+				retentionSnap("c", "2026-10-01T12:10:00Z", "host-0"),
+				retentionSnap("d", "2026-10-01T12:40:00Z", "host-0"),
+			},
+			policy: v1alpha1.RetentionPolicy{KeepHourly: 2},
+			kept:   map[string]string{"d": "keep-hourly", "b": "keep-hourly"},
+		},
+		{
+			name: "keep-daily computes days in UTC",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2026-10-01T08:00:00Z", "host-0"),
+				retentionSnap("b", "2026-10-02T08:00:00Z", "host-0"),
+				retentionSnap("c", "2026-10-03T01:00:00Z", "host-0"),
+				// 2026-10-03T04:30:00Z
+				retentionSnap("d", "2026-10-02T23:30:00-05:00", "host-0"),
+			},
+			policy: v1alpha1.RetentionPolicy{KeepDaily: 2},
+			kept:   map[string]string{"d": "keep-daily", "b": "keep-daily"},
+		},
+		{
+			name: "keep-weekly uses ISO weeks",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2026-10-04T00:00:00Z", "host-0"),
+				retentionSnap("b", "2026-10-05T00:00:00Z", "host-0"),
+				retentionSnap("c", "2026-10-11T00:00:00Z", "host-0"),
+				retentionSnap("d", "2026-10-12T00:00:00Z", "host-0"),
+			},
+			policy: v1alpha1.RetentionPolicy{KeepWeekly: 2},
+			kept:   map[string]string{"d": "keep-weekly", "c": "keep-weekly"},
+		},
+		{
+			name: "keep-weekly with an ISO week spanning the new year",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2025-12-28T00:00:00Z", "host-0"),
+				retentionSnap("b", "2025-12-29T00:00:00Z", "host-0"),
+				retentionSnap("c", "2026-01-01T00:00:00Z", "host-0"),
+			},
+			policy: v1alpha1.RetentionPolicy{KeepWeekly: 2},
+			kept:   map[string]string{"c": "keep-weekly", "a": "keep-weekly"},
+		},
+		{
+			name: "keep-monthly",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2026-08-15T00:00:00Z", "host-0"),
+				retentionSnap("b", "2026-09-30T00:00:00Z", "host-0"),
+				retentionSnap("c", "2026-10-01T00:00:00Z", "host-0"),
+				retentionSnap("d", "2026-10-20T00:00:00Z", "host-0"),
+			},
+			policy: v1alpha1.RetentionPolicy{KeepMonthly: 2},
+			kept:   map[string]string{"d": "keep-monthly", "b": "keep-monthly"},
+		},
+		{
+			name: "keep-yearly",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2024-06-01T00:00:00Z", "host-0"),
+				retentionSnap("b", "2025-12-29T00:00:00Z", "host-0"),
+				retentionSnap("c", "2026-01-01T00:00:00Z", "host-0"),
+			},
+			policy: v1alpha1.RetentionPolicy{KeepYearly: 2},
+			kept:   map[string]string{"c": "keep-yearly", "b": "keep-yearly"},
+		},
+		{
+			name: "a snapshot kept by several rules lists all of them",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2026-10-01T00:00:00Z", "host-0"),
+				retentionSnap("b", "2026-10-02T00:00:00Z", "host-0"),
+				retentionSnap("c", "2026-10-02T06:00:00Z", "host-0"),
+			},
+			policy: v1alpha1.RetentionPolicy{KeepLast: 1, KeepDaily: 2},
+			kept:   map[string]string{"c": "keep-last,keep-daily", "a": "keep-daily"},
+		},
+		{
+			name: "keep-tag requires all the tags of an entry",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2026-10-01T00:00:00Z", "host-0", "release", "v1"),
+				retentionSnap("b", "2026-10-02T00:00:00Z", "host-0", "release"),
+				retentionSnap("c", "2026-10-03T00:00:00Z", "host-0", "manual"),
+				retentionSnap("d", "2026-10-04T00:00:00Z", "host-0"),
+			},
+			policy: v1alpha1.RetentionPolicy{KeepTags: []string{"release,v1", "manual"}},
+			kept:   map[string]string{"a": "keep-tag release,v1", "c": "keep-tag manual"},
+		},
+		{
+			name: "rules are applied to every group independently",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2026-10-01T00:00:00Z", "host-0"),
+				retentionSnap("b", "2026-10-02T00:00:00Z", "host-0"),
+				retentionSnap("c", "2026-10-01T00:00:00Z", "host-1"),
+				retentionSnap("d", "2026-10-02T00:00:00Z", "host-1"),
+			},
+			policy:  v1alpha1.RetentionPolicy{KeepLast: 1},
+			groupBy: []string{"host"},
+			kept:    map[string]string{"b": "keep-last", "d": "keep-last"},
+		},
+		{
+			name: "without grouping the rules are applied to all the snapshots together",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2026-10-01T00:00:00Z", "host-0"),
+				retentionSnap("b", "2026-10-02T00:00:00Z", "host-0"),
+				retentionSnap("c", "2026-10-01T00:00:00Z", "host-1"),
+				retentionSnap("d", "2026-10-03T00:00:00Z", "host-1"),
+			},
+			policy: v1alpha1.RetentionPolicy{KeepLast: 1},
+			kept:   map[string]string{"d": "keep-last"},
+		},
+		{
+			name: "no keep rule keeps every snapshot",
+			snapshots: []retentionSnapshot{
+				retentionSnap("a", "2026-10-01T00:00:00Z", "host-0"),
+				retentionSnap("b", "2026-10-02T00:00:00Z", "host-0"),
+			},
+			kept: map[string]string{"a": "no keep rule", "b": "no keep rule"},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			decisions, err := applyRetentionPolicy(tc.snapshots, tc.policy, tc.groupBy)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if len(decisions) != len(tc.snapshots) {
+				t.Fatalf("expected %d decisions, got %d", len(tc.snapshots), len(decisions))
+			}
+			kept := map[string]string{}
+			for _, d := range decisions {
+				if d.Keep {
+					kept[d.Snapshot.ID] = strings.Join(d.Reasons, ",")
+				} else if len(d.Reasons) > 0 {
+					t.Errorf("forgotten snapshot %s has reasons %v", d.Snapshot.ID, d.Reasons)
+				}
+			}
+			if !reflect.DeepEqual(kept, tc.kept) {
+				t.Errorf("expected kept snapshots %v, got %v", tc.kept, kept)
+			}
+		})
+	}
+}
+
+func TestApplyRetentionPolicyGroups(t *testing.T) {
+	snapshots := []retentionSnapshot{
+		retentionSnap("a", "2026-10-01T00:00:00Z", "host-1", "b", "a"),
+		retentionSnap("b", "2026-10-02T00:00:00Z", "host-0", "a", "b"),
+	}
+	decisions, err := applyRetentionPolicy(snapshots, v1alpha1.RetentionPolicy{KeepLast: 1}, []string{"host", "tags"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var groups []string
+	for _, d := range decisions {
+		groups = append(groups, d.Group)
+	}
+	expected := []string{"host=host-0 tags=a,b", "host=host-1 tags=a,b"}
+	if !reflect.DeepEqual(groups, expected) {
+		t.Errorf("expected groups %v, got %v", expected, groups)
+	}
+
+	if _, err = applyRetentionPolicy(snapshots, v1alpha1.RetentionPolicy{KeepLast: 1}, []string{"hostname"}); err == nil {
+		t.Errorf("expected an error for an invalid group-by field")
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+var retentionSimulateExample = templates.Examples(`
+		# Show which snapshots of a Repository would be kept or forgotten by a retention policy
+		stash retention simulate gcs-repo --namespace=demo --keep-daily=7 --keep-monthly=12
+
+		# Apply the policy to all the snapshots of a host together, regardless of their paths
+		stash retention simulate gcs-repo --namespace=demo --keep-last=5 --group-by=host`)
+
+type retentionSimulateOptions struct {
+	retentionPolicy v1alpha1.RetentionPolicy
+	groupBy         []string
+}
+
+func NewCmdRetentionSimulate() *cobra.Command {
+	opt := retentionSimulateOptions{
+		groupBy: []string{"host", "paths"},
+	}
+	cmd := &cobra.Command{
+		Use:               "simulate <repository>",
+		Short:             `Show which snapshots a retention policy would keep or forget`,
+		Long:              `Load the snapshots of a Repository and show which of them a retention policy would keep, along with the rules that keep them, and which it would forget. Nothing is removed from the Repository.`,
+		Example:           retentionSimulateExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || args[0] == "" {
+				return fmt.Errorf("repository name not found")
+			}
+			repo, err := stashClient.StashV1alpha1().Repositories(namespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			snapshots, err := listRetentionSnapshots(repo, "")
+			if err != nil {
+				return err
+			}
+			if len(snapshots) == 0 {
+				klog.Infof("Repository %s/%s has no snapshot.", namespace, repo.Name)
+				return nil
+			}
+
+			decisions, err := applyRetentionPolicy(snapshots, opt.retentionPolicy, opt.groupBy)
+			if err != nil {
+				return err
+			}
+			writeRetentionDecisions(decisions)
+
+			uploaded, err := snapshotUploadedSizes(repo.Namespace)
+			if err != nil {
+				return err
+			}
+			repoSize, _ := parseSize(repo.Status.TotalSize)
+			fmt.Printf("Estimated reclaimable space: %s\n", formatSize(estimateReclaimableSpace(decisions, uploaded, repoSize)))
+			return nil
+		},
+	}
+
+	addKeepRuleFlags(cmd, &opt.retentionPolicy)
+	cmd.Flags().StringArrayVar(&opt.retentionPolicy.KeepTags, "keep-tag", opt.retentionPolicy.KeepTags, "Keep snapshots with this comma separated list of tags")
+	cmd.Flags().StringSliceVar(&opt.groupBy, "group-by", opt.groupBy, "Apply the policy to the snapshots grouped by these fields (host, paths, tags). Pass an empty value to apply it to all the snapshots together")
+
+	return cmd
+}
+
+// listRetentionSnapshots lists the snapshots of the Repository matching the label selector through the
+// Snapshot API of the operator.
+func listRetentionSnapshots(repo *v1alpha1.Repository, selector string) ([]retentionSnapshot, error) {
+	ls := "repository=" + repo.Name
+	if selector != "" {
+		ls += "," + selector
+	}
+	list, err := stashClient.RepositoriesV1alpha1().Snapshots(repo.Namespace).List(context.TODO(), metav1.ListOptions{
+		LabelSelector: ls,
+	})
+	if err != nil {
+		return nil, err
+	}
+	snapshots := make([]retentionSnapshot, 0, len(list.Items))
+	for _, s := range list.Items {
+		snapshots = append(snapshots, retentionSnapshot{
+			ID:    s.Name,
+			Time:  s.CreationTimestamp.Time,
+			Host:  s.Status.Hostname,
+			Paths: s.Status.Paths,
+			Tags:  s.Status.Tags,
+		})
+	}
+	return snapshots, nil
+}
+
+// snapshotUploadedSizes returns the size of the data uploaded by each snapshot, as recorded by the
+// BackupSessions of the namespace.
+func snapshotUploadedSizes(ns string) (map[string]int64, error) {
+	sessions, err := stashClient.StashV1beta1().BackupSessions(ns).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	sizes := map[string]int64{}
+	for _, bs := range sessions.Items {
+		for _, target := range bs.Status.Targets {
+			for _, host := range target.Stats {
+				for _, snap := range host.Snapshots {
+					if size, err := parseSize(snap.Uploaded); err == nil {
+						sizes[snap.Name] += size
+					}
+				}
+			}
+		}
+	}
+	return sizes, nil
+}
+
+// estimateReclaimableSpace sums the data uploaded by the forgotten snapshots. Snapshots without recorded
+// stats are counted as an even share of the repository size. It is an upper bound, as the data uploaded by a
+// forgotten snapshot may still be referenced by a kept one.
+func estimateReclaimableSpace(decisions []retentionDecision, uploaded map[string]int64, repoSize int64) int64 {
+	var total int64
+	for _, d := range decisions {
+		if d.Keep {
+			continue
+		}
+		if size, ok := uploaded[d.Snapshot.ID]; ok {
+			total += size
+		} else {
+			total += repoSize / int64(len(decisions))
+		}
+	}
+	return total
+}
+
+func writeRetentionDecisions(decisions []retentionDecision) {
+	kept := 0
+	w := tabwriter.NewWriter(os.Stdout, TableMinWidth, TableTabWidth, TablePadding, TablePadChar, 0)
+	group := ""
+	for i, d := range decisions {
+		if i == 0 || d.Group != group {
+			group = d.Group
+			if i > 0 {
+				_, _ = fmt.Fprintln(w)
+			}
+			if group != "" {
+				_, _ = fmt.Fprintf(w, "Group: %s\n", group)
+			}
+			_, _ = fmt.Fprintln(w, "SNAPSHOT\tHOST\tTIME\tACTION\tREASON")
+		}
+		action := "forget"
+		if d.Keep {
+			action = "keep"
+			kept++
+		}
+		_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", d.Snapshot.ID, d.Snapshot.Host, d.Snapshot.Time.UTC().Format(OutputTimeFormat), action, strings.Join(d.Reasons, ", "))
+	}
+	_ = w.Flush()
+	fmt.Printf("\n%d snapshots would be kept, %d would be forgotten.\n", kept, len(decisions)-kept)
+}
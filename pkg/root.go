@@ -58,7 +58,10 @@ func NewRootCmd() *cobra.Command {
 	rootCmd.AddCommand(NewCmdTriggerBackup(f))
 	rootCmd.AddCommand(NewCmdUnlockRepository(f))
 	rootCmd.AddCommand(NewCmdCreate(f))
+	rootCmd.AddCommand(NewCmdInit(f))
+	rootCmd.AddCommand(NewCmdSet(f))
 	rootCmd.AddCommand(NewCmdClone(f))
+	rootCmd.AddCommand(NewCmdMigratePVC(f))
 	rootCmd.AddCommand(NewCmdPause(f))
 	rootCmd.AddCommand(NewCmdResume(f))
 	rootCmd.AddCommand(NewCmdDebug(f))
@@ -67,7 +70,13 @@ func NewRootCmd() *cobra.Command {
 	rootCmd.AddCommand(NewCmdCheckRepository(f))
 	rootCmd.AddCommand(NewCmdRebuildIndex(f))
 	rootCmd.AddCommand(NewCmdMigrateRepositoryToV2(f))
+	rootCmd.AddCommand(NewCmdForget(f))
 	rootCmd.AddCommand(NewCmdPruneRepository(f))
+	rootCmd.AddCommand(NewCmdRetention(f))
 	rootCmd.AddCommand(NewCmdPurgeRepos(f))
+	rootCmd.AddCommand(NewCmdWatch(f))
+	rootCmd.AddCommand(NewCmdReport(f))
+	rootCmd.AddCommand(NewCmdMetrics(f))
+	rootCmd.AddCommand(NewCmdDrill(f))
 	return rootCmd
 }
@@ -0,0 +1,459 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/util"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/templates"
+	core_util "kmodules.xyz/client-go/core/v1"
+)
+
+var rotateKeyExample = templates.Examples(`
+		# Rotate the password of a repository and, all the other repositories sharing its storage Secret
+		stash key rotate gcs-repo -n demo
+
+		# Rotate to a password read from a file
+		stash key rotate gcs-repo -n demo --new-password-file=/path/to/password`)
+
+// resticKey is an entry of the output of "restic key list --json".
+type resticKey struct {
+	ID       string `json:"id"`
+	Current  bool   `json:"current"`
+	UserName string `json:"userName"`
+	HostName string `json:"hostName"`
+}
+
+// keyRotation tracks the progress of rotating the key of a single repository so that it can be rolled back.
+type keyRotation struct {
+	repo *v1alpha1.Repository
+	// pod mounts the backend of a Local repository. The commands on such a repository run on this pod
+	// with the password of the storage Secret, instead of inside docker.
+	pod       *core.Pod
+	localDirs cliLocalDirectories
+	caPath    string
+	oldKeyID  string
+	oldKeys   map[string]bool
+	keyAdded  bool
+}
+
+type rotateKeyOptions struct {
+	config            *rest.Config
+	newPasswordFile   string
+	newPasswordSecret string
+	oldPassword       string
+	newPassword       string
+	scratchDir        string
+	secret            *core.Secret
+	rotations         []*keyRotation
+}
+
+func NewCmdRotateKey(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
+	opt := rotateKeyOptions{}
+	cmd := &cobra.Command{
+		Use:   "rotate",
+		Short: `Rotate the password of a restic repository`,
+		Long: `Rotate the password of a restic repository. It adds a key with the new password to the repository and,
+all the other repositories sharing its storage Secret, updates RESTIC_PASSWORD of the Secret, checks the repositories
+with the new password and, then removes the old key. Every change is rolled back if any step before removing the old key fails.`,
+		Example:           rotateKeyExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || args[0] == "" {
+				return fmt.Errorf("repository name not found")
+			}
+			var err error
+			opt.config, err = clientGetter.ToRESTConfig()
+			if err != nil {
+				return errors.Wrap(err, "failed to read kubeconfig")
+			}
+			repo, err := stashClient.StashV1alpha1().Repositories(namespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			return opt.rotate(repo)
+		},
+	}
+	cmd.Flags().StringVar(&opt.newPasswordFile, "new-password-file", opt.newPasswordFile, "File from which to read the new password. A random password is generated if neither this nor --new-password-secret is provided")
+	cmd.Flags().StringVar(&opt.newPasswordSecret, "new-password-secret", opt.newPasswordSecret, "Secret key, in name/key format, from which to read the new password")
+	return cmd
+}
+
+func (opt *rotateKeyOptions) rotate(repo *v1alpha1.Repository) error {
+	var err error
+	opt.secret, err = kubeClient.CoreV1().Secrets(namespace).Get(context.TODO(), repo.Spec.Backend.StorageSecretName, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	opt.oldPassword = string(opt.secret.Data[restic.RESTIC_PASSWORD])
+	if opt.oldPassword == "" {
+		return fmt.Errorf("storage Secret %s/%s does not have %s", namespace, opt.secret.Name, restic.RESTIC_PASSWORD)
+	}
+	if opt.newPassword, err = readOrGeneratePassword(opt.newPasswordFile, opt.newPasswordSecret); err != nil {
+		return err
+	}
+	if opt.newPassword == opt.oldPassword {
+		return fmt.Errorf("new password is the same as the current password")
+	}
+
+	repos, err := repositoriesUsingSecret(opt.secret.Name)
+	if err != nil {
+		return err
+	}
+
+	// keep the dumped credentials in a private directory of this invocation
+	opt.scratchDir, err = newScratchDir()
+	if err != nil {
+		return err
+	}
+	defer removeScratchDir(opt.scratchDir)
+
+	for _, r := range repos {
+		rotation, err := opt.prepareRotation(r)
+		if err != nil {
+			return err
+		}
+		opt.rotations = append(opt.rotations, rotation)
+	}
+	klog.Infof("Rotating the key of %d repositories using Secret %s/%s", len(opt.rotations), namespace, opt.secret.Name)
+
+	// add the new key to every repository and make sure it opens the repository
+	for _, r := range opt.rotations {
+		err = opt.addKey(r)
+		if err != nil {
+			return opt.rollback(err, false)
+		}
+	}
+
+	klog.Infof("Updating %s of Secret %s/%s", restic.RESTIC_PASSWORD, namespace, opt.secret.Name)
+	if err = patchResticPassword(opt.secret, opt.newPassword); err != nil {
+		return opt.rollback(err, false)
+	}
+
+	for _, r := range opt.rotations {
+		klog.Infof("Checking repository %s/%s with the new password", r.repo.Namespace, r.repo.Name)
+		if err = opt.checkRepository(r, opt.newPassword); err != nil {
+			return opt.rollback(fmt.Errorf("failed to check repository %s/%s: %w", r.repo.Namespace, r.repo.Name, err), true)
+		}
+	}
+
+	// from here on the new password is in use, so a failure is reported without rolling back
+	for _, r := range opt.rotations {
+		if err = opt.removeKey(r, opt.newPassword, r.oldKeyID); err != nil {
+			return fmt.Errorf("the password has been rotated but failed to remove the old key %s from repository %s/%s: %w. Remove it using \"key remove --id=%s\"", r.oldKeyID, r.repo.Namespace, r.repo.Name, err, r.oldKeyID)
+		}
+		klog.Infof("Removed the old key %s from repository %s/%s", r.oldKeyID, r.repo.Namespace, r.repo.Name)
+	}
+	klog.Infof("Password of Secret %s/%s has been rotated successfully", namespace, opt.secret.Name)
+	return nil
+}
+
+// prepareRotation dumps the restic environment of the repository, or finds the pod that mounts its Local
+// backend, and records its current keys.
+func (opt *rotateKeyOptions) prepareRotation(repo *v1alpha1.Repository) (*keyRotation, error) {
+	r := &keyRotation{repo: repo}
+	if repo.Spec.Backend.Local != nil {
+		pod, err := getBackendMountingPod(kubeClient, repo)
+		if err != nil {
+			return nil, err
+		}
+		r.pod = pod
+	} else {
+		setupOpt, err := util.SetupOptionsForRepository(*repo, util.ExtraOptions{
+			StorageSecret: opt.secret,
+			ScratchDir:    opt.scratchDir,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("setup option for repository failed")
+		}
+		resticWrapper, err := restic.NewResticWrapper(setupOpt)
+		if err != nil {
+			return nil, err
+		}
+		r.localDirs = cliLocalDirectories{
+			configDir: filepath.Join(opt.scratchDir, repo.Name, configDirName),
+		}
+		r.caPath = resticWrapper.GetCaPath()
+		if err = resticWrapper.DumpEnv(r.localDirs.configDir, ResticEnvs); err != nil {
+			return nil, err
+		}
+	}
+
+	keys, err := opt.listKeys(r, opt.oldPassword)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the keys of repository %s/%s: %w", repo.Namespace, repo.Name, err)
+	}
+	r.oldKeys = map[string]bool{}
+	for _, k := range keys {
+		r.oldKeys[k.ID] = true
+		if k.Current {
+			r.oldKeyID = k.ID
+		}
+	}
+	if r.oldKeyID == "" {
+		return nil, fmt.Errorf("failed to find the key of the current password in repository %s/%s", repo.Namespace, repo.Name)
+	}
+	return r, nil
+}
+
+func (opt *rotateKeyOptions) addKey(r *keyRotation) error {
+	r.keyAdded = true
+	if r.pod != nil {
+		return opt.addKeyFromPod(r)
+	}
+
+	args := []string{"key", "add", "--no-cache", "--new-password-file", "/dev/stdin"}
+	if r.caPath != "" {
+		args = append(args, "--cacert", r.caPath)
+	}
+	if _, err := opt.runRestic(r, opt.oldPassword, opt.newPassword, args); err != nil {
+		return fmt.Errorf("failed to add the new key to repository %s/%s: %w", r.repo.Namespace, r.repo.Name, err)
+	}
+
+	keys, err := opt.listKeys(r, opt.newPassword)
+	if err != nil {
+		return fmt.Errorf("failed to open repository %s/%s with the new password: %w", r.repo.Namespace, r.repo.Name, err)
+	}
+	for _, k := range keys {
+		if k.Current && !r.oldKeys[k.ID] {
+			klog.Infof("Added key %s to repository %s/%s", k.ID, r.repo.Namespace, r.repo.Name)
+			return nil
+		}
+	}
+	return fmt.Errorf("the new password does not open repository %s/%s with the new key", r.repo.Namespace, r.repo.Name)
+}
+
+// addKeyFromPod adds the new key to a Local repository from the pod that mounts its backend. The pod opens
+// the repository with the password of the storage Secret, so the new key can only be checked to exist here.
+// It is checked to open the repository once the Secret has been updated.
+func (opt *rotateKeyOptions) addKeyFromPod(r *keyRotation) error {
+	command := []string{"/stash-enterprise", "add-key", "--repo-name=" + r.repo.Name, "--repo-namespace=" + r.repo.Namespace, "--new-password-file=/dev/stdin"}
+	if _, err := execCommandOnPodWithStdin(kubeClient, opt.config, r.pod, command, strings.NewReader(opt.newPassword)); err != nil {
+		return fmt.Errorf("failed to add the new key to repository %s/%s: %w", r.repo.Namespace, r.repo.Name, err)
+	}
+
+	keys, err := opt.listKeys(r, opt.oldPassword)
+	if err != nil {
+		return fmt.Errorf("failed to list the keys of repository %s/%s: %w", r.repo.Namespace, r.repo.Name, err)
+	}
+	for _, k := range keys {
+		if !r.oldKeys[k.ID] {
+			klog.Infof("Added key %s to repository %s/%s", k.ID, r.repo.Namespace, r.repo.Name)
+			return nil
+		}
+	}
+	return fmt.Errorf("the new key has not been added to repository %s/%s", r.repo.Namespace, r.repo.Name)
+}
+
+func (opt *rotateKeyOptions) checkRepository(r *keyRotation, password string) error {
+	if r.pod != nil {
+		command := []string{"/stash-enterprise", "check", "--repo-name=" + r.repo.Name, "--repo-namespace=" + r.repo.Namespace}
+		_, err := execCommandOnPod(kubeClient, opt.config, r.pod, command)
+		return err
+	}
+	args := []string{"check", "--no-cache"}
+	if r.caPath != "" {
+		args = append(args, "--cacert", r.caPath)
+	}
+	_, err := opt.runRestic(r, password, "", args)
+	return err
+}
+
+func (opt *rotateKeyOptions) removeKey(r *keyRotation, password, id string) error {
+	if r.pod != nil {
+		command := []string{"/stash-enterprise", "remove-key", "--repo-name=" + r.repo.Name, "--repo-namespace=" + r.repo.Namespace, "--id=" + id}
+		_, err := execCommandOnPod(kubeClient, opt.config, r.pod, command)
+		return err
+	}
+	args := []string{"key", "remove", id, "--no-cache"}
+	if r.caPath != "" {
+		args = append(args, "--cacert", r.caPath)
+	}
+	_, err := opt.runRestic(r, password, "", args)
+	return err
+}
+
+// rollback restores the old password in the Secret, if it has been updated, and removes the keys added to the repositories.
+func (opt *rotateKeyOptions) rollback(cause error, secretPatched bool) error {
+	klog.Infof("Rotation failed: %v. Rolling back.", cause)
+	if secretPatched {
+		if err := patchResticPassword(opt.secret, opt.oldPassword); err != nil {
+			return fmt.Errorf("%v. Failed to restore %s of Secret %s/%s: %v", cause, restic.RESTIC_PASSWORD, namespace, opt.secret.Name, err)
+		}
+	}
+	for _, r := range opt.rotations {
+		if !r.keyAdded {
+			continue
+		}
+		keys, err := opt.listKeys(r, opt.oldPassword)
+		if err != nil {
+			return fmt.Errorf("%v. Failed to list the keys of repository %s/%s: %v", cause, r.repo.Namespace, r.repo.Name, err)
+		}
+		for _, k := range keys {
+			if r.oldKeys[k.ID] {
+				continue
+			}
+			if err = opt.removeKey(r, opt.oldPassword, k.ID); err != nil {
+				return fmt.Errorf("%v. Failed to remove key %s from repository %s/%s: %v", cause, k.ID, r.repo.Namespace, r.repo.Name, err)
+			}
+			klog.Infof("Removed key %s from repository %s/%s", k.ID, r.repo.Namespace, r.repo.Name)
+		}
+	}
+	return cause
+}
+
+// listKeys lists the keys of the repository opened with the password. The keys of a Local repository are
+// listed from the pod that mounts its backend, which opens it with the password of the storage Secret.
+func (opt *rotateKeyOptions) listKeys(r *keyRotation, password string) ([]resticKey, error) {
+	if r.pod != nil {
+		command := []string{"/stash-enterprise", "list-key", "--repo-name=" + r.repo.Name, "--repo-namespace=" + r.repo.Namespace}
+		out, err := execCommandOnPod(kubeClient, opt.config, r.pod, command)
+		if err != nil {
+			return nil, err
+		}
+		return parseKeyTable(out), nil
+	}
+
+	args := []string{"key", "list", "--json", "--no-cache"}
+	if r.caPath != "" {
+		args = append(args, "--cacert", r.caPath)
+	}
+	out, err := opt.runRestic(r, password, "", args)
+	if err != nil {
+		return nil, err
+	}
+	var keys []resticKey
+	if err = json.Unmarshal(out, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse the keys: %w", err)
+	}
+	return keys, nil
+}
+
+// parseKeyTable parses the table printed by "restic key list", where the key of the current password is
+// marked with a "*" before its ID.
+func parseKeyTable(out []byte) []resticKey {
+	var keys []resticKey
+	for _, line := range strings.Split(string(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] == "ID" || strings.HasPrefix(fields[0], "-") {
+			continue
+		}
+		key := resticKey{ID: strings.TrimPrefix(fields[0], "*"), Current: strings.HasPrefix(fields[0], "*")}
+		if len(fields) > 2 {
+			key.UserName, key.HostName = fields[1], fields[2]
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+func repositoriesUsingSecret(secretName string) ([]*v1alpha1.Repository, error) {
+	repoList, err := stashClient.StashV1alpha1().Repositories(namespace).List(context.TODO(), metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var repos []*v1alpha1.Repository
+	for i := range repoList.Items {
+		if repoList.Items[i].Spec.Backend.StorageSecretName == secretName {
+			repos = append(repos, &repoList.Items[i])
+		}
+	}
+	return repos, nil
+}
+
+func patchResticPassword(secret *core.Secret, password string) error {
+	_, _, err := core_util.CreateOrPatchSecret(context.TODO(), kubeClient, secret.ObjectMeta, func(in *core.Secret) *core.Secret {
+		if in.Data == nil {
+			in.Data = map[string][]byte{}
+		}
+		in.Data[restic.RESTIC_PASSWORD] = []byte(password)
+		return in
+	}, metav1.PatchOptions{})
+	return err
+}
+
+func readOrGeneratePassword(file, secretRef string) (string, error) {
+	password, err := readPassword(file, secretRef)
+	if err != nil || password != "" {
+		return password, err
+	}
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf), nil
+}
+
+// runRestic runs restic inside docker with the dumped environment of the repository, overriding its password.
+// The password is passed through the environment of the docker client so that it does not show up in the
+// process list, and the new password, if any, is streamed through stdin.
+func (opt *rotateKeyOptions) runRestic(r *keyRotation, password, newPassword string, args []string) ([]byte, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	dockerArgs := []string{
+		"run",
+		"--rm",
+		"-u", currentUser.Uid,
+		"-v", opt.scratchDir + ":" + opt.scratchDir,
+		"--env", "HTTP_PROXY=" + os.Getenv("HTTP_PROXY"),
+		"--env", "HTTPS_PROXY=" + os.Getenv("HTTPS_PROXY"),
+		"--env-file", filepath.Join(r.localDirs.configDir, ResticEnvs),
+		"--env", restic.RESTIC_PASSWORD,
+	}
+	if newPassword != "" {
+		dockerArgs = append(dockerArgs, "-i")
+	}
+	dockerArgs = append(dockerArgs, imgRestic.ToContainerImage())
+	dockerArgs = append(dockerArgs, args...)
+	klog.Infoln("Running docker with args:", dockerArgs)
+
+	cmd := exec.Command("docker", dockerArgs...)
+	cmd.Env = append(os.Environ(), restic.RESTIC_PASSWORD+"="+password)
+	if newPassword != "" {
+		cmd.Stdin = strings.NewReader(newPassword)
+	}
+	out, err := cmd.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return out, fmt.Errorf("%w: %s", err, string(exitErr.Stderr))
+		}
+		return out, err
+	}
+	return out, nil
+}
@@ -0,0 +1,68 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cronSchedule is a parsed cron expression. It is parsed with the standard parser of the cron library
+// used by the Stash operator: five fields (minute, hour, day of month, month, day of week), an optional
+// CRON_TZ/TZ prefix and the @every/@daily/... descriptors.
+type cronSchedule struct {
+	schedule cron.Schedule
+}
+
+// parseCronSchedule parses the schedule. Unless the schedule has a CRON_TZ/TZ prefix, it is evaluated in
+// UTC, the time zone of the CronJobs created by the operator, instead of the local time zone of the CLI.
+func parseCronSchedule(spec string) (*cronSchedule, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("empty schedule")
+	}
+	if !strings.HasPrefix(spec, "TZ=") && !strings.HasPrefix(spec, "CRON_TZ=") {
+		spec = "CRON_TZ=UTC " + spec
+	}
+	s, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+	return &cronSchedule{schedule: s}, nil
+}
+
+// next returns the first activation time strictly after t.
+// It returns the zero time if the schedule can not be satisfied within five years.
+func (s *cronSchedule) next(t time.Time) time.Time {
+	return s.schedule.Next(t)
+}
+
+// nextN returns the next n activation times after t.
+func (s *cronSchedule) nextN(t time.Time, n int) []time.Time {
+	var times []time.Time
+	for i := 0; i < n; i++ {
+		t = s.next(t)
+		if t.IsZero() {
+			break
+		}
+		times = append(times, t)
+	}
+	return times
+}
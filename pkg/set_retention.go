@@ -0,0 +1,290 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+var setRetentionExample = templates.Examples(`
+		# Keep the last 7 daily and 4 weekly snapshots of a BackupConfiguration
+		stash set retention sample-mongodb-backup --namespace=demo --keep-daily=7 --keep-weekly=4
+
+		# Only show which snapshots would be forgotten by the new retention policy
+		stash set retention sample-mongodb-backup --namespace=demo --keep-last=5 --prune --dry-run`)
+
+type setRetentionOptions struct {
+	retentionPolicy v1alpha1.RetentionPolicy
+	dryRun          bool
+	skipPreview     bool
+	yes             bool
+}
+
+func NewCmdSetRetention() *cobra.Command {
+	opt := setRetentionOptions{}
+	cmd := &cobra.Command{
+		Use:               "retention <backupconfiguration>",
+		Short:             `Update the retention policy of a BackupConfiguration`,
+		Long:              `Update the retention policy of a BackupConfiguration. The given keep rules replace the existing ones. Before applying, the snapshots that would be forgotten by the new policy are listed.`,
+		Example:           setRetentionExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 || args[0] == "" {
+				return fmt.Errorf("BackupConfiguration name not found")
+			}
+			bc, err := stashClient.StashV1beta1().BackupConfigurations(namespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+
+			policy := opt.newRetentionPolicy(cmd, bc.Spec.RetentionPolicy)
+			v := &backupConfigValidator{retention: policy}
+			v.validateRetentionPolicy()
+			if len(v.errs) > 0 {
+				return fmt.Errorf("invalid retention policy: %s", strings.Join(v.errs, ", "))
+			}
+
+			if !opt.skipPreview {
+				if err = previewRetentionPolicy(bc, policy); err != nil {
+					return err
+				}
+			}
+			if opt.dryRun {
+				return nil
+			}
+			if !opt.yes && !confirm(fmt.Sprintf("Update the retention policy of BackupConfiguration %s/%s?", namespace, bc.Name)) {
+				return errAborted
+			}
+
+			_, _, err = v1beta1_util.PatchBackupConfiguration(
+				context.TODO(),
+				stashClient.StashV1beta1(),
+				bc,
+				func(in *v1beta1.BackupConfiguration) *v1beta1.BackupConfiguration {
+					in.Spec.RetentionPolicy = policy
+					return in
+				},
+				metav1.PatchOptions{},
+			)
+			if err != nil {
+				return err
+			}
+			klog.Infof("Retention policy of BackupConfiguration %s/%s has been updated to %s.", namespace, bc.Name, policy.Name)
+			return nil
+		},
+	}
+
+	addRetentionPolicyFlags(cmd, &opt.retentionPolicy)
+	cmd.Flags().BoolVar(&opt.dryRun, "dry-run", opt.dryRun, "Only show which snapshots would be forgotten, don't update the BackupConfiguration")
+	cmd.Flags().BoolVar(&opt.skipPreview, "skip-preview", opt.skipPreview, "Don't list the snapshots that would be forgotten by the new retention policy. Required for the Repositories of the local backend")
+	cmd.Flags().BoolVarP(&opt.yes, "yes", "y", opt.yes, "Don't ask for confirmation")
+
+	cmd.Flags().StringVar(&imgRestic.Registry, "docker-registry", imgRestic.Registry, "Docker image registry for restic cli")
+	cmd.Flags().StringVar(&imgRestic.Tag, "image-tag", imgRestic.Tag, "Restic docker image tag")
+
+	return cmd
+}
+
+// newRetentionPolicy replaces the keep rules of the current policy with the ones given by the flags. The
+// prune and dry-run fields are kept unless they are set explicitly.
+func (opt setRetentionOptions) newRetentionPolicy(cmd *cobra.Command, current v1alpha1.RetentionPolicy) v1alpha1.RetentionPolicy {
+	policy := opt.retentionPolicy
+	if !cmd.Flags().Changed("prune") {
+		policy.Prune = current.Prune
+	}
+	if !cmd.Flags().Changed("retention-dry-run") {
+		policy.DryRun = current.DryRun
+	}
+	if !hasKeepRule(policy) {
+		policy.KeepTags = current.KeepTags
+		policy.KeepLast = current.KeepLast
+		policy.KeepHourly = current.KeepHourly
+		policy.KeepDaily = current.KeepDaily
+		policy.KeepWeekly = current.KeepWeekly
+		policy.KeepMonthly = current.KeepMonthly
+		policy.KeepYearly = current.KeepYearly
+	}
+	policy = getRetentionPolicy(backupConfigOption{retentionPolicy: policy})
+	if policy.Name == "" {
+		policy.Name = current.Name
+	}
+	return policy
+}
+
+func hasKeepRule(p v1alpha1.RetentionPolicy) bool {
+	return p.KeepLast > 0 || p.KeepHourly > 0 || p.KeepDaily > 0 || p.KeepWeekly > 0 || p.KeepMonthly > 0 || p.KeepYearly > 0 || len(p.KeepTags) > 0
+}
+
+// retentionPolicyArgs returns the restic forget flags of the keep rules of the policy.
+func retentionPolicyArgs(p v1alpha1.RetentionPolicy) []string {
+	var args []string
+	rules := []struct {
+		flag  v1alpha1.RetentionStrategy
+		value int64
+	}{
+		{v1alpha1.KeepLast, p.KeepLast},
+		{v1alpha1.KeepHourly, p.KeepHourly},
+		{v1alpha1.KeepDaily, p.KeepDaily},
+		{v1alpha1.KeepWeekly, p.KeepWeekly},
+		{v1alpha1.KeepMonthly, p.KeepMonthly},
+		{v1alpha1.KeepYearly, p.KeepYearly},
+	}
+	for _, r := range rules {
+		if r.value > 0 {
+			args = append(args, string(r.flag), strconv.FormatInt(r.value, 10))
+		}
+	}
+	for _, tag := range p.KeepTags {
+		args = append(args, string(v1alpha1.KeepTag), tag)
+	}
+	return args
+}
+
+// forgetGroup is a group of snapshots of the json output of restic forget.
+type forgetGroup struct {
+	Host   string           `json:"host"`
+	Paths  []string         `json:"paths"`
+	Keep   []forgetSnapshot `json:"keep"`
+	Remove []forgetSnapshot `json:"remove"`
+}
+
+type forgetSnapshot struct {
+	ID       string    `json:"id"`
+	ShortID  string    `json:"short_id"`
+	Time     time.Time `json:"time"`
+	Hostname string    `json:"hostname"`
+	Paths    []string  `json:"paths"`
+}
+
+// previewRetentionPolicy lists the snapshots of the Repository of the BackupConfiguration that would be
+// forgotten by the policy, using restic forget --dry-run.
+func previewRetentionPolicy(bc *v1beta1.BackupConfiguration, policy v1alpha1.RetentionPolicy) error {
+	if !hasKeepRule(policy) {
+		klog.Infoln("Retention policy has no keep rule. No snapshot will be forgotten.")
+		return nil
+	}
+	repoNamespace := bc.Spec.Repository.Namespace
+	if repoNamespace == "" {
+		repoNamespace = bc.Namespace
+	}
+	repo, err := stashClient.StashV1alpha1().Repositories(repoNamespace).Get(context.TODO(), bc.Spec.Repository.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if repo.Spec.Backend.Local != nil {
+		return fmt.Errorf("previewing the retention policy is not supported for the local backend of Repository %s/%s. Use --skip-preview to update the policy without listing the snapshots that would be forgotten", repo.Namespace, repo.Name)
+	}
+
+	groups, err := forgetDryRun(repo, retentionPolicyArgs(policy))
+	if err != nil {
+		return err
+	}
+	printForgetGroups(groups)
+	return nil
+}
+
+// forgetDryRun runs restic forget --dry-run with the given arguments against the repository through docker.
+func forgetDryRun(repo *v1alpha1.Repository, extraArgs []string) ([]forgetGroup, error) {
+	secret, err := kubeClient.CoreV1().Secrets(repo.Namespace).Get(context.TODO(), repo.Spec.Backend.StorageSecretName, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+
+	scratchDir, err := newScratchDir()
+	if err != nil {
+		return nil, err
+	}
+	defer removeScratchDir(scratchDir)
+
+	caPath, err := dumpResticEnv(repo, secret, scratchDir)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"forget", "--dry-run", "--json", "--quiet", "--no-cache"}
+	args = append(args, extraArgs...)
+	if caPath != "" {
+		args = append(args, "--cacert", caPath)
+	}
+	out, err := runResticInDocker(scratchDir, args)
+	if err != nil {
+		return nil, fmt.Errorf("failed to run restic forget: %w", err)
+	}
+	return parseForgetOutput(out)
+}
+
+func parseForgetOutput(out []byte) ([]forgetGroup, error) {
+	out = bytes.TrimSpace(out)
+	if len(out) == 0 {
+		return nil, nil
+	}
+	var groups []forgetGroup
+	if err := json.Unmarshal(out, &groups); err != nil {
+		return nil, fmt.Errorf("failed to parse the output of restic forget: %w", err)
+	}
+	return groups, nil
+}
+
+func printForgetGroups(groups []forgetGroup) {
+	total, removed := 0, 0
+	w := tabwriter.NewWriter(os.Stdout, TableMinWidth, TableTabWidth, TablePadding, TablePadChar, 0)
+	_, _ = fmt.Fprintln(w, "SNAPSHOT\tHOST\tTIME\tPATHS")
+	for _, g := range groups {
+		total += len(g.Keep) + len(g.Remove)
+		removed += len(g.Remove)
+		for _, s := range g.Remove {
+			_, _ = fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", s.ShortID, s.Hostname, s.Time.Format(OutputTimeFormat), strings.Join(s.Paths, ","))
+		}
+	}
+	if removed == 0 {
+		fmt.Printf("No snapshot would be forgotten. %d snapshots would be kept.\n", total)
+		return
+	}
+	fmt.Println("Snapshots that would be forgotten:")
+	_ = w.Flush()
+	fmt.Printf("%d of %d snapshots would be forgotten.\n", removed, total)
+}
+
+// errAborted is returned when the user does not confirm an operation, including when there is no terminal
+// to answer the question, so that scripts notice that nothing has been done.
+var errAborted = errors.New("aborted, nothing has been changed. Use --yes to skip the confirmation")
+
+// confirm asks the question and reports whether the user answered yes.
+func confirm(question string) bool {
+	fmt.Printf("%s (y/N): ", question)
+	var confirmation string
+	_, _ = fmt.Scanln(&confirmation)
+	confirmation = strings.ToLower(strings.TrimSpace(confirmation))
+	return confirmation == "y" || confirmation == "yes"
+}
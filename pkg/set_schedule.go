@@ -0,0 +1,76 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	v1beta1_util "stash.appscode.dev/apimachinery/client/clientset/versioned/typed/stash/v1beta1/util"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+var setScheduleExample = templates.Examples(`
+		# Run the backup of a BackupConfiguration every day at 2 AM
+		stash set schedule sample-mongodb-backup "0 2 * * *" --namespace=demo`)
+
+func NewCmdSetSchedule() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:               "schedule <backupconfiguration> <cron-expression>",
+		Short:             `Update the schedule of a BackupConfiguration`,
+		Example:           setScheduleExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) != 2 || args[0] == "" {
+				return fmt.Errorf("BackupConfiguration name and schedule are required")
+			}
+			schedule := strings.TrimSpace(args[1])
+			v := &backupConfigValidator{schedule: schedule}
+			v.validateSchedule()
+			if len(v.errs) > 0 {
+				return fmt.Errorf("%s", strings.Join(v.errs, ", "))
+			}
+
+			bc, err := stashClient.StashV1beta1().BackupConfigurations(namespace).Get(context.TODO(), args[0], metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			_, _, err = v1beta1_util.PatchBackupConfiguration(
+				context.TODO(),
+				stashClient.StashV1beta1(),
+				bc,
+				func(in *v1beta1.BackupConfiguration) *v1beta1.BackupConfiguration {
+					in.Spec.Schedule = schedule
+					return in
+				},
+				metav1.PatchOptions{},
+			)
+			if err != nil {
+				return err
+			}
+			klog.Infof("Schedule of BackupConfiguration %s/%s has been updated to %q.", namespace, bc.Name, schedule)
+			return nil
+		},
+	}
+	return cmd
+}
@@ -105,3 +105,34 @@ func triggerBackup(backupConfig *v1beta1.BackupConfiguration, client cs.Interfac
 	klog.Infof("BackupSession %s/%s has been created successfully", backupSession.Namespace, backupSession.Name)
 	return backupSession, nil
 }
+
+func triggerBackupBatch(backupBatch *v1beta1.BackupBatch, client cs.Interface) (*v1beta1.BackupSession, error) {
+	backupSession := &v1beta1.BackupSession{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: backupBatch.Name + "-",
+			Namespace:    backupBatch.Namespace,
+			Labels: map[string]string{
+				apis.LabelApp:         apis.AppLabelStash,
+				apis.LabelInvokerType: v1beta1.ResourceKindBackupBatch,
+				apis.LabelInvokerName: backupBatch.Name,
+			},
+		},
+		Spec: v1beta1.BackupSessionSpec{
+			Invoker: v1beta1.BackupInvokerRef{
+				APIGroup: v1beta1.SchemeGroupVersion.Group,
+				Kind:     v1beta1.ResourceKindBackupBatch,
+				Name:     backupBatch.Name,
+			},
+		},
+	}
+
+	owner := metav1.NewControllerRef(backupBatch, v1beta1.SchemeGroupVersion.WithKind(v1beta1.ResourceKindBackupBatch))
+	core_util.EnsureOwnerReference(&backupSession.ObjectMeta, owner)
+
+	backupSession, err := client.StashV1beta1().BackupSessions(backupSession.Namespace).Create(context.TODO(), backupSession, metav1.CreateOptions{})
+	if err != nil {
+		return backupSession, err
+	}
+	klog.Infof("BackupSession %s/%s has been created successfully", backupSession.Namespace, backupSession.Name)
+	return backupSession, nil
+}
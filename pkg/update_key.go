@@ -19,15 +19,14 @@ package pkg
 import (
 	"context"
 	"fmt"
-	"os"
 	"path/filepath"
+	"strings"
 
 	"stash.appscode.dev/apimachinery/pkg/restic"
 	"stash.appscode.dev/stash/pkg/util"
 
 	"github.com/pkg/errors"
 	"github.com/spf13/cobra"
-	"gomodules.xyz/flags"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
 	"k8s.io/klog/v2"
@@ -40,23 +39,16 @@ func NewCmdUpdateKey(clientGetter genericclioptions.RESTClientGetter) *cobra.Com
 		Short:             `Update current key (password) of a restic repository`,
 		DisableAutoGenTag: true,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			flags.EnsureRequiredFlags(cmd, "new-password-file")
-			var err error
-			opt.File, err = filepath.Abs(opt.File)
-			if err != nil {
-				return fmt.Errorf("failed to find the absolute path for password file: %w", err)
-			}
-
-			_, err = os.Stat(opt.File)
-			if os.IsNotExist(err) {
-				return fmt.Errorf("%s does not exist", opt.File)
-			}
-
 			if len(args) == 0 || args[0] == "" {
 				return fmt.Errorf("repository name not found")
 			}
 			repositoryName := args[0]
 
+			var err error
+			if err = opt.readNewPassword(); err != nil {
+				return err
+			}
+
 			opt.config, err = clientGetter.ToRESTConfig()
 			if err != nil {
 				return errors.Wrap(err, "failed to read kubeconfig")
@@ -77,6 +69,7 @@ func NewCmdUpdateKey(clientGetter genericclioptions.RESTClientGetter) *cobra.Com
 	}
 
 	cmd.Flags().StringVar(&opt.File, "new-password-file", opt.File, "File from which to read the new password")
+	cmd.Flags().StringVar(&opt.newPasswordSecret, "new-password-secret", opt.newPasswordSecret, "Secret key, in name/key format, from which to read the new password")
 
 	return cmd
 }
@@ -88,23 +81,15 @@ func (opt *keyOptions) updateResticKeyForLocalRepo() error {
 		return err
 	}
 
-	if err := opt.copyPasswordFileToPod(pod); err != nil {
-		return fmt.Errorf("failed to copy password file from local directory to pod: %w", err)
-	}
-
 	command := []string{"/stash-enterprise", "update-key"}
 	command = append(command, "--repo-name="+opt.repo.Name, "--repo-namespace="+opt.repo.Namespace)
-	command = append(command, "--new-password-file="+getPodDirForPasswordFile())
+	command = append(command, "--new-password-file=/dev/stdin")
 
-	_, err = execCommandOnPod(kubeClient, opt.config, pod, command)
+	_, err = execCommandOnPodWithStdin(kubeClient, opt.config, pod, command, strings.NewReader(opt.newPassword))
 	if err != nil {
 		return err
 	}
 
-	if err := opt.removePasswordFileFromPod(pod); err != nil {
-		return fmt.Errorf("failed to remove password file from pod: %w", err)
-	}
-
 	klog.Infof("Restic key has been updated successfully for repository %s/%s", opt.repo.Namespace, opt.repo.Name)
 	return nil
 }
@@ -116,15 +101,17 @@ func (opt *keyOptions) updateResticKey() error {
 		return err
 	}
 
-	if err = os.MkdirAll(ScratchDir, 0o755); err != nil {
+	// keep the dumped credentials in a private directory of this invocation
+	opt.scratchDir, err = newScratchDir()
+	if err != nil {
 		return err
 	}
-	defer os.RemoveAll(ScratchDir)
+	defer removeScratchDir(opt.scratchDir)
 
 	// configure restic wrapper
 	extraOpt := util.ExtraOptions{
 		StorageSecret: secret,
-		ScratchDir:    ScratchDir,
+		ScratchDir:    opt.scratchDir,
 	}
 	// configure setupOption
 	setupOpt, err := util.SetupOptionsForRepository(*opt.repo, extraOpt)
@@ -138,7 +125,7 @@ func (opt *keyOptions) updateResticKey() error {
 	}
 
 	opt.localDirs = cliLocalDirectories{
-		configDir: filepath.Join(ScratchDir, configDirName),
+		configDir: filepath.Join(opt.scratchDir, configDirName),
 	}
 
 	// dump restic's environments into `restic-env` file.
@@ -20,24 +20,37 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
 	"os/user"
 	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
 	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	stashscheme "stash.appscode.dev/apimachinery/client/clientset/versioned/scheme"
+	"stash.appscode.dev/apimachinery/pkg/restic"
+	"stash.appscode.dev/stash/pkg/util"
 
+	"github.com/spf13/cobra"
 	core "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/cli-runtime/pkg/printers"
 	"k8s.io/client-go/kubernetes"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/remotecommand"
 	"k8s.io/klog/v2"
 	"k8s.io/kube-aggregator/pkg/client/clientset_generated/clientset"
+	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 	"k8s.io/kubectl/pkg/scheme"
 )
 
@@ -76,6 +89,21 @@ func WaitUntilRestoreSessionCompleted(name string, namespace string) error {
 	})
 }
 
+func WaitUntilRestoreBatchCompleted(name string, namespace string) error {
+	return wait.PollUntilContextTimeout(context.Background(), PullInterval, WaitTimeOut, true, func(ctx context.Context) (done bool, err error) {
+		restoreBatch, err := stashClient.StashV1beta1().RestoreBatches(namespace).Get(ctx, name, metav1.GetOptions{})
+		if err == nil {
+			if restoreBatch.Status.Phase == v1beta1.RestoreSucceeded {
+				return true, nil
+			}
+			if restoreBatch.Status.Phase == v1beta1.RestoreFailed {
+				return true, fmt.Errorf("RestoreBatch has been failed")
+			}
+		}
+		return false, nil
+	})
+}
+
 func GetOperatorPod(aggrClient *clientset.Clientset, kubeClient *kubernetes.Clientset) (*core.Pod, error) {
 	apiSvc, err := aggrClient.ApiregistrationV1().APIServices().Get(context.TODO(), "v1alpha1.admission.stash.appscode.com", metav1.GetOptions{})
 	if err != nil {
@@ -107,6 +135,12 @@ func hasStashContainer(pod *core.Pod) bool {
 }
 
 func execCommandOnPod(kubeClient *kubernetes.Clientset, config *rest.Config, pod *core.Pod, command []string) ([]byte, error) {
+	return execCommandOnPodWithStdin(kubeClient, config, pod, command, nil)
+}
+
+// execCommandOnPodWithStdin runs the command on the pod streaming stdin to it. It is used to pass secrets to the
+// command without writing them to the file system of the pod.
+func execCommandOnPodWithStdin(kubeClient *kubernetes.Clientset, config *rest.Config, pod *core.Pod, command []string, stdin io.Reader) ([]byte, error) {
 	var (
 		execOut bytes.Buffer
 		execErr bytes.Buffer
@@ -123,6 +157,7 @@ func execCommandOnPod(kubeClient *kubernetes.Clientset, config *rest.Config, pod
 	req.VersionedParams(&core.PodExecOptions{
 		Container: getContainerName(pod),
 		Command:   command,
+		Stdin:     stdin != nil,
 		Stdout:    true,
 		Stderr:    true,
 	}, scheme.ParameterCodec)
@@ -133,17 +168,74 @@ func execCommandOnPod(kubeClient *kubernetes.Clientset, config *rest.Config, pod
 	}
 
 	err = executor.StreamWithContext(context.Background(), remotecommand.StreamOptions{
+		Stdin:  stdin,
 		Stdout: &execOut,
 		Stderr: &execErr,
-		Tty:    true,
+		Tty:    stdin == nil,
 	})
 	if err != nil {
-		return nil, fmt.Errorf("could not execute: %v, reason: %s", err, execErr.String())
+		// with a tty, the error of the command is written to stdout
+		reason := execErr.String()
+		if reason == "" {
+			reason = execOut.String()
+		}
+		return nil, fmt.Errorf("could not execute: %v, reason: %s", err, reason)
 	}
 
 	return execOut.Bytes(), nil
 }
 
+// enterpriseCommandError explains the error of a stash-enterprise command that the binary in the pod does
+// not provide, which happens when the operator is older than the cli.
+func enterpriseCommandError(subcommand string, pod *core.Pod, err error) error {
+	if err != nil && strings.Contains(err.Error(), "unknown command") {
+		return fmt.Errorf("stash-enterprise in pod %s/%s does not support the %q command. Upgrade the Stash Enterprise operator: %w", pod.Namespace, pod.Name, subcommand, err)
+	}
+	return err
+}
+
+var (
+	scratchDirs     = map[string]bool{}
+	scratchDirsMu   sync.Mutex
+	scratchDirsTrap sync.Once
+)
+
+// newScratchDir creates a private directory, readable only by the current user, to hold the credentials of a
+// single invocation. The caller must defer removeScratchDir so that it is removed on return or panic. The
+// directory is also removed if the process is interrupted.
+func newScratchDir() (string, error) {
+	dir, err := os.MkdirTemp("", "stash-")
+	if err != nil {
+		return "", err
+	}
+	scratchDirsMu.Lock()
+	scratchDirs[dir] = true
+	scratchDirsMu.Unlock()
+
+	scratchDirsTrap.Do(func() {
+		ch := make(chan os.Signal, 1)
+		signal.Notify(ch, os.Interrupt, syscall.SIGTERM)
+		go func() {
+			<-ch
+			scratchDirsMu.Lock()
+			for d := range scratchDirs {
+				_ = os.RemoveAll(d)
+			}
+			os.Exit(130)
+		}()
+	})
+	return dir, nil
+}
+
+func removeScratchDir(dir string) {
+	scratchDirsMu.Lock()
+	delete(scratchDirs, dir)
+	scratchDirsMu.Unlock()
+	if err := os.RemoveAll(dir); err != nil {
+		klog.Warningf("Failed to cleanup scratch directory %s: %v", dir, err)
+	}
+}
+
 func getContainerName(pod *core.Pod) string {
 	if hasStashContainer(pod) {
 		return apis.OperatorContainer
@@ -176,3 +268,119 @@ func runCmdViaDocker(localDirs cliLocalDirectories, command string, extraArgs []
 	klog.Infoln("Output:", string(out))
 	return err
 }
+
+// manifestOption holds the --dry-run and --output flags of the commands that create objects.
+type manifestOption struct {
+	dryRun cmdutil.DryRunStrategy
+	output string
+}
+
+func addManifestFlags(cmd *cobra.Command, opt *manifestOption) {
+	cmdutil.AddDryRunFlag(cmd)
+	cmd.Flags().StringVarP(&opt.output, "output", "o", "yaml", "Output format of the objects in dry-run mode. One of: yaml|json")
+}
+
+func (opt *manifestOption) complete(cmd *cobra.Command) error {
+	var err error
+	opt.dryRun, err = cmdutil.GetDryRunStrategy(cmd)
+	return err
+}
+
+// patchOptions returns the options to create or patch the objects. In server dry-run mode, the objects are
+// validated and defaulted by the API server without being persisted.
+func (opt manifestOption) patchOptions() metav1.PatchOptions {
+	if opt.dryRun == cmdutil.DryRunServer {
+		return metav1.PatchOptions{DryRun: []string{metav1.DryRunAll}}
+	}
+	return metav1.PatchOptions{}
+}
+
+// printManifests writes the objects to stdout in yaml or json format. Multiple objects are printed as separate
+// yaml documents or, as a json List.
+func printManifests(output string, objs ...runtime.Object) error {
+	for _, obj := range objs {
+		if !obj.GetObjectKind().GroupVersionKind().Empty() {
+			continue
+		}
+		gvks, _, err := clientsetscheme.Scheme.ObjectKinds(obj)
+		if err != nil {
+			// the stash types are registered to the client-go scheme only by the root command
+			if gvks, _, err = stashscheme.Scheme.ObjectKinds(obj); err != nil {
+				return err
+			}
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvks[0])
+	}
+
+	switch output {
+	case "yaml":
+		p := &printers.YAMLPrinter{}
+		for _, obj := range objs {
+			if err := p.PrintObj(obj, os.Stdout); err != nil {
+				return err
+			}
+		}
+		return nil
+	case "json":
+		p := &printers.JSONPrinter{}
+		if len(objs) == 1 {
+			return p.PrintObj(objs[0], os.Stdout)
+		}
+		list := &core.List{TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "List"}}
+		for _, obj := range objs {
+			list.Items = append(list.Items, runtime.RawExtension{Object: obj})
+		}
+		return p.PrintObj(list, os.Stdout)
+	}
+	return fmt.Errorf("unsupported output format %q. Supported formats are: yaml, json", output)
+}
+
+// dumpResticEnv writes the restic environment of the repository into the config directory of scratchDir, so
+// that runResticInDocker can pass it to the container. It returns the path of the CA certificate, if any.
+func dumpResticEnv(repo *v1alpha1.Repository, secret *core.Secret, scratchDir string) (string, error) {
+	setupOpt, err := util.SetupOptionsForRepository(*repo, util.ExtraOptions{
+		StorageSecret: secret,
+		ScratchDir:    scratchDir,
+	})
+	if err != nil {
+		return "", fmt.Errorf("setup option for repository failed")
+	}
+	resticWrapper, err := restic.NewResticWrapper(setupOpt)
+	if err != nil {
+		return "", err
+	}
+	if err = resticWrapper.DumpEnv(filepath.Join(scratchDir, configDirName), ResticEnvs); err != nil {
+		return "", err
+	}
+	return resticWrapper.GetCaPath(), nil
+}
+
+// runResticInDocker runs restic with the given arguments inside docker using the environment dumped by
+// dumpResticEnv. It returns the standard output only, so that it can be parsed when restic is run with
+// --json. The standard error is returned as part of the error if restic fails.
+func runResticInDocker(scratchDir string, args []string) ([]byte, error) {
+	currentUser, err := user.Current()
+	if err != nil {
+		return nil, err
+	}
+	dockerArgs := []string{
+		"run",
+		"--rm",
+		"-u", currentUser.Uid,
+		"-v", scratchDir + ":" + scratchDir,
+		"--env", "HTTP_PROXY=" + os.Getenv("HTTP_PROXY"),
+		"--env", "HTTPS_PROXY=" + os.Getenv("HTTPS_PROXY"),
+		"--env-file", filepath.Join(scratchDir, configDirName, ResticEnvs),
+		imgRestic.ToContainerImage(),
+	}
+	dockerArgs = append(dockerArgs, args...)
+	klog.Infoln("Running docker with args:", dockerArgs)
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command("docker", dockerArgs...)
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	if err = cmd.Run(); err != nil {
+		return stdout.Bytes(), fmt.Errorf("%s: %w", strings.TrimSpace(stderr.String()), err)
+	}
+	return stdout.Bytes(), nil
+}
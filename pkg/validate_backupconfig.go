@@ -0,0 +1,300 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis"
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+
+	core "k8s.io/api/core/v1"
+	kerr "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/klog/v2"
+	kmapi "kmodules.xyz/client-go/api/v1"
+	appcatalog "kmodules.xyz/custom-resources/apis/appcatalog/v1alpha1"
+)
+
+// backupConfigValidator collects every problem of a BackupConfiguration or BackupBatch so that they can be
+// fixed at once, instead of being rejected one by one by the webhook or, the operator at runtime.
+type backupConfigValidator struct {
+	kind       string
+	meta       metav1.ObjectMeta
+	schedule   string
+	retention  v1alpha1.RetentionPolicy
+	repository kmapi.ObjectReference
+	driver     v1beta1.Snapshotter
+	members    []v1beta1.BackupConfigurationTemplateSpec
+
+	errs []string
+}
+
+func (v *backupConfigValidator) addError(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	for _, e := range v.errs {
+		if e == msg {
+			return
+		}
+	}
+	v.errs = append(v.errs, msg)
+}
+
+// validateBackupConfiguration checks the schedule, retention policy, volume mounts and paths of the
+// BackupConfiguration. When offline is false, it also checks that the target, Repository and Task exist
+// and, that the volume mounts match the volumes of the target.
+func validateBackupConfiguration(bc *v1beta1.BackupConfiguration, offline bool) error {
+	v := &backupConfigValidator{
+		kind:       v1beta1.ResourceKindBackupConfiguration,
+		meta:       bc.ObjectMeta,
+		schedule:   bc.Spec.Schedule,
+		retention:  bc.Spec.RetentionPolicy,
+		repository: bc.Spec.Repository,
+		driver:     bc.Spec.Driver,
+		members:    []v1beta1.BackupConfigurationTemplateSpec{bc.Spec.BackupConfigurationTemplateSpec},
+	}
+	return v.validate(offline)
+}
+
+// validateBackupBatch runs the checks of validateBackupConfiguration for every member of the BackupBatch.
+func validateBackupBatch(batch *v1beta1.BackupBatch, offline bool) error {
+	v := &backupConfigValidator{
+		kind:       v1beta1.ResourceKindBackupBatch,
+		meta:       batch.ObjectMeta,
+		schedule:   batch.Spec.Schedule,
+		retention:  batch.Spec.RetentionPolicy,
+		repository: batch.Spec.Repository,
+		driver:     batch.Spec.Driver,
+		members:    batch.Spec.Members,
+	}
+	if len(v.members) == 0 {
+		v.addError("no member is provided. Use --member")
+	}
+	return v.validate(offline)
+}
+
+func (v *backupConfigValidator) validate(offline bool) error {
+	v.validateSchedule()
+	v.validateRetentionPolicy()
+	for _, m := range v.members {
+		v.validatePaths(m.Target)
+	}
+	if !offline {
+		for _, m := range v.members {
+			v.validateTarget(m.Target)
+			v.validateTask(m.Task.Name)
+		}
+		v.validateRepository()
+	}
+	if len(v.errs) > 0 {
+		return fmt.Errorf("invalid %s %s/%s:\n  - %s", v.kind, v.meta.Namespace, v.meta.Name, strings.Join(v.errs, "\n  - "))
+	}
+	return nil
+}
+
+func (v *backupConfigValidator) validateSchedule() {
+	if v.schedule == "" {
+		v.addError("schedule is not provided. Use --schedule")
+		return
+	}
+	s, err := parseCronSchedule(v.schedule)
+	if err != nil {
+		v.addError("invalid schedule %q: %v", v.schedule, err)
+		return
+	}
+	runs := s.nextN(time.Now(), 5)
+	if len(runs) == 0 {
+		v.addError("schedule %q never runs", v.schedule)
+		return
+	}
+	next := make([]string, 0, len(runs))
+	for _, t := range runs {
+		next = append(next, t.Format(time.RFC1123))
+	}
+	klog.Infof("Next runs of schedule %q:\n  %s", v.schedule, strings.Join(next, "\n  "))
+}
+
+func (v *backupConfigValidator) validateRetentionPolicy() {
+	p := v.retention
+	if !p.Prune {
+		return
+	}
+	if !hasKeepRule(p) {
+		v.addError("--prune requires at least one keep rule (i.e. --keep-last), otherwise every snapshot would be removed")
+	}
+}
+
+// validatePaths checks that every path is inside one of the volume mounts of the target.
+func (v *backupConfigValidator) validatePaths(target *v1beta1.BackupTarget) {
+	if target == nil || len(target.VolumeMounts) == 0 {
+		return
+	}
+	for _, p := range target.Paths {
+		if !path.IsAbs(p) {
+			v.addError("path %q of %s %s must be an absolute path", p, target.Ref.Kind, target.Ref.Name)
+			continue
+		}
+		if volumeMountOf(path.Clean(p), target.VolumeMounts) == nil {
+			v.addError("path %q of %s %s is not inside any of its volume mounts", p, target.Ref.Kind, target.Ref.Name)
+		}
+	}
+}
+
+func volumeMountOf(p string, mounts []core.VolumeMount) *core.VolumeMount {
+	for i, m := range mounts {
+		mountPath := path.Clean(m.MountPath)
+		if p == mountPath || strings.HasPrefix(p, strings.TrimSuffix(mountPath, "/")+"/") {
+			return &mounts[i]
+		}
+	}
+	return nil
+}
+
+func (v *backupConfigValidator) validateTarget(target *v1beta1.BackupTarget) {
+	if target == nil || target.Ref.Name == "" {
+		v.addError("target is not provided. Use --target-kind and --target-name")
+		return
+	}
+	ref := target.Ref
+	ns := v.meta.Namespace
+
+	podSpec, volumes, err := targetPodSpec(ref, ns)
+	if err == errUnsupportedTargetKind {
+		v.addError("unsupported target kind %q", ref.Kind)
+		return
+	}
+	if err != nil {
+		if kerr.IsNotFound(err) {
+			v.addError("target %s %s/%s not found", ref.Kind, ns, ref.Name)
+		} else {
+			v.addError("failed to get target %s %s/%s: %v", ref.Kind, ns, ref.Name, err)
+		}
+		return
+	}
+	if podSpec == nil {
+		return
+	}
+	for _, m := range target.VolumeMounts {
+		if !volumes.Has(m.Name) {
+			v.addError("volume %q of the volume mounts is not a volume of %s %s. Available volumes are: %s", m.Name, ref.Kind, ref.Name, strings.Join(sets.List(volumes), ", "))
+		}
+	}
+	if v.driver != v1beta1.VolumeSnapshotter && len(target.Paths) > 0 && len(target.VolumeMounts) == 0 {
+		v.addError("paths of %s %s can not be backed up without the volume mounts that hold them", ref.Kind, ref.Name)
+	}
+}
+
+func (v *backupConfigValidator) validateRepository() {
+	if v.driver == v1beta1.VolumeSnapshotter {
+		return
+	}
+	repo := v.repository
+	if repo.Name == "" {
+		v.addError("repository is not provided. Use --repo-name")
+		return
+	}
+	ns := repo.Namespace
+	if ns == "" {
+		ns = v.meta.Namespace
+	}
+	_, err := stashClient.StashV1alpha1().Repositories(ns).Get(context.TODO(), repo.Name, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		v.addError("%s %s/%s not found", v1alpha1.ResourceKindRepository, ns, repo.Name)
+	} else if err != nil {
+		v.addError("failed to get %s %s/%s: %v", v1alpha1.ResourceKindRepository, ns, repo.Name, err)
+	}
+}
+
+func (v *backupConfigValidator) validateTask(task string) {
+	if task == "" {
+		return
+	}
+	_, err := stashClient.StashV1beta1().Tasks().Get(context.TODO(), task, metav1.GetOptions{})
+	if kerr.IsNotFound(err) {
+		v.addError("Task %s not found", task)
+	} else if err != nil {
+		v.addError("failed to get Task %s: %v", task, err)
+	}
+}
+
+var errUnsupportedTargetKind = errors.New("unsupported target kind")
+
+// targetPodSpec gets the target and returns its pod template along with the names of its volumes. The pod
+// template is nil for PVC and AppBinding targets.
+func targetPodSpec(ref v1beta1.TargetRef, ns string) (*core.PodSpec, sets.Set[string], error) {
+	ctx := context.TODO()
+	volumes := sets.New[string]()
+	var spec *core.PodSpec
+	switch ref.Kind {
+	case apis.KindDeployment:
+		obj, err := kubeClient.AppsV1().Deployments(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		spec = &obj.Spec.Template.Spec
+	case apis.KindStatefulSet:
+		obj, err := kubeClient.AppsV1().StatefulSets(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		spec = &obj.Spec.Template.Spec
+		for _, t := range obj.Spec.VolumeClaimTemplates {
+			volumes.Insert(t.Name)
+		}
+	case apis.KindDaemonSet:
+		obj, err := kubeClient.AppsV1().DaemonSets(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		spec = &obj.Spec.Template.Spec
+	case apis.KindReplicaSet:
+		obj, err := kubeClient.AppsV1().ReplicaSets(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		spec = &obj.Spec.Template.Spec
+	case apis.KindReplicationController:
+		obj, err := kubeClient.CoreV1().ReplicationControllers(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		if obj.Spec.Template != nil {
+			spec = &obj.Spec.Template.Spec
+		}
+	case apis.KindPersistentVolumeClaim:
+		_, err := kubeClient.CoreV1().PersistentVolumeClaims(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		return nil, nil, err
+	case apis.KindAppBinding:
+		_, err := dynamicClient.Resource(appcatalog.SchemeGroupVersion.WithResource(appcatalog.ResourceApps)).Namespace(ns).Get(ctx, ref.Name, metav1.GetOptions{})
+		return nil, nil, err
+	default:
+		return nil, nil, errUnsupportedTargetKind
+	}
+	if spec != nil {
+		for _, vol := range spec.Volumes {
+			volumes.Insert(vol.Name)
+		}
+	}
+	return spec, volumes, nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+
+	"gocloud.dev/gcerrors"
+	core "k8s.io/api/core/v1"
+	clientsetscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog/v2"
+	cu "kmodules.xyz/client-go/client"
+	storage "kmodules.xyz/objectstore-api/api/v1"
+	"kmodules.xyz/objectstore-api/pkg/blob"
+)
+
+// verifyBackend lists the prefix of the repository using the credentials of the storage Secret. It returns
+// whether a restic repository already exists there.
+func verifyBackend(cfg *rest.Config, repo *v1alpha1.Repository) (bool, error) {
+	backend := repo.Spec.Backend
+	provider, err := backend.Provider()
+	if err != nil {
+		return false, err
+	}
+	switch provider {
+	case storage.ProviderS3, storage.ProviderGCS, storage.ProviderAzure:
+	default:
+		klog.Infof("Skipping backend verification. It is not supported for the %s provider.", provider)
+		return false, nil
+	}
+
+	bucket, _ := backend.Container()
+	prefix, _ := backend.Prefix()
+	location := fmt.Sprintf("%s bucket %q", provider, bucket)
+	if prefix != "" {
+		location = fmt.Sprintf("%s with prefix %q", location, prefix)
+	}
+
+	c, err := cu.NewUncachedClient(cfg, clientsetscheme.AddToScheme)
+	if err != nil {
+		return false, err
+	}
+	ctx := context.Background()
+	b, err := blob.NewBlob(ctx, c, repo.Namespace, &backend)
+	if err != nil {
+		return false, fmt.Errorf("failed to configure access to %s: %w", location, err)
+	}
+	if _, err = b.ListDirN(ctx, "", 0); err != nil {
+		return false, backendError(location, backend.StorageSecretName, err)
+	}
+	exists, err := b.Exists(ctx, "config")
+	if err != nil {
+		return false, backendError(location, backend.StorageSecretName, err)
+	}
+	klog.Infof("Successfully accessed %s.", location)
+	return exists, nil
+}
+
+func backendError(location, secretName string, err error) error {
+	switch gcerrors.Code(err) {
+	case gcerrors.PermissionDenied:
+		return fmt.Errorf("permission denied on %s. Check that the credentials of Secret %s/%s are allowed to list and read objects: %w", location, namespace, secretName, err)
+	case gcerrors.NotFound:
+		return fmt.Errorf("%s does not exist or, is not visible with the credentials of Secret %s/%s: %w", location, namespace, secretName, err)
+	case gcerrors.InvalidArgument, gcerrors.FailedPrecondition:
+		return fmt.Errorf("invalid backend configuration for %s. Check the endpoint, region and credentials: %w", location, err)
+	case gcerrors.DeadlineExceeded:
+		return fmt.Errorf("timed out while accessing %s. Check the endpoint and network connectivity: %w", location, err)
+	}
+	return fmt.Errorf("failed to access %s: %w", location, err)
+}
+
+// initResticRepository runs "restic init" for the repository inside docker.
+func initResticRepository(repo *v1alpha1.Repository, secret *core.Secret) error {
+	if repo.Spec.Backend.Local != nil {
+		return fmt.Errorf("initializing a repository of the local backend is not supported. It will be initialized by the first backup")
+	}
+
+	// keep the dumped credentials in a private directory of this invocation
+	scratchDir, err := newScratchDir()
+	if err != nil {
+		return err
+	}
+	defer removeScratchDir(scratchDir)
+
+	caPath, err := dumpResticEnv(repo, secret, scratchDir)
+	if err != nil {
+		return err
+	}
+	args := []string{"init", "--no-cache"}
+	if caPath != "" {
+		args = append(args, "--cacert", caPath)
+	}
+	if _, err = runResticInDocker(scratchDir, args); err != nil {
+		if strings.Contains(err.Error(), "already exists") || strings.Contains(err.Error(), "already initialized") {
+			klog.Infof("Restic repository already exists in the backend. Skipping initialization.")
+			return nil
+		}
+		return fmt.Errorf("failed to initialize restic repository: %w", err)
+	}
+	klog.Infof("Restic repository has been initialized in the backend.")
+	return nil
+}
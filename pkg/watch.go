@@ -0,0 +1,401 @@
+/*
+Copyright AppsCode Inc. and Contributors
+
+Licensed under the AppsCode Community License 1.0.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    https://github.com/appscode/licenses/raw/1.0.0/AppsCode-Community-1.0.0.md
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"stash.appscode.dev/apimachinery/apis/stash/v1alpha1"
+	"stash.appscode.dev/apimachinery/apis/stash/v1beta1"
+	cs "stash.appscode.dev/apimachinery/client/clientset/versioned"
+
+	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/kubectl/pkg/util/templates"
+)
+
+const (
+	colorRed   = "\033[31m"
+	colorReset = "\033[0m"
+	clearTerm  = "\033[H\033[2J"
+
+	maxRecentFailures = 10
+)
+
+var watchExample = templates.Examples(`
+		# Watch backup activity of a namespace
+		kubectl stash watch -n demo
+
+		# Watch backup activity of all namespaces and refresh every 5 seconds
+		kubectl stash watch -A --refresh-interval=5s`)
+
+type watchOptions struct {
+	client          cs.Interface
+	namespace       string
+	allNamespaces   bool
+	refreshInterval time.Duration
+
+	backupConfigs   cache.SharedIndexInformer
+	backupBatches   cache.SharedIndexInformer
+	backupSessions  cache.SharedIndexInformer
+	restoreSessions cache.SharedIndexInformer
+	repositories    cache.SharedIndexInformer
+
+	mu       sync.Mutex
+	failures []string
+}
+
+// watchRow represents a backup invoker (BackupConfiguration or BackupBatch) in the dashboard.
+type watchRow struct {
+	namespace  string
+	kind       string
+	name       string
+	schedule   string
+	nextRun    string
+	paused     bool
+	lastPhase  string
+	duration   string
+	repository string
+	size       string
+	snapshots  string
+	failed     bool
+}
+
+func NewCmdWatch(clientGetter genericclioptions.RESTClientGetter) *cobra.Command {
+	opt := watchOptions{
+		refreshInterval: 2 * time.Second,
+	}
+	cmd := &cobra.Command{
+		Use:               "watch",
+		Short:             `Watch backup and restore activity`,
+		Long:              `Show a continuously updating dashboard of BackupConfigurations, BackupBatches, BackupSessions, RestoreSessions and Repositories`,
+		Example:           watchExample,
+		DisableAutoGenTag: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := clientGetter.ToRESTConfig()
+			if err != nil {
+				return errors.Wrap(err, "failed to read kubeconfig")
+			}
+
+			namespace, _, err = clientGetter.ToRawKubeConfigLoader().Namespace()
+			if err != nil {
+				return err
+			}
+
+			stashClient, err = cs.NewForConfig(cfg)
+			if err != nil {
+				return err
+			}
+
+			opt.client = stashClient
+			opt.namespace = namespace
+			if opt.allNamespaces {
+				opt.namespace = metav1.NamespaceAll
+			}
+			return opt.run()
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opt.allNamespaces, "all-namespaces", "A", opt.allNamespaces, "Watch the resources of all namespaces")
+	cmd.Flags().DurationVar(&opt.refreshInterval, "refresh-interval", opt.refreshInterval, "Interval between two refreshes of the dashboard")
+	return cmd
+}
+
+func (opt *watchOptions) run() error {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+
+	opt.setupInformers()
+	informers := []cache.SharedIndexInformer{opt.backupConfigs, opt.backupBatches, opt.backupSessions, opt.restoreSessions, opt.repositories}
+	synced := make([]cache.InformerSynced, 0, len(informers))
+	for _, inf := range informers {
+		go inf.Run(ctx.Done())
+		synced = append(synced, inf.HasSynced)
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), synced...) {
+		return fmt.Errorf("failed to sync informer caches")
+	}
+
+	ticker := time.NewTicker(opt.refreshInterval)
+	defer ticker.Stop()
+	for {
+		opt.render()
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (opt *watchOptions) setupInformers() {
+	ns := opt.namespace
+	c := opt.client
+
+	opt.backupConfigs = newStashInformer(&v1beta1.BackupConfiguration{},
+		func(o metav1.ListOptions) (runtime.Object, error) {
+			return c.StashV1beta1().BackupConfigurations(ns).List(context.TODO(), o)
+		},
+		func(o metav1.ListOptions) (watch.Interface, error) {
+			return c.StashV1beta1().BackupConfigurations(ns).Watch(context.TODO(), o)
+		})
+	opt.backupBatches = newStashInformer(&v1beta1.BackupBatch{},
+		func(o metav1.ListOptions) (runtime.Object, error) {
+			return c.StashV1beta1().BackupBatches(ns).List(context.TODO(), o)
+		},
+		func(o metav1.ListOptions) (watch.Interface, error) {
+			return c.StashV1beta1().BackupBatches(ns).Watch(context.TODO(), o)
+		})
+	opt.backupSessions = newStashInformer(&v1beta1.BackupSession{},
+		func(o metav1.ListOptions) (runtime.Object, error) {
+			return c.StashV1beta1().BackupSessions(ns).List(context.TODO(), o)
+		},
+		func(o metav1.ListOptions) (watch.Interface, error) {
+			return c.StashV1beta1().BackupSessions(ns).Watch(context.TODO(), o)
+		})
+	opt.restoreSessions = newStashInformer(&v1beta1.RestoreSession{},
+		func(o metav1.ListOptions) (runtime.Object, error) {
+			return c.StashV1beta1().RestoreSessions(ns).List(context.TODO(), o)
+		},
+		func(o metav1.ListOptions) (watch.Interface, error) {
+			return c.StashV1beta1().RestoreSessions(ns).Watch(context.TODO(), o)
+		})
+	opt.repositories = newStashInformer(&v1alpha1.Repository{},
+		func(o metav1.ListOptions) (runtime.Object, error) {
+			return c.StashV1alpha1().Repositories(ns).List(context.TODO(), o)
+		},
+		func(o metav1.ListOptions) (watch.Interface, error) {
+			return c.StashV1alpha1().Repositories(ns).Watch(context.TODO(), o)
+		})
+
+	// record failures as they happen so that they stay visible after the session is cleaned up
+	_, _ = opt.backupSessions.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldBS, newBS := oldObj.(*v1beta1.BackupSession), newObj.(*v1beta1.BackupSession)
+			if oldBS.Status.Phase != v1beta1.BackupSessionFailed && newBS.Status.Phase == v1beta1.BackupSessionFailed {
+				opt.recordFailure(fmt.Sprintf("BackupSession %s/%s of %s %s has failed", newBS.Namespace, newBS.Name, newBS.Spec.Invoker.Kind, newBS.Spec.Invoker.Name))
+			}
+		},
+	})
+	_, _ = opt.restoreSessions.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		UpdateFunc: func(oldObj, newObj interface{}) {
+			oldRS, newRS := oldObj.(*v1beta1.RestoreSession), newObj.(*v1beta1.RestoreSession)
+			if oldRS.Status.Phase != v1beta1.RestoreFailed && newRS.Status.Phase == v1beta1.RestoreFailed {
+				opt.recordFailure(fmt.Sprintf("RestoreSession %s/%s has failed", newRS.Namespace, newRS.Name))
+			}
+		},
+	})
+}
+
+func newStashInformer(obj runtime.Object, list cache.ListFunc, watchFn cache.WatchFunc) cache.SharedIndexInformer {
+	return cache.NewSharedIndexInformer(&cache.ListWatch{ListFunc: list, WatchFunc: watchFn}, obj, 0, cache.Indexers{})
+}
+
+func (opt *watchOptions) recordFailure(msg string) {
+	opt.mu.Lock()
+	defer opt.mu.Unlock()
+	opt.failures = append(opt.failures, fmt.Sprintf("%s  %s", time.Now().Format(OutputTimeFormat), msg))
+	if len(opt.failures) > maxRecentFailures {
+		opt.failures = opt.failures[len(opt.failures)-maxRecentFailures:]
+	}
+}
+
+func (opt *watchOptions) render() {
+	var out bytes.Buffer
+	out.WriteString(clearTerm)
+	scope := opt.namespace
+	if scope == metav1.NamespaceAll {
+		scope = "all namespaces"
+	}
+	fmt.Fprintf(&out, "Stash backup activity in %s (refreshed at %s, press Ctrl+C to exit)\n\n", scope, time.Now().Format(OutputTimeFormat))
+
+	rows := opt.backupRows(time.Now())
+	writeHighlightedTable(&out, "NAMESPACE\tKIND\tNAME\tSCHEDULE\tNEXT RUN\tPAUSED\tLAST PHASE\tDURATION\tREPOSITORY\tSIZE\tSNAPSHOTS", len(rows), func(i int) (string, bool) {
+		r := rows[i]
+		return strings.Join([]string{r.namespace, r.kind, r.name, r.schedule, r.nextRun, fmt.Sprintf("%t", r.paused), r.lastPhase, r.duration, r.repository, r.size, r.snapshots}, "\t"), r.failed
+	})
+
+	restores := opt.activeRestoreSessions()
+	if len(restores) > 0 {
+		out.WriteString("\n")
+		writeHighlightedTable(&out, "NAMESPACE\tRESTORESESSION\tPHASE\tDURATION\tAGE", len(restores), func(i int) (string, bool) {
+			rs := restores[i]
+			return strings.Join([]string{rs.Namespace, rs.Name, phaseOrUnknown(string(rs.Status.Phase)), sessionDuration(rs.Status.SessionDuration, rs.CreationTimestamp, rs.Status.Phase == v1beta1.RestoreRunning), formatDuration(time.Since(rs.CreationTimestamp.Time))}, "\t"), rs.Status.Phase == v1beta1.RestoreFailed
+		})
+	}
+
+	opt.mu.Lock()
+	if len(opt.failures) > 0 {
+		out.WriteString("\nRecent failures:\n")
+		for _, f := range opt.failures {
+			fmt.Fprintf(&out, "%s%s%s\n", colorRed, f, colorReset)
+		}
+	}
+	opt.mu.Unlock()
+
+	_, _ = os.Stdout.Write(out.Bytes())
+}
+
+// writeHighlightedTable renders a tab separated table and colors the rows for which row() reports a failure.
+// The color is applied after the columns are aligned, so that the escape sequences do not affect the layout.
+func writeHighlightedTable(out *bytes.Buffer, header string, n int, row func(i int) (string, bool)) {
+	var buf bytes.Buffer
+	w := tabwriter.NewWriter(&buf, TableMinWidth, TableTabWidth, TablePadding, TablePadChar, 0)
+	_, _ = fmt.Fprintln(w, header)
+	failed := make([]bool, n)
+	for i := 0; i < n; i++ {
+		var line string
+		line, failed[i] = row(i)
+		_, _ = fmt.Fprintln(w, line)
+	}
+	_ = w.Flush()
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	for i, line := range lines {
+		if i > 0 && failed[i-1] {
+			line = colorRed + line + colorReset
+		}
+		out.WriteString(line + "\n")
+	}
+}
+
+func (opt *watchOptions) backupRows(now time.Time) []watchRow {
+	sessions := map[string]*v1beta1.BackupSession{}
+	for _, obj := range opt.backupSessions.GetStore().List() {
+		bs := obj.(*v1beta1.BackupSession)
+		key := invokerKey(bs.Namespace, bs.Spec.Invoker.Kind, bs.Spec.Invoker.Name)
+		if cur, ok := sessions[key]; !ok || cur.CreationTimestamp.Before(&bs.CreationTimestamp) {
+			sessions[key] = bs
+		}
+	}
+
+	var rows []watchRow
+	for _, obj := range opt.backupConfigs.GetStore().List() {
+		bc := obj.(*v1beta1.BackupConfiguration)
+		rows = append(rows, opt.newWatchRow(bc.Namespace, v1beta1.ResourceKindBackupConfiguration, bc.Name, bc.Spec.Schedule, bc.Spec.Paused, bc.Spec.Repository.Name, bc.Spec.Repository.Namespace, sessions, now))
+	}
+	for _, obj := range opt.backupBatches.GetStore().List() {
+		bb := obj.(*v1beta1.BackupBatch)
+		rows = append(rows, opt.newWatchRow(bb.Namespace, v1beta1.ResourceKindBackupBatch, bb.Name, bb.Spec.Schedule, bb.Spec.Paused, bb.Spec.Repository.Name, bb.Spec.Repository.Namespace, sessions, now))
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return invokerKey(rows[i].namespace, rows[i].kind, rows[i].name) < invokerKey(rows[j].namespace, rows[j].kind, rows[j].name)
+	})
+	return rows
+}
+
+func (opt *watchOptions) newWatchRow(ns, kind, name, schedule string, paused bool, repoName, repoNamespace string, sessions map[string]*v1beta1.BackupSession, now time.Time) watchRow {
+	row := watchRow{
+		namespace: ns,
+		kind:      kind,
+		name:      name,
+		schedule:  schedule,
+		nextRun:   nextScheduledRun(schedule, paused, now),
+		paused:    paused,
+		lastPhase: "-",
+		duration:  "-",
+		size:      "-",
+		snapshots: "-",
+	}
+	if bs, ok := sessions[invokerKey(ns, kind, name)]; ok {
+		row.lastPhase = phaseOrUnknown(string(bs.Status.Phase))
+		row.duration = sessionDuration(bs.Status.SessionDuration, bs.CreationTimestamp, bs.Status.Phase == v1beta1.BackupSessionRunning)
+		row.failed = bs.Status.Phase == v1beta1.BackupSessionFailed
+	}
+
+	if repoName == "" {
+		row.repository = "-"
+		return row
+	}
+	if repoNamespace == "" {
+		repoNamespace = ns
+	}
+	row.repository = repoNamespace + "/" + repoName
+	if obj, exists, _ := opt.repositories.GetStore().GetByKey(repoNamespace + "/" + repoName); exists {
+		repo := obj.(*v1alpha1.Repository)
+		if repo.Status.TotalSize != "" {
+			row.size = repo.Status.TotalSize
+		}
+		row.snapshots = fmt.Sprintf("%d", repo.Status.SnapshotCount)
+	}
+	return row
+}
+
+func (opt *watchOptions) activeRestoreSessions() []*v1beta1.RestoreSession {
+	var sessions []*v1beta1.RestoreSession
+	for _, obj := range opt.restoreSessions.GetStore().List() {
+		rs := obj.(*v1beta1.RestoreSession)
+		if rs.Status.Phase != v1beta1.RestoreSucceeded {
+			sessions = append(sessions, rs)
+		}
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[j].CreationTimestamp.Before(&sessions[i].CreationTimestamp)
+	})
+	return sessions
+}
+
+func invokerKey(namespace, kind, name string) string {
+	return namespace + "/" + kind + "/" + name
+}
+
+func nextScheduledRun(schedule string, paused bool, now time.Time) string {
+	if paused {
+		return "-"
+	}
+	s, err := parseCronSchedule(schedule)
+	if err != nil {
+		return "invalid"
+	}
+	next := s.next(now)
+	if next.IsZero() {
+		return "never"
+	}
+	return "in " + formatDuration(next.Sub(now))
+}
+
+func sessionDuration(duration string, created metav1.Time, running bool) string {
+	if duration != "" {
+		return duration
+	}
+	if running {
+		return formatDuration(time.Since(created.Time)) + " (running)"
+	}
+	return "-"
+}
+
+func phaseOrUnknown(phase string) string {
+	if phase == "" {
+		return "Unknown"
+	}
+	return phase
+}